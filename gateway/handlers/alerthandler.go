@@ -11,6 +11,7 @@ import (
 	"math"
 	"net/http"
 
+	"github.com/ngduchai/faas/gateway/realtime/specs"
 	"github.com/ngduchai/faas/gateway/requests"
 	"github.com/ngduchai/faas/gateway/scaling"
 )
@@ -76,10 +77,24 @@ func scaleService(alert requests.PrometheusInnerAlert, service scaling.ServiceQu
 
 	if len(serviceName) > 0 {
 		queryResponse, getErr := service.GetReplicas(serviceName)
-		if getErr == nil && queryResponse.Realtime == 0.0 {
+		// Guaranteed functions are sized for their rate by the gateway and
+		// never scaled on alerts. Burstable functions are scaled to serve
+		// the load above their base rate, but not below the replicas
+		// holding their reservation.
+		qos := specs.QoSClass(queryResponse.QoS, queryResponse.Realtime)
+		if getErr == nil && qos != requests.QoSGuaranteed {
 			status := alert.Status
 
-			newReplicas := CalculateReplicas(status, queryResponse.Replicas, uint64(queryResponse.MaxReplicas), queryResponse.MinReplicas, queryResponse.ScalingFactor)
+			minReplicas := queryResponse.MinReplicas
+			if qos == requests.QoSBurstable {
+				if minReplicas < 1 {
+					minReplicas = 1
+				}
+				if queryResponse.Spec != nil && queryResponse.Spec.Replicas > minReplicas {
+					minReplicas = queryResponse.Spec.Replicas
+				}
+			}
+			newReplicas := CalculateReplicas(status, queryResponse.Replicas, uint64(queryResponse.MaxReplicas), minReplicas, queryResponse.ScalingFactor)
 
 			log.Printf("[Scale] function=%s %d => %d.\n", serviceName, queryResponse.Replicas, newReplicas)
 			if newReplicas == queryResponse.Replicas {
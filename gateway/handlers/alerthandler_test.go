@@ -6,6 +6,7 @@ package handlers
 import (
 	"testing"
 
+	"github.com/ngduchai/faas/gateway/requests"
 	"github.com/ngduchai/faas/gateway/scaling"
 )
 
@@ -121,3 +122,85 @@ func TestScaledUpWithSmallParam(t *testing.T) {
 		t.Fail()
 	}
 }
+
+type fakeServiceQuery struct {
+	response scaling.ServiceQueryResponse
+	scaledTo []uint64
+}
+
+func (s *fakeServiceQuery) GetReplicas(service string) (scaling.ServiceQueryResponse, error) {
+	return s.response, nil
+}
+
+func (s *fakeServiceQuery) SetReplicas(service string, count uint64) error {
+	s.scaledTo = append(s.scaledTo, count)
+	return nil
+}
+
+func alertFor(status string) requests.PrometheusInnerAlert {
+	alert := requests.PrometheusInnerAlert{Status: status}
+	alert.Labels.FunctionName = "burt"
+	return alert
+}
+
+func TestScaleSkipsGuaranteed(t *testing.T) {
+	for _, response := range []scaling.ServiceQueryResponse{
+		{Replicas: 1, MaxReplicas: 20, ScalingFactor: 20, Realtime: 5, QoS: requests.QoSGuaranteed},
+		// Functions deployed without a class are guaranteed if they have a rate
+		{Replicas: 1, MaxReplicas: 20, ScalingFactor: 20, Realtime: 5},
+	} {
+		service := &fakeServiceQuery{response: response}
+		if err := scaleService(alertFor("firing"), service); err != nil {
+			t.Logf("Expected err to be nil got: %s", err)
+			t.Fail()
+		}
+		if len(service.scaledTo) != 0 {
+			t.Logf("Expected guaranteed function not to scale, scaled to %v", service.scaledTo)
+			t.Fail()
+		}
+	}
+}
+
+func TestScaleBurstable(t *testing.T) {
+	service := &fakeServiceQuery{response: scaling.ServiceQueryResponse{
+		Replicas: 1, MaxReplicas: 20, ScalingFactor: 20, Realtime: 5, QoS: requests.QoSBurstable,
+	}}
+	scaleService(alertFor("firing"), service)
+	if len(service.scaledTo) != 1 || service.scaledTo[0] != 5 {
+		t.Logf("Expected burstable function to scale to 5, scaled to %v", service.scaledTo)
+		t.Fail()
+	}
+
+	// The replica holding the reservation is kept when the alert resolves
+	service = &fakeServiceQuery{response: scaling.ServiceQueryResponse{
+		Replicas: 5, MaxReplicas: 20, ScalingFactor: 20, Realtime: 5, QoS: requests.QoSBurstable,
+	}}
+	scaleService(alertFor("resolved"), service)
+	if len(service.scaledTo) != 1 || service.scaledTo[0] != 1 {
+		t.Logf("Expected burstable function to back off to 1, scaled to %v", service.scaledTo)
+		t.Fail()
+	}
+}
+
+func TestScaleBurstableKeepsReservedReplicas(t *testing.T) {
+	service := &fakeServiceQuery{response: scaling.ServiceQueryResponse{
+		Replicas: 8, MaxReplicas: 20, ScalingFactor: 20, Realtime: 5, QoS: requests.QoSBurstable,
+		Spec: &requests.ReservationSpec{Replicas: 3},
+	}}
+	scaleService(alertFor("resolved"), service)
+	if len(service.scaledTo) != 1 || service.scaledTo[0] != 3 {
+		t.Logf("Expected burstable function to back off to 3, scaled to %v", service.scaledTo)
+		t.Fail()
+	}
+}
+
+func TestScaleBestEffort(t *testing.T) {
+	service := &fakeServiceQuery{response: scaling.ServiceQueryResponse{
+		Replicas: 5, MaxReplicas: 20, ScalingFactor: 20,
+	}}
+	scaleService(alertFor("resolved"), service)
+	if len(service.scaledTo) != 1 || service.scaledTo[0] != 0 {
+		t.Logf("Expected best-effort function to back off to 0, scaled to %v", service.scaledTo)
+		t.Fail()
+	}
+}
@@ -24,6 +24,8 @@ type Exporter struct {
 	metricOptions MetricOptions
 	services      []requests.Function
 	credentials   *auth.BasicAuthCredentials
+	realtimeStats func() []RealtimeStats
+	concurrency   func() []ConcurrencyStats
 }
 
 // NewExporter creates a new exporter for the OpenFaaS gateway metrics
@@ -35,6 +37,18 @@ func NewExporter(options MetricOptions, credentials *auth.BasicAuthCredentials)
 	}
 }
 
+// SetRealtimeStats sets where the state of realtime functions is read from
+// on every collection
+func (e *Exporter) SetRealtimeStats(stats func() []RealtimeStats) {
+	e.realtimeStats = stats
+}
+
+// SetConcurrencyStats sets where the invocations in flight of every
+// function are read from on every collection
+func (e *Exporter) SetConcurrencyStats(stats func() []ConcurrencyStats) {
+	e.concurrency = stats
+}
+
 // Describe is to describe the metrics for Prometheus
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 
@@ -44,6 +58,24 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 
 	e.metricOptions.ServiceMetrics.Counter.Describe(ch)
 	e.metricOptions.ServiceMetrics.Histogram.Describe(ch)
+
+	realtime := e.metricOptions.RealtimeMetrics
+	realtime.QueueDepth.Describe(ch)
+	realtime.QueueWait.Describe(ch)
+	realtime.Dispatched.Describe(ch)
+	realtime.Opportunistic.Describe(ch)
+	realtime.Rejected.Describe(ch)
+	realtime.GuaranteedRate.Describe(ch)
+	realtime.AchievedRate.Describe(ch)
+	realtime.Deadline.Describe(ch)
+	realtime.LeaseExpired.Describe(ch)
+	realtime.LeaseRemaining.Describe(ch)
+
+	concurrency := e.metricOptions.ConcurrencyMetrics
+	concurrency.InFlight.Describe(ch)
+	concurrency.Queued.Describe(ch)
+	concurrency.Limit.Describe(ch)
+	concurrency.Rejected.Describe(ch)
 }
 
 // Collect collects data to be consumed by prometheus
@@ -62,6 +94,49 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 
 	e.metricOptions.ServiceMetrics.Counter.Collect(ch)
 	e.metricOptions.ServiceMetrics.Histogram.Collect(ch)
+
+	realtime := e.metricOptions.RealtimeMetrics
+	realtime.QueueDepth.Reset()
+	realtime.GuaranteedRate.Reset()
+	realtime.AchievedRate.Reset()
+	realtime.LeaseRemaining.Reset()
+	if e.realtimeStats != nil {
+		for _, stats := range e.realtimeStats() {
+			realtime.QueueDepth.WithLabelValues(stats.Function, "sync").Set(float64(stats.SyncQueue))
+			realtime.QueueDepth.WithLabelValues(stats.Function, "async").Set(float64(stats.AsyncQueue))
+			realtime.GuaranteedRate.WithLabelValues(stats.Function).Set(stats.GuaranteedRate)
+			realtime.AchievedRate.WithLabelValues(stats.Function).Set(stats.AchievedRate)
+			if stats.Leased {
+				realtime.LeaseRemaining.WithLabelValues(stats.Function).Set(stats.LeaseRemaining)
+			}
+		}
+	}
+	realtime.QueueDepth.Collect(ch)
+	realtime.QueueWait.Collect(ch)
+	realtime.Dispatched.Collect(ch)
+	realtime.Opportunistic.Collect(ch)
+	realtime.Rejected.Collect(ch)
+	realtime.GuaranteedRate.Collect(ch)
+	realtime.AchievedRate.Collect(ch)
+	realtime.Deadline.Collect(ch)
+	realtime.LeaseExpired.Collect(ch)
+	realtime.LeaseRemaining.Collect(ch)
+
+	concurrency := e.metricOptions.ConcurrencyMetrics
+	concurrency.InFlight.Reset()
+	concurrency.Queued.Reset()
+	concurrency.Limit.Reset()
+	if e.concurrency != nil {
+		for _, stats := range e.concurrency() {
+			concurrency.InFlight.WithLabelValues(stats.Function).Set(float64(stats.InFlight))
+			concurrency.Queued.WithLabelValues(stats.Function).Set(float64(stats.Queued))
+			concurrency.Limit.WithLabelValues(stats.Function).Set(float64(stats.Limit))
+		}
+	}
+	concurrency.InFlight.Collect(ch)
+	concurrency.Queued.Collect(ch)
+	concurrency.Limit.Collect(ch)
+	concurrency.Rejected.Collect(ch)
 }
 
 // StartServiceWatcher starts a ticker and collects service replica counts to expose to prometheus
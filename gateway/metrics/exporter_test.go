@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/ngduchai/faas/gateway/requests"
@@ -89,3 +90,89 @@ func Test_Collect_CollectsTheNumberOfReplicasOfAService(t *testing.T) {
 		t.Errorf("Want %f, got %f", expectedReplicas, result.value)
 	}
 }
+
+func Test_Collect_CollectsTheStateOfRealtimeFunctions(t *testing.T) {
+	metricsOptions := BuildMetricsOptions()
+	exporter := NewExporter(metricsOptions, nil)
+	exporter.SetRealtimeStats(func() []RealtimeStats {
+		return []RealtimeStats{
+			{Function: "rt", SyncQueue: 3, AsyncQueue: 1, GuaranteedRate: 10, AchievedRate: 9.5, Leased: true, LeaseRemaining: 60},
+		}
+	})
+
+	ch := make(chan prometheus.Metric, 100)
+	exporter.Collect(ch)
+	close(ch)
+
+	found := map[string]float64{}
+	for metric := range ch {
+		m := &dto.Metric{}
+		metric.Write(m)
+		if m.Gauge == nil {
+			continue
+		}
+		labels := labels2Map(m.GetLabel())
+		if labels["function_name"] != "rt" {
+			continue
+		}
+		desc := metric.Desc().String()
+		for _, name := range []string{"gateway_realtime_queue_depth", "gateway_realtime_guaranteed_rate", "gateway_realtime_achieved_rate", "gateway_realtime_lease_remaining_seconds"} {
+			if strings.Contains(desc, `"`+name+`"`) {
+				found[name+labels["queue"]] = m.GetGauge().GetValue()
+			}
+		}
+	}
+
+	want := map[string]float64{
+		"gateway_realtime_queue_depthsync":         3,
+		"gateway_realtime_queue_depthasync":        1,
+		"gateway_realtime_guaranteed_rate":         10,
+		"gateway_realtime_achieved_rate":           9.5,
+		"gateway_realtime_lease_remaining_seconds": 60,
+	}
+	for name, value := range want {
+		if found[name] != value {
+			t.Errorf("%s - want: %f, got %f", name, value, found[name])
+		}
+	}
+}
+
+func Test_Collect_CollectsTheConcurrencyOfFunctions(t *testing.T) {
+	metricsOptions := BuildMetricsOptions()
+	exporter := NewExporter(metricsOptions, nil)
+	exporter.SetConcurrencyStats(func() []ConcurrencyStats {
+		return []ConcurrencyStats{
+			{Function: "limited", InFlight: 8, Queued: 2, Limit: 8},
+		}
+	})
+
+	ch := make(chan prometheus.Metric, 100)
+	exporter.Collect(ch)
+	close(ch)
+
+	found := map[string]float64{}
+	for metric := range ch {
+		m := &dto.Metric{}
+		metric.Write(m)
+		if m.Gauge == nil || labels2Map(m.GetLabel())["function_name"] != "limited" {
+			continue
+		}
+		desc := metric.Desc().String()
+		for _, name := range []string{"gateway_function_in_flight", "gateway_function_concurrency_queue_depth", "gateway_function_concurrency_limit"} {
+			if strings.Contains(desc, `"`+name+`"`) {
+				found[name] = m.GetGauge().GetValue()
+			}
+		}
+	}
+
+	want := map[string]float64{
+		"gateway_function_in_flight":               8,
+		"gateway_function_concurrency_queue_depth": 2,
+		"gateway_function_concurrency_limit":       8,
+	}
+	for name, value := range want {
+		if found[name] != value {
+			t.Errorf("%s - want: %f, got %f", name, value, found[name])
+		}
+	}
+}
@@ -17,6 +17,8 @@ type MetricOptions struct {
 	GatewayFunctionsHistogram *prometheus.HistogramVec
 	ServiceReplicasGauge      *prometheus.GaugeVec
 	ServiceMetrics            *ServiceMetricOptions
+	RealtimeMetrics           *RealtimeMetricOptions
+	ConcurrencyMetrics        *ConcurrencyMetricOptions
 }
 
 // ServiceMetricOptions provides RED metrics
@@ -25,6 +27,52 @@ type ServiceMetricOptions struct {
 	Counter   *prometheus.CounterVec
 }
 
+// RealtimeMetricOptions shows whether the reservations of realtime
+// functions are honoured
+type RealtimeMetricOptions struct {
+	QueueDepth     *prometheus.GaugeVec
+	QueueWait      *prometheus.HistogramVec
+	Dispatched     *prometheus.CounterVec
+	Opportunistic  *prometheus.CounterVec
+	Rejected       *prometheus.CounterVec
+	GuaranteedRate *prometheus.GaugeVec
+	AchievedRate   *prometheus.GaugeVec
+	Deadline       *prometheus.CounterVec
+	LeaseExpired   *prometheus.CounterVec
+	LeaseRemaining *prometheus.GaugeVec
+}
+
+// RealtimeStats is a snapshot of the state of a realtime function
+type RealtimeStats struct {
+	Function       string
+	SyncQueue      int
+	AsyncQueue     int
+	GuaranteedRate float64
+	AchievedRate   float64
+	// Leased is set when the function holds a lease, which expires in
+	// LeaseRemaining seconds
+	Leased         bool
+	LeaseRemaining float64
+}
+
+// ConcurrencyMetricOptions shows how close functions are to the
+// invocations their replicas can serve at once
+type ConcurrencyMetricOptions struct {
+	InFlight *prometheus.GaugeVec
+	Queued   *prometheus.GaugeVec
+	Limit    *prometheus.GaugeVec
+	Rejected *prometheus.CounterVec
+}
+
+// ConcurrencyStats is a snapshot of the invocations of a function in flight
+// and waiting for a free slot
+type ConcurrencyStats struct {
+	Function string
+	InFlight uint64
+	Queued   int
+	Limit    uint64
+}
+
 // Synchronize to make sure MustRegister only called once
 var once = sync.Once{}
 
@@ -63,6 +111,69 @@ func BuildMetricsOptions() MetricOptions {
 		[]string{"function_name"},
 	)
 
+	realtimeMetricOptions := &RealtimeMetricOptions{
+		QueueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "gateway_realtime_queue_depth",
+			Help: "Realtime invocations waiting for a token",
+		}, []string{"function_name", "queue"}),
+		QueueWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "gateway_realtime_queue_wait_seconds",
+			Help:    "Time realtime invocations waited before dispatch",
+			Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30},
+		}, []string{"function_name"}),
+		Dispatched: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_realtime_dispatched_total",
+			Help: "Realtime invocations dispatched to the provider",
+		}, []string{"function_name"}),
+		Opportunistic: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_realtime_opportunistic_total",
+			Help: "Burstable invocations dispatched beyond the guaranteed rate",
+		}, []string{"function_name"}),
+		Rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_realtime_rejected_total",
+			Help: "Realtime invocations turned away by the gateway",
+		}, []string{"function_name", "reason"}),
+		GuaranteedRate: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "gateway_realtime_guaranteed_rate",
+			Help: "Invocations per second reserved for the function",
+		}, []string{"function_name"}),
+		AchievedRate: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "gateway_realtime_achieved_rate",
+			Help: "Invocations per second recently dispatched for the function",
+		}, []string{"function_name"}),
+		Deadline: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_realtime_deadline_total",
+			Help: "Realtime invocations which met (hit) or missed their deadline",
+		}, []string{"function_name", "outcome"}),
+		LeaseExpired: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_realtime_lease_expired_total",
+			Help: "Leases which expired without renewal, by the policy applied",
+		}, []string{"function_name", "policy"}),
+		LeaseRemaining: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "gateway_realtime_lease_remaining_seconds",
+			Help: "Seconds left before the lease of the function expires",
+		}, []string{"function_name"}),
+	}
+
+	concurrencyMetricOptions := &ConcurrencyMetricOptions{
+		InFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "gateway_function_in_flight",
+			Help: "Invocations of the function in flight at the provider",
+		}, []string{"function_name"}),
+		Queued: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "gateway_function_concurrency_queue_depth",
+			Help: "Invocations of the function waiting for a free slot",
+		}, []string{"function_name"}),
+		Limit: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "gateway_function_concurrency_limit",
+			Help: "Invocations of the function its replicas serve at once",
+		}, []string{"function_name"}),
+		Rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_function_concurrency_rejected_total",
+			Help: "Invocations answered 429 beyond the concurrency limit",
+		}, []string{"function_name"}),
+	}
+
 	// For automatic monitoring and alerting (RED method)
 	histogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Subsystem: "http",
@@ -91,6 +202,8 @@ func BuildMetricsOptions() MetricOptions {
 		GatewayFunctionInvocation: gatewayFunctionInvocation,
 		ServiceReplicasGauge:      serviceReplicas,
 		ServiceMetrics:            serviceMetricOptions,
+		RealtimeMetrics:           realtimeMetricOptions,
+		ConcurrencyMetrics:        concurrencyMetricOptions,
 	}
 
 	return metricsOptions
@@ -15,9 +15,11 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/ngduchai/faas/gateway/realtime/specs"
 	"github.com/ngduchai/faas/gateway/requests"
 	"github.com/ngduchai/faas/gateway/scaling"
 	"github.com/openfaas/faas-provider/auth"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 // NewExternalServiceQuery proxies service queries to external plugin via HTTP
@@ -106,6 +108,14 @@ func (s ExternalServiceQuery) GetReplicas(serviceName string) (scaling.ServiceQu
 	cpu := int64(1000)
 	memory := int64(256 * 1024 * 1024)
 	duration := uint64(60)
+	burst := float64(1)
+	qos := ""
+
+	spec, specErr := specs.Read(function.Annotations)
+	if specErr != nil {
+		log.Printf("GetReplicas took: %fs", time.Since(start).Seconds())
+		return emptyServiceQueryResponse, fmt.Errorf("function %s: %s", serviceName, specErr)
+	}
 
 	if function.Labels != nil {
 		labels := *function.Labels
@@ -120,10 +130,32 @@ func (s ExternalServiceQuery) GetReplicas(serviceName string) (scaling.ServiceQu
 			log.Printf("Bad Scaling Factor: %d, is not in range of [0 - 100]. Will fallback to %d", extractedScalingFactor, scalingFactor)
 		}
 
-		realTime = extractLabelRealValue(labels["realtime"], realTime)
-		cpu = int64(extractLabelValue(labels["cpu"], uint64(cpu)))
-		memory = int64(extractLabelValue(labels["memory"], uint64(memory)))
-		duration = extractLabelValue(labels["duration"], duration)
+		if spec == nil {
+			// Deployed before the gateway kept a reservation spec
+			realTime = extractLabelRealValue(labels["realtime"], realTime)
+			cpu = int64(extractLabelValue(labels["cpu"], uint64(cpu)))
+			memory = int64(extractLabelValue(labels["memory"], uint64(memory)))
+			duration = extractLabelValue(labels["duration"], duration)
+		}
+	}
+
+	if spec != nil {
+		cpuQuantity, err := resource.ParseQuantity(spec.CPU)
+		if err != nil {
+			return emptyServiceQueryResponse, fmt.Errorf("function %s: invalid cpu %q in %s", serviceName, spec.CPU, specs.Annotation)
+		}
+		memoryQuantity, err := resource.ParseQuantity(spec.Memory)
+		if err != nil {
+			return emptyServiceQueryResponse, fmt.Errorf("function %s: invalid memory %q in %s", serviceName, spec.Memory, specs.Annotation)
+		}
+		realTime = spec.Realtime
+		cpu = cpuQuantity.MilliValue()
+		memory = memoryQuantity.Value()
+		duration = spec.Timeout
+		if spec.Burst > 0 {
+			burst = spec.Burst
+		}
+		qos = spec.QoS
 	}
 
 	log.Printf("GetReplicas took: %fs", time.Since(start).Seconds())
@@ -138,6 +170,9 @@ func (s ExternalServiceQuery) GetReplicas(serviceName string) (scaling.ServiceQu
 		CPU:               cpu,
 		Memory:            memory,
 		Duration:          duration,
+		Burst:             burst,
+		QoS:               specs.QoSClass(qos, realTime),
+		Spec:              spec,
 	}, err
 }
 
@@ -180,6 +215,52 @@ func (s ExternalServiceQuery) SetReplicas(serviceName string, count uint64) erro
 	return err
 }
 
+// CapacityResponse describes the resources the provider can allocate to functions
+type CapacityResponse struct {
+	CPU    string `json:"cpu"`
+	Memory string `json:"memory"`
+}
+
+// GetCapacity returns the allocatable CPU (millicores) and memory (bytes) reported by the provider
+func (s ExternalServiceQuery) GetCapacity() (int64, int64, error) {
+	urlPath := fmt.Sprintf("%ssystem/capacity", s.URL.String())
+	req, _ := http.NewRequest(http.MethodGet, urlPath, nil)
+
+	if s.Credentials != nil {
+		req.SetBasicAuth(s.Credentials.User, s.Credentials.Password)
+	}
+
+	res, err := s.ProxyClient.Do(req)
+	if err != nil {
+		log.Println(urlPath, err)
+		return 0, 0, err
+	}
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("server returned non-200 status code (%d) for capacity", res.StatusCode)
+	}
+
+	capacity := CapacityResponse{}
+	bytesOut, _ := ioutil.ReadAll(res.Body)
+	if err = json.Unmarshal(bytesOut, &capacity); err != nil {
+		return 0, 0, err
+	}
+
+	cpu, err := resource.ParseQuantity(capacity.CPU)
+	if err != nil {
+		return 0, 0, err
+	}
+	memory, err := resource.ParseQuantity(capacity.Memory)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return cpu.MilliValue(), memory.Value(), nil
+}
+
 // extractLabelValue will parse the provided raw label value and if it fails
 // it will return the provided fallback value and log an message
 func extractLabelValue(rawLabelValue string, fallback uint64) uint64 {
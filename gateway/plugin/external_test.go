@@ -1,12 +1,16 @@
 package plugin
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/ngduchai/faas/gateway/realtime/specs"
+	"github.com/ngduchai/faas/gateway/requests"
 	"github.com/ngduchai/faas/gateway/scaling"
 	"github.com/openfaas/faas-provider/auth"
 )
@@ -78,6 +82,7 @@ func TestGetReplicasExistentFn(t *testing.T) {
 		AvailableReplicas: 0,
 		Realtime:          float64(0),
 		Duration:          uint64(60),
+		QoS:               requests.QoSBestEffort,
 	}
 
 	var creds auth.BasicAuthCredentials
@@ -99,6 +104,7 @@ func TestGetReplicasExistentFn(t *testing.T) {
 		svcQryResp.ScalingFactor != expectedSvcQryResp.ScalingFactor ||
 		svcQryResp.AvailableReplicas != expectedSvcQryResp.AvailableReplicas ||
 		svcQryResp.Realtime != expectedSvcQryResp.Realtime ||
+		svcQryResp.QoS != expectedSvcQryResp.QoS ||
 		//svcQryResp.Duration != expectedSvcQryResp.Duration ||
 		svcQryResp.Duration != expectedSvcQryResp.Duration {
 		//svcQryResp.PastAllocations.Len() != 0 {
@@ -108,6 +114,107 @@ func TestGetReplicasExistentFn(t *testing.T) {
 	}
 }
 
+func TestGetReplicasReadsQoS(t *testing.T) {
+	deployed := requests.CreateFunctionRequest{
+		Service:   "burt",
+		Realtime:  5,
+		Timeout:   100,
+		QoS:       requests.QoSBurstable,
+		Resources: &requests.FunctionResources{CPU: "100m", Memory: "0"},
+	}
+	if err := specs.Write(&deployed); err != nil {
+		t.Fatal(err)
+	}
+	function, _ := json.Marshal(requests.Function{Name: "burt", Annotations: deployed.Annotations})
+
+	testServer := httptest.NewServer(
+		http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			res.WriteHeader(http.StatusOK)
+			if strings.HasSuffix(req.URL.Path, "/legacy") {
+				res.Write([]byte(`{"name":"legacy","labels":{"realtime":"5"}}`))
+				return
+			}
+			res.Write(function)
+		}))
+	defer testServer.Close()
+
+	var creds auth.BasicAuthCredentials
+	url, _ := url.Parse(testServer.URL + "/")
+	esq := NewExternalServiceQuery(*url, &creds)
+
+	svcQryResp, err := esq.GetReplicas("burt")
+	if err != nil {
+		t.Logf("Expected err to be nil got: %s ", err.Error())
+		t.Fail()
+	}
+	if svcQryResp.QoS != requests.QoSBurstable {
+		t.Logf("Wanted QoS %s, got %s", requests.QoSBurstable, svcQryResp.QoS)
+		t.Fail()
+	}
+
+	// Functions deployed without a class are guaranteed if they have a rate
+	svcQryResp, err = esq.GetReplicas("legacy")
+	if err != nil {
+		t.Logf("Expected err to be nil got: %s ", err.Error())
+		t.Fail()
+	}
+	if svcQryResp.QoS != requests.QoSGuaranteed {
+		t.Logf("Wanted QoS %s, got %s", requests.QoSGuaranteed, svcQryResp.QoS)
+		t.Fail()
+	}
+}
+
+func TestGetReplicasReadsReservationSpec(t *testing.T) {
+	deployed := requests.CreateFunctionRequest{
+		Service:   "burt",
+		Realtime:  2.5,
+		Burst:     3,
+		Timeout:   250,
+		Resources: &requests.FunctionResources{CPU: "500m", Memory: "128Mi"},
+		Pool:      "batch",
+		Schedule:  []requests.RealtimeWindow{{Cron: "0 9 * * *", Duration: "1h", Realtime: 5}},
+	}
+	if err := specs.Write(&deployed); err != nil {
+		t.Fatal(err)
+	}
+	function, _ := json.Marshal(requests.Function{Name: "burt", Annotations: deployed.Annotations})
+
+	testServer := httptest.NewServer(
+		http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			res.WriteHeader(http.StatusOK)
+			if strings.HasSuffix(req.URL.Path, "/invalid") {
+				res.Write([]byte(`{"name":"invalid","annotations":{"realtime_spec":"{\"version\":1,\"cpu\":\"fast\",\"memory\":\"0\"}"}}`))
+				return
+			}
+			res.Write(function)
+		}))
+	defer testServer.Close()
+
+	var creds auth.BasicAuthCredentials
+	url, _ := url.Parse(testServer.URL + "/")
+	esq := NewExternalServiceQuery(*url, &creds)
+
+	svcQryResp, err := esq.GetReplicas("burt")
+	if err != nil {
+		t.Logf("Expected err to be nil got: %s ", err.Error())
+		t.FailNow()
+	}
+	if want := specs.Of(deployed); svcQryResp.Spec == nil || !reflect.DeepEqual(*svcQryResp.Spec, want) {
+		t.Logf("Wanted spec %+v, got %+v", want, svcQryResp.Spec)
+		t.Fail()
+	}
+	if svcQryResp.Realtime != 2.5 || svcQryResp.CPU != 500 || svcQryResp.Memory != 128*1024*1024 ||
+		svcQryResp.Duration != 250 || svcQryResp.Burst != 3 || svcQryResp.QoS != requests.QoSGuaranteed {
+		t.Logf("Unexpected realtime parameters %+v", svcQryResp)
+		t.Fail()
+	}
+
+	if _, err := esq.GetReplicas("invalid"); err == nil {
+		t.Logf("Expected an error for an invalid spec, got nil")
+		t.Fail()
+	}
+}
+
 func TestSetReplicasNonExistentFn(t *testing.T) {
 
 	testServer := httptest.NewServer(
@@ -149,3 +256,52 @@ func TestSetReplicasExistentFn(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestGetCapacity(t *testing.T) {
+
+	testServer := httptest.NewServer(
+		http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			res.WriteHeader(http.StatusOK)
+			res.Write([]byte(`{"cpu":"4","memory":"1Gi"}`))
+		}))
+	defer testServer.Close()
+
+	var creds auth.BasicAuthCredentials
+	url, _ := url.Parse(testServer.URL + "/")
+	esq := ExternalServiceQuery{URL: *url, Credentials: &creds}
+
+	cpu, memory, err := esq.GetCapacity()
+
+	if err != nil {
+		t.Logf("Expected err to be nil got: %s ", err.Error())
+		t.Fail()
+	}
+	if cpu != 4000 {
+		t.Logf("Wanted cpu %d, got %d", 4000, cpu)
+		t.Fail()
+	}
+	if memory != 1024*1024*1024 {
+		t.Logf("Wanted memory %d, got %d", 1024*1024*1024, memory)
+		t.Fail()
+	}
+}
+
+func TestGetCapacityNotSupported(t *testing.T) {
+
+	testServer := httptest.NewServer(
+		http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			res.WriteHeader(http.StatusNotFound)
+		}))
+	defer testServer.Close()
+
+	var creds auth.BasicAuthCredentials
+	url, _ := url.Parse(testServer.URL + "/")
+	esq := ExternalServiceQuery{URL: *url, Credentials: &creds}
+
+	_, _, err := esq.GetCapacity()
+
+	if err == nil {
+		t.Logf("Expected err for unsupported capacity endpoint")
+		t.Fail()
+	}
+}
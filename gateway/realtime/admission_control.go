@@ -1,7 +1,9 @@
 package realtime
 
 import (
+	"fmt"
 	"net/http"
+	"sync"
 	"time"
 )
 
@@ -31,3 +33,56 @@ type AdmissionControl interface {
 		timeout time.Duration,
 		writeRequestURI bool) (int, error)
 }
+
+// Names of the admission control strategies shipped with the gateway
+const (
+	AdmissionReserve     = "reserve"
+	AdmissionPassthrough = "passthrough"
+	AdmissionOvercommit  = "overcommit"
+)
+
+// AdmissionOptions holds the configuration handed to admission strategies
+type AdmissionOptions struct {
+	// OverbookingRatio is how far the reservations may exceed the capacity
+	OverbookingRatio float64
+}
+
+// AdmissionFactory builds an admission control strategy
+type AdmissionFactory func(options AdmissionOptions) AdmissionControl
+
+var admissionFactories = map[string]AdmissionFactory{}
+var admissionSync sync.Mutex
+
+// RegisterAdmissionControl makes an admission control strategy available
+// under the given name
+func RegisterAdmissionControl(name string, factory AdmissionFactory) {
+	admissionSync.Lock()
+	defer admissionSync.Unlock()
+
+	admissionFactories[name] = factory
+}
+
+// NewAdmissionControl builds the admission control strategy registered
+// under the given name
+func NewAdmissionControl(name string, options AdmissionOptions) (AdmissionControl, error) {
+	admissionSync.Lock()
+	factory, ok := admissionFactories[name]
+	admissionSync.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown admission control strategy: %s", name)
+	}
+	return factory(options), nil
+}
+
+func init() {
+	RegisterAdmissionControl(AdmissionReserve, func(options AdmissionOptions) AdmissionControl {
+		return ReserveAdmissionControl{}
+	})
+	RegisterAdmissionControl(AdmissionPassthrough, func(options AdmissionOptions) AdmissionControl {
+		return PassthroughAdmissionControl{}
+	})
+	RegisterAdmissionControl(AdmissionOvercommit, func(options AdmissionOptions) AdmissionControl {
+		return OvercommitAdmissionControl{Ratio: options.OverbookingRatio}
+	})
+}
@@ -0,0 +1,293 @@
+package realtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ngduchai/faas/gateway/realtime/specs"
+	"github.com/ngduchai/faas/gateway/requests"
+	"github.com/ngduchai/faas/gateway/scaling"
+)
+
+// ScaleRecordingServiceQuery reports ready replicas and records the scale
+// requests it receives
+type ScaleRecordingServiceQuery struct {
+	ReadyServiceQuery
+	scaled *[]uint64
+	sync   *sync.Mutex
+}
+
+func newScaleRecordingServiceQuery(response scaling.ServiceQueryResponse) ScaleRecordingServiceQuery {
+	return ScaleRecordingServiceQuery{
+		ReadyServiceQuery: ReadyServiceQuery{Response: response},
+		scaled:            &[]uint64{},
+		sync:              &sync.Mutex{},
+	}
+}
+
+func (sq ScaleRecordingServiceQuery) SetReplicas(serviceName string, count uint64) error {
+	sq.sync.Lock()
+	defer sq.sync.Unlock()
+	*sq.scaled = append(*sq.scaled, count)
+	return nil
+}
+
+func (sq ScaleRecordingServiceQuery) scaleRequests() int {
+	sq.sync.Lock()
+	defer sq.sync.Unlock()
+	return len(*sq.scaled)
+}
+
+// admissionProvider accepts every request, keeps the last deployment and
+// the paths invoked with GET
+type admissionProvider struct {
+	server   *httptest.Server
+	deployed requests.CreateFunctionRequest
+	invoked  []string
+	sync     sync.Mutex
+}
+
+func newAdmissionProvider() *admissionProvider {
+	provider := &admissionProvider{}
+	provider.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		provider.sync.Lock()
+		switch r.Method {
+		case http.MethodPost, http.MethodPut:
+			provider.deployed = requests.CreateFunctionRequest{}
+			json.Unmarshal(body, &provider.deployed)
+		case http.MethodGet:
+			if name := strings.TrimPrefix(r.URL.Path, "/system/function/"); name != r.URL.Path {
+				provider.sync.Unlock()
+				provider.report(w, name)
+				return
+			}
+			provider.invoked = append(provider.invoked, r.URL.Path)
+		}
+		provider.sync.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	return provider
+}
+
+// report answers the provider query of the last deployed function
+func (p *admissionProvider) report(w http.ResponseWriter, functionName string) {
+	deployed := p.lastDeployed()
+	if deployed.Service != functionName {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	body, _ := json.Marshal(requests.Function{
+		Name:        deployed.Service,
+		Image:       deployed.Image,
+		EnvProcess:  deployed.EnvProcess,
+		Labels:      deployed.Labels,
+		Annotations: deployed.Annotations,
+	})
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+func (p *admissionProvider) invokedPaths() []string {
+	p.sync.Lock()
+	defer p.sync.Unlock()
+	return append([]string{}, p.invoked...)
+}
+
+func (p *admissionProvider) lastDeployed() requests.CreateFunctionRequest {
+	p.sync.Lock()
+	defer p.sync.Unlock()
+	return p.deployed
+}
+
+// deployedSpec returns the reservation spec the provider was given
+func deployedSpec(deployed requests.CreateFunctionRequest) requests.ReservationSpec {
+	spec, _ := specs.Read(deployed.Annotations)
+	if spec == nil {
+		return requests.ReservationSpec{}
+	}
+	return *spec
+}
+
+func admissionSpec(functionName string, realtime float64) requests.CreateFunctionRequest {
+	return requests.CreateFunctionRequest{
+		Service:   functionName,
+		Image:     "functions/" + functionName + ":latest",
+		Realtime:  realtime,
+		Timeout:   100,
+		Resources: &requests.FunctionResources{CPU: "1", Memory: "0"},
+	}
+}
+
+func deploy(ac AdmissionControl, provider *admissionProvider, method string, spec requests.CreateFunctionRequest) int {
+	body, _ := json.Marshal(spec)
+	r := httptest.NewRequest(method, "/system/functions", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	switch method {
+	case http.MethodPost:
+		ac.Register(w, r, http.DefaultClient, provider.server.URL, "/system/functions", time.Second, false)
+	case http.MethodPut:
+		ac.Update(w, r, http.DefaultClient, provider.server.URL, "/system/functions", time.Second, false)
+	}
+	return w.Code
+}
+
+func undeploy(ac AdmissionControl, provider *admissionProvider, functionName string) int {
+	body, _ := json.Marshal(requests.DeleteFunctionRequest{FunctionName: functionName})
+	r := httptest.NewRequest(http.MethodDelete, "/system/functions", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	ac.Unregister(w, r, http.DefaultClient, provider.server.URL, "/system/functions", time.Second, false)
+	return w.Code
+}
+
+func Test_NewAdmissionControlFromRegistry(t *testing.T) {
+	for _, name := range []string{AdmissionReserve, AdmissionPassthrough, AdmissionOvercommit} {
+		if _, err := NewAdmissionControl(name, AdmissionOptions{OverbookingRatio: 2}); err != nil {
+			t.Errorf("NewAdmissionControl %s - error want: %s, got %s", name, "nil", err.Error())
+			t.Fail()
+		}
+	}
+
+	ac, _ := NewAdmissionControl(AdmissionOvercommit, AdmissionOptions{OverbookingRatio: 2})
+	if overcommit, ok := ac.(OvercommitAdmissionControl); !ok || overcommit.Ratio != 2 {
+		t.Errorf("NewAdmissionControl - want: %+v, got %+v", OvercommitAdmissionControl{Ratio: 2}, ac)
+		t.Fail()
+	}
+
+	if _, err := NewAdmissionControl("unknown", AdmissionOptions{}); err == nil {
+		t.Errorf("NewAdmissionControl unknown - error want: %s, got %s", "error", "nil")
+		t.Fail()
+	}
+
+	RegisterAdmissionControl("custom", func(options AdmissionOptions) AdmissionControl {
+		return PassthroughAdmissionControl{}
+	})
+	if _, err := NewAdmissionControl("custom", AdmissionOptions{}); err != nil {
+		t.Errorf("NewAdmissionControl custom - error want: %s, got %s", "nil", err.Error())
+		t.Fail()
+	}
+}
+
+func Test_ReserveAdmissionControlRejectsBeyondCapacity(t *testing.T) {
+	SetupLedger(1000, 0, PolicyNone)
+	defer SetupLedger(0, 0, PolicyEDF)
+	provider := newAdmissionProvider()
+	defer provider.server.Close()
+	serviceQuery := newScaleRecordingServiceQuery(scaling.ServiceQueryResponse{
+		Replicas: 1, AvailableReplicas: 1, Realtime: 8, CPU: 1000, Duration: 100,
+	})
+	scaling.GetScalerInstance().Config.ServiceQuery = serviceQuery
+
+	ac := ReserveAdmissionControl{}
+	defer RemoveFunctionHandler("reserve-a")
+	defer RemoveFunctionHandler("reserve-b")
+
+	if code := deploy(ac, provider, http.MethodPost, admissionSpec("reserve-a", 8)); code != http.StatusAccepted {
+		t.Errorf("Register reserve-a - status want: %d, got %d", http.StatusAccepted, code)
+		t.Fail()
+	}
+	if code := deploy(ac, provider, http.MethodPost, admissionSpec("reserve-b", 6)); code != http.StatusConflict {
+		t.Errorf("Register reserve-b - status want: %d, got %d", http.StatusConflict, code)
+		t.Fail()
+	}
+	if code := deploy(ac, provider, http.MethodPut, admissionSpec("reserve-a", 10)); code != http.StatusAccepted {
+		t.Errorf("Update reserve-a - status want: %d, got %d", http.StatusAccepted, code)
+		t.Fail()
+	}
+	if serviceQuery.scaleRequests() == 0 {
+		t.Errorf("Update reserve-a - want: scaled, got no scale request")
+		t.Fail()
+	}
+	if cpu, _ := GetLedger().Committed(); cpu != 1000 {
+		t.Errorf("Committed - cpu want: %d, got %d", 1000, cpu)
+		t.Fail()
+	}
+
+	if code := undeploy(ac, provider, "reserve-a"); code != http.StatusAccepted {
+		t.Errorf("Unregister reserve-a - status want: %d, got %d", http.StatusAccepted, code)
+		t.Fail()
+	}
+	if cpu, _ := GetLedger().Committed(); cpu != 0 {
+		t.Errorf("Committed - cpu want: %d, got %d", 0, cpu)
+		t.Fail()
+	}
+}
+
+func Test_RegisterSplitsReservationAcrossReplicas(t *testing.T) {
+	SetupLedger(100000, 0, PolicyNone)
+	defer SetupLedger(0, 0, PolicyEDF)
+	config := &scaling.GetScalerInstance().Config
+	defer func(concurrency uint) { config.ContainerConcurrency = concurrency }(config.ContainerConcurrency)
+	config.ContainerConcurrency = 1
+	provider := newAdmissionProvider()
+	defer provider.server.Close()
+	serviceQuery := newScaleRecordingServiceQuery(scaling.ServiceQueryResponse{
+		Replicas: 4, AvailableReplicas: 4, Realtime: 40, CPU: 1000, Duration: 100,
+	})
+	config.ServiceQuery = serviceQuery
+
+	ac := ReserveAdmissionControl{}
+	// 40 invocations per second of 100ms keep 4 in flight
+	if code := deploy(ac, provider, http.MethodPost, admissionSpec("reserve-split", 40)); code != http.StatusAccepted {
+		t.Errorf("Register - status want: %d, got %d", http.StatusAccepted, code)
+		t.FailNow()
+	}
+	defer undeploy(ac, provider, "reserve-split")
+
+	if serviceQuery.scaleRequests() != 1 || (*serviceQuery.scaled)[0] != 4 {
+		t.Errorf("Register - scaled want: %v, got %v", []uint64{4}, *serviceQuery.scaled)
+		t.Fail()
+	}
+	deployed := provider.lastDeployed()
+	if spec := deployedSpec(deployed); spec.Replicas != 4 {
+		t.Errorf("Register - spec replicas want: %d, got %d", 4, spec.Replicas)
+		t.Fail()
+	}
+	reservation, _ := GetLedger().Get("reserve-split")
+	if want := fmt.Sprintf("%vm", reservation.CPU/4); deployed.Requests.CPU != want {
+		t.Errorf("Register - replica cpu want: %s, got %s", want, deployed.Requests.CPU)
+		t.Fail()
+	}
+}
+
+func Test_ReserveAdmissionControlRejectsConcurrentChange(t *testing.T) {
+	SetupLedger(1000, 0, PolicyNone)
+	defer SetupLedger(0, 0, PolicyEDF)
+	provider := newAdmissionProvider()
+	defer provider.server.Close()
+	scaling.GetScalerInstance().Config.ServiceQuery = newScaleRecordingServiceQuery(scaling.ServiceQueryResponse{
+		Replicas: 1, AvailableReplicas: 1, Realtime: 2, CPU: 1000, Duration: 100,
+	})
+
+	ac := ReserveAdmissionControl{}
+	if code := deploy(ac, provider, http.MethodPost, admissionSpec("reserve-claimed", 2)); code != http.StatusAccepted {
+		t.Errorf("Register - status want: %d, got %d", http.StatusAccepted, code)
+		t.FailNow()
+	}
+	defer undeploy(ac, provider, "reserve-claimed")
+
+	// Another change to the function is in flight
+	claimPending("reserve-claimed")
+	if code := deploy(ac, provider, http.MethodPut, admissionSpec("reserve-claimed", 4)); code != http.StatusConflict {
+		t.Errorf("Update - status want: %d, got %d", http.StatusConflict, code)
+		t.Fail()
+	}
+	if res, _ := GetLedger().Get("reserve-claimed"); res.Task.Rate != 2 {
+		t.Errorf("Update - reserved rate want: %f, got %f", 2.0, res.Task.Rate)
+		t.Fail()
+	}
+	clearPending("reserve-claimed")
+
+	if code := deploy(ac, provider, http.MethodPut, admissionSpec("reserve-claimed", 4)); code != http.StatusAccepted {
+		t.Errorf("Update after the change - status want: %d, got %d", http.StatusAccepted, code)
+		t.Fail()
+	}
+}
@@ -0,0 +1,131 @@
+package realtime
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// backfillPoll bounds how long a borrower waits before looking for idle
+// capacity again, lenders do not notify when they become idle
+const backfillPoll = 100 * time.Millisecond
+
+// SetBackfill enables work-conserving dispatch: best-effort functions of a
+// reservation pool run on the dispatch slots the realtime functions of the
+// pool leave idle
+func (s *Scheduler) SetBackfill(enabled bool) {
+	value := int32(0)
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&s.backfill, value)
+}
+
+// Backfill tells whether best-effort functions borrow idle dispatch slots
+func (s *Scheduler) Backfill() bool {
+	return atomic.LoadInt32(&s.backfill) == 1
+}
+
+// backfills tells whether the handler dispatches on borrowed slots rather
+// than its own token bucket
+func (handler *InvocationHandler) backfills() bool {
+	params := handler.params()
+	return params.Realtime <= 0 && len(params.Pool) > 0 && handler.scheduler.Backfill()
+}
+
+// take uses a dispatch slot, borrowed when the handler backfills. Members
+// of a declared pool dispatch beyond their own rate on their share of the
+// pool and on the slots the other members leave idle.
+func (handler *InvocationHandler) take(now time.Time) bool {
+	if handler.backfills() {
+		return handler.borrow(now)
+	}
+	if handler.Bucket.Take(now) {
+		return true
+	}
+	share := handler.share()
+	if share.bucket != nil && share.bucket.Take(now) {
+		return true
+	}
+	return share.member && handler.borrow(now)
+}
+
+// slotWait returns how long it takes until a dispatch slot is available
+func (handler *InvocationHandler) slotWait(now time.Time) time.Duration {
+	if handler.backfills() {
+		return handler.lendWait(now)
+	}
+	wait := handler.Bucket.Wait(now)
+	share := handler.share()
+	if share.bucket != nil {
+		if shared := share.bucket.Wait(now); shared < wait {
+			wait = shared
+		}
+	}
+	if share.member {
+		if lent := handler.lendWait(now); lent < wait {
+			wait = lent
+		}
+	}
+	return wait
+}
+
+// lenders calls lend with every realtime function of the pool which has
+// nothing waiting, until lend returns false. A lender with pending work
+// keeps its slots to itself.
+func (handler *InvocationHandler) lenders(lend func(lender *InvocationHandler) bool) {
+	pool := handler.params().Pool
+	functionHandlers.Range(func(key interface{}, value interface{}) bool {
+		lender := value.(*InvocationHandler)
+		if lender == handler {
+			return true
+		}
+		if params := lender.params(); params.Realtime <= 0 || params.Pool != pool || !lender.idle() {
+			return true
+		}
+		return lend(lender)
+	})
+}
+
+// idle tells whether nothing is waiting. The count of pending invocations
+// is read rather than the queues: members of a pool look at each other's
+// queue while holding their own, waiting for the lock could deadlock.
+func (handler *InvocationHandler) idle() bool {
+	return atomic.LoadInt32(&handler.queued) == 0
+}
+
+// countQueued publishes how many invocations are pending. The caller holds
+// the lock.
+func (handler *InvocationHandler) countQueued() {
+	atomic.StoreInt32(&handler.queued, int32(len(handler.syncInvs)+len(handler.asyncInvs)))
+}
+
+// borrow takes a spare token from an idle realtime function of the pool
+func (handler *InvocationHandler) borrow(now time.Time) bool {
+	borrowed := false
+	handler.lenders(func(lender *InvocationHandler) bool {
+		borrowed = lender.Bucket.TakeSpare(now)
+		if share := lender.share().bucket; !borrowed && share != nil {
+			borrowed = share.TakeSpare(now)
+		}
+		return !borrowed
+	})
+	return borrowed
+}
+
+// lendWait returns how long it takes until an idle realtime function of the
+// pool has a spare token, bounded by backfillPoll
+func (handler *InvocationHandler) lendWait(now time.Time) time.Duration {
+	wait := backfillPoll
+	handler.lenders(func(lender *InvocationHandler) bool {
+		if spare := lender.Bucket.SpareWait(now); spare < wait {
+			wait = spare
+		}
+		if share := lender.share().bucket; share != nil {
+			if spare := share.SpareWait(now); spare < wait {
+				wait = spare
+			}
+		}
+		return wait > 0
+	})
+	return wait
+}
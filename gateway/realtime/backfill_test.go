@@ -0,0 +1,96 @@
+package realtime
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ngduchai/faas/gateway/requests"
+)
+
+func Test_BackfillBorrowsIdleSlots(t *testing.T) {
+	GetScheduler().SetBackfill(true)
+	defer GetScheduler().SetBackfill(false)
+
+	SetFunctionHandler(requests.CreateFunctionRequest{Service: "backfill-lender", Realtime: 20, Pool: "backfill"})
+	defer RemoveFunctionHandler("backfill-lender")
+	SetFunctionHandler(requests.CreateFunctionRequest{Service: "backfill-borrower", Pool: "backfill", MaxQueueWait: 2000})
+	defer RemoveFunctionHandler("backfill-borrower")
+
+	start := time.Now()
+	called := 0
+	lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodGet, "/function/backfill-borrower", nil)
+			Invoke(func(w http.ResponseWriter, r *http.Request) {
+				lock.Lock()
+				called++
+				lock.Unlock()
+			}, httptest.NewRecorder(), r)
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	if called != 4 {
+		t.Errorf("Invoke - borrowed executions want: %d, got %d", 4, called)
+		t.Fail()
+	}
+	// Spare slots of the lender come at its rate once its bucket is full
+	if elapsed < 150*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Invoke - borrowed slots want: ~%s, got %s", 200*time.Millisecond, elapsed)
+		t.Fail()
+	}
+
+	entry, _ := functionHandlers.Load("backfill-lender")
+	if tokens := entry.(*InvocationHandler).Bucket.Tokens(time.Now()); tokens != 1 {
+		t.Errorf("Tokens - lender want: %v, got %v", 1, tokens)
+		t.Fail()
+	}
+}
+
+func Test_BackfillYieldsToPendingOwner(t *testing.T) {
+	GetScheduler().SetBackfill(true)
+	defer GetScheduler().SetBackfill(false)
+
+	SetFunctionHandler(requests.CreateFunctionRequest{Service: "backfill-owner", Realtime: 5, Pool: "backfill-busy"})
+	defer RemoveFunctionHandler("backfill-owner")
+	SetFunctionHandler(requests.CreateFunctionRequest{Service: "backfill-guest", Pool: "backfill-busy"})
+	defer RemoveFunctionHandler("backfill-guest")
+
+	// Keep the owner busy: its token is used and an invocation waits
+	next := func(w http.ResponseWriter, r *http.Request) {}
+	Invoke(next, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/function/backfill-owner", nil))
+	owner := make(chan bool)
+	go func() {
+		Invoke(next, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/function/backfill-owner", nil))
+		owner <- true
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	guest := 0
+	r := httptest.NewRequest(http.MethodGet, "/function/backfill-guest", nil)
+	r.Header.Set(MaxQueueWaitHeader, "100")
+	w := httptest.NewRecorder()
+	Invoke(func(w http.ResponseWriter, r *http.Request) { guest++ }, w, r)
+	if w.Code != http.StatusServiceUnavailable || guest != 0 {
+		t.Errorf("Invoke - guest want: %d without execution, got %d with %d executions", http.StatusServiceUnavailable, w.Code, guest)
+		t.Fail()
+	}
+	<-owner
+
+	// Without backfill the guest goes straight to the provider
+	GetScheduler().SetBackfill(false)
+	Invoke(func(w http.ResponseWriter, r *http.Request) { guest++ }, httptest.NewRecorder(),
+		httptest.NewRequest(http.MethodGet, "/function/backfill-guest", nil))
+	if guest != 1 {
+		t.Errorf("Invoke - guest without backfill want: %d, got %d", 1, guest)
+		t.Fail()
+	}
+}
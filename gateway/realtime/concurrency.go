@@ -0,0 +1,232 @@
+package realtime
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ngduchai/faas/gateway/metrics"
+	"github.com/ngduchai/faas/gateway/scaling"
+)
+
+// concurrencyLimiters holds the limiter of every function invoked since the
+// gateway started
+var concurrencyLimiters sync.Map
+
+// concurrencyQueueSize bounds the invocations of a function waiting for a
+// free slot, zero turns away every invocation beyond the limit
+var concurrencyQueueSize = 100
+
+// SetConcurrencyQueue sets how many invocations of a function may wait for
+// a free slot before the gateway answers 429
+func SetConcurrencyQueue(size int) {
+	concurrencyQueueSize = size
+}
+
+// ConcurrencyLimiter caps the invocations of a function in flight at the
+// provider. Invocations beyond the limit wait in a bounded FIFO queue for a
+// slot to be released.
+type ConcurrencyLimiter struct {
+	Name     string
+	limit    uint64
+	inFlight uint64
+	waiting  []chan struct{}
+	sync     sync.Mutex
+}
+
+// getConcurrencyLimiter returns the limiter of the function, creating it on
+// first use
+func getConcurrencyLimiter(functionName string) *ConcurrencyLimiter {
+	if entry, ok := concurrencyLimiters.Load(functionName); ok {
+		return entry.(*ConcurrencyLimiter)
+	}
+	entry, _ := concurrencyLimiters.LoadOrStore(functionName, &ConcurrencyLimiter{Name: functionName})
+	return entry.(*ConcurrencyLimiter)
+}
+
+// removeConcurrencyLimiter forgets the limiter of a removed function,
+// invocations in flight keep the slot they hold until they finish
+func removeConcurrencyLimiter(functionName string) {
+	concurrencyLimiters.Delete(functionName)
+}
+
+// grant hands free slots to the oldest waiting invocations. The caller
+// holds the lock.
+func (l *ConcurrencyLimiter) grant() {
+	for len(l.waiting) > 0 && l.inFlight < l.limit {
+		close(l.waiting[0])
+		l.waiting = l.waiting[1:]
+		l.inFlight++
+	}
+}
+
+// SetLimit changes how many invocations may be in flight, waiting
+// invocations are let through if the limit was raised
+func (l *ConcurrencyLimiter) SetLimit(limit uint64) {
+	l.sync.Lock()
+	defer l.sync.Unlock()
+
+	l.limit = limit
+	l.grant()
+}
+
+// Acquire takes a slot for an invocation, waiting in the queue if none is
+// free. It returns false when the queue is full, the caller left or the
+// wait exceeded the budget.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context, queueSize int, budget time.Duration) bool {
+	l.sync.Lock()
+	if len(l.waiting) == 0 && l.inFlight < l.limit {
+		l.inFlight++
+		l.sync.Unlock()
+		return true
+	}
+	if len(l.waiting) >= queueSize {
+		l.sync.Unlock()
+		return false
+	}
+	slot := make(chan struct{})
+	l.waiting = append(l.waiting, slot)
+	l.sync.Unlock()
+
+	var expired <-chan time.Time
+	if budget > 0 {
+		timer := time.NewTimer(budget)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-slot:
+		return true
+	case <-ctx.Done():
+	case <-expired:
+	}
+
+	l.sync.Lock()
+	defer l.sync.Unlock()
+	for i := range l.waiting {
+		if l.waiting[i] == slot {
+			l.waiting = append(l.waiting[:i], l.waiting[i+1:]...)
+			return false
+		}
+	}
+	// The slot was granted while giving up, hand it to the next invocation
+	l.inFlight--
+	l.grant()
+	return false
+}
+
+// Release frees the slot of a finished invocation
+func (l *ConcurrencyLimiter) Release() {
+	l.sync.Lock()
+	defer l.sync.Unlock()
+
+	if l.inFlight > 0 {
+		l.inFlight--
+	}
+	l.grant()
+}
+
+// state returns the invocations in flight and waiting, and the limit
+func (l *ConcurrencyLimiter) state() (uint64, int, uint64) {
+	l.sync.Lock()
+	defer l.sync.Unlock()
+
+	return l.inFlight, len(l.waiting), l.limit
+}
+
+// concurrencyLimit returns how many invocations of the function may be in
+// flight: its replicas times the concurrency of a replica. Zero means the
+// function is not limited, either because no concurrency is configured or
+// the replicas of the function are unknown.
+func concurrencyLimit(functionName string) uint64 {
+	scaler := scaling.GetScalerInstance()
+	concurrency := uint64(scaler.Config.ContainerConcurrency)
+	if concurrency == 0 {
+		return 0
+	}
+	info, hit := scaler.Cache.Get(functionName)
+	if !hit && scaler.Config.ServiceQuery != nil {
+		queried, err := scaler.Config.ServiceQuery.GetReplicas(functionName)
+		if err == nil {
+			scaler.Cache.Set(functionName, queried)
+			info, hit = queried, true
+		} else {
+			log.Printf("Unable to read the replicas of %s: %s", functionName, err)
+			// Keep the limit of the stale entry if there is one
+			hit = info.Replicas > 0
+		}
+	}
+	if !hit {
+		return 0
+	}
+	replicas := info.Replicas
+	if replicas < 1 {
+		// The function is scaled from zero on its first invocation
+		replicas = 1
+	}
+	return replicas * concurrency
+}
+
+// concurrencyBudget returns how long an invocation of the function may wait
+// for a free slot
+func concurrencyBudget(functionName string) time.Duration {
+	if entry, ok := functionHandlers.Load(functionName); ok {
+		return entry.(*InvocationHandler).params().MaxQueueWait
+	}
+	return defaultMaxQueueWait
+}
+
+// MakeConcurrencyLimitHandler caps the invocations of every function in
+// flight at the provider to its replicas times the container concurrency.
+// Invocations beyond the cap wait for a slot, and are answered 429 once the
+// wait queue is full or their queue-wait budget runs out.
+func MakeConcurrencyLimitHandler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName := mux.Vars(r)["name"]
+		limit := concurrencyLimit(functionName)
+		if len(functionName) == 0 || limit == 0 {
+			next(w, r)
+			return
+		}
+		limiter := getConcurrencyLimiter(functionName)
+		limiter.SetLimit(limit)
+		if !limiter.Acquire(r.Context(), concurrencyQueueSize, concurrencyBudget(functionName)) {
+			if r.Context().Err() != nil {
+				log.Printf("Caller of %s left waiting for a free slot\n", functionName)
+				return
+			}
+			recordConcurrencyRejection(functionName)
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(fmt.Sprintf("Concurrency limit of %d reached", limit)))
+			log.Printf("Cannot invoke function %s: concurrency limit of %d reached\n", functionName, limit)
+			return
+		}
+		defer limiter.Release()
+		next(w, r)
+	}
+}
+
+// ConcurrencyStats returns a snapshot of the invocations in flight and
+// waiting for every limited function for the exporter
+func ConcurrencyStats() []metrics.ConcurrencyStats {
+	stats := []metrics.ConcurrencyStats{}
+	concurrencyLimiters.Range(func(key interface{}, value interface{}) bool {
+		limiter := value.(*ConcurrencyLimiter)
+		inFlight, queued, limit := limiter.state()
+		stats = append(stats, metrics.ConcurrencyStats{
+			Function: limiter.Name,
+			InFlight: inFlight,
+			Queued:   queued,
+			Limit:    limit,
+		})
+		return true
+	})
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Function < stats[j].Function })
+	return stats
+}
@@ -0,0 +1,139 @@
+package realtime
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ngduchai/faas/gateway/scaling"
+)
+
+func Test_ConcurrencyLimitQueuesThenRejects(t *testing.T) {
+	functionName := "concurrency-limited"
+	scaler := scaling.GetScalerInstance()
+	defer func(config scaling.ScalingConfig) { scaler.Config = config }(scaler.Config)
+	scaler.Config.ContainerConcurrency = 1
+	scaler.Config.ServiceQuery = ReadyServiceQuery{Response: scaling.ServiceQueryResponse{Replicas: 2, AvailableReplicas: 2}}
+	defer SetConcurrencyQueue(concurrencyQueueSize)
+	SetConcurrencyQueue(1)
+	defer removeConcurrencyLimiter(functionName)
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 3)
+	handler := MakeConcurrencyLimitHandler(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+	invoke := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/function/"+functionName, nil)
+		w := httptest.NewRecorder()
+		handler(w, mux.SetURLVars(r, map[string]string{"name": functionName}))
+		return w
+	}
+
+	// Two replicas serve one invocation each, the third waits
+	responses := make([]*httptest.ResponseRecorder, 3)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = invoke()
+		}(i)
+		<-started
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		responses[2] = invoke()
+	}()
+	for {
+		if _, queued, _ := getConcurrencyLimiter(functionName).state(); queued == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The queue is full, the next invocation is turned away
+	if w := invoke(); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Invoke - status want: %d, got %d", http.StatusTooManyRequests, w.Code)
+		t.Fail()
+	}
+	stats := ConcurrencyStats()
+	if len(stats) != 1 || stats[0].InFlight != 2 || stats[0].Queued != 1 || stats[0].Limit != 2 {
+		t.Errorf("ConcurrencyStats - want: %d in flight, %d queued, got %+v", 2, 1, stats)
+		t.Fail()
+	}
+
+	close(release)
+	wg.Wait()
+	for i, w := range responses {
+		if w.Code != http.StatusOK {
+			t.Errorf("Invoke %d - status want: %d, got %d", i, http.StatusOK, w.Code)
+			t.Fail()
+		}
+	}
+	if inFlight, queued, _ := getConcurrencyLimiter(functionName).state(); inFlight != 0 || queued != 0 {
+		t.Errorf("Release - want: nothing in flight, got %d in flight, %d queued", inFlight, queued)
+		t.Fail()
+	}
+}
+
+func Test_ConcurrencyLimitWaitBudget(t *testing.T) {
+	limiter := &ConcurrencyLimiter{Name: "concurrency-budget"}
+	limiter.SetLimit(1)
+	if !limiter.Acquire(httptest.NewRequest(http.MethodGet, "/", nil).Context(), 1, 0) {
+		t.Errorf("Acquire - want: slot, got none")
+		t.FailNow()
+	}
+	if limiter.Acquire(httptest.NewRequest(http.MethodGet, "/", nil).Context(), 1, 10*time.Millisecond) {
+		t.Errorf("Acquire - want: budget exceeded, got slot")
+		t.Fail()
+	}
+	if _, queued, _ := limiter.state(); queued != 0 {
+		t.Errorf("Acquire - queued want: %d, got %d", 0, queued)
+		t.Fail()
+	}
+
+	// Raising the limit lets the waiting invocation through
+	acquired := make(chan bool)
+	go func() {
+		acquired <- limiter.Acquire(httptest.NewRequest(http.MethodGet, "/", nil).Context(), 1, time.Second)
+	}()
+	for {
+		if _, queued, _ := limiter.state(); queued == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	limiter.SetLimit(2)
+	if !<-acquired {
+		t.Errorf("SetLimit - want: waiting invocation let through, got rejected")
+		t.Fail()
+	}
+}
+
+func Test_ConcurrencyLimitDisabled(t *testing.T) {
+	scaler := scaling.GetScalerInstance()
+	defer func(concurrency uint) { scaler.Config.ContainerConcurrency = concurrency }(scaler.Config.ContainerConcurrency)
+	scaler.Config.ContainerConcurrency = 0
+
+	called := false
+	handler := MakeConcurrencyLimitHandler(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+	r := httptest.NewRequest(http.MethodPost, "/function/concurrency-free", nil)
+	handler(httptest.NewRecorder(), mux.SetURLVars(r, map[string]string{"name": "concurrency-free"}))
+	if !called {
+		t.Errorf("Invoke - want: forwarded, got blocked")
+		t.Fail()
+	}
+	if _, ok := concurrencyLimiters.Load("concurrency-free"); ok {
+		t.Errorf("Invoke - want: no limiter, got one")
+		t.Fail()
+	}
+}
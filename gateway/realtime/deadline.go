@@ -0,0 +1,52 @@
+package realtime
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// DeadlineHeader carries the time after which the result of an invocation
+// is no longer useful. It is either absolute (RFC 3339) or relative to the
+// arrival of the invocation, in ms or as a Go duration such as "1.5s".
+const DeadlineHeader = "X-Deadline"
+
+// parseDeadline returns the absolute deadline given by the header value
+func parseDeadline(value string, now time.Time) (time.Time, error) {
+	if ms, err := strconv.ParseUint(value, 10, 64); err == nil {
+		return now.Add(time.Duration(ms) * time.Millisecond), nil
+	}
+	if relative, err := time.ParseDuration(value); err == nil {
+		if relative <= 0 {
+			return time.Time{}, fmt.Errorf("relative deadline must be positive: %s", value)
+		}
+		return now.Add(relative), nil
+	}
+	deadline, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid deadline %q, want ms, a duration or an RFC 3339 time", value)
+	}
+	return deadline, nil
+}
+
+// earlier tells whether invocation a should be dispatched before b.
+// Invocations without a deadline come after those with one.
+func earlier(a Invocation, b Invocation) bool {
+	if a.deadline.IsZero() {
+		return false
+	}
+	return b.deadline.IsZero() || a.deadline.Before(b.deadline)
+}
+
+// enqueue inserts the invocation in earliest-deadline-first order, keeping
+// arrival order among invocations with the same deadline
+func enqueue(invocations []Invocation, invocation Invocation) []Invocation {
+	i := len(invocations)
+	for i > 0 && earlier(invocation, invocations[i-1]) {
+		i--
+	}
+	invocations = append(invocations, Invocation{})
+	copy(invocations[i+1:], invocations[i:])
+	invocations[i] = invocation
+	return invocations
+}
@@ -0,0 +1,115 @@
+package realtime
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ngduchai/faas/gateway/metrics"
+	"github.com/ngduchai/faas/gateway/requests"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func Test_ParseDeadline(t *testing.T) {
+	now := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		value string
+		want  time.Time
+	}{
+		{"250", now.Add(250 * time.Millisecond)},
+		{"1.5s", now.Add(1500 * time.Millisecond)},
+		{"2019-03-01T12:00:02Z", now.Add(2 * time.Second)},
+	}
+	for _, c := range cases {
+		deadline, err := parseDeadline(c.value, now)
+		if err != nil || !deadline.Equal(c.want) {
+			t.Errorf("parseDeadline %s - want: %s, got %s (%v)", c.value, c.want, deadline, err)
+			t.Fail()
+		}
+	}
+
+	for _, value := range []string{"soon", "-1s"} {
+		if _, err := parseDeadline(value, now); err == nil {
+			t.Errorf("parseDeadline %s - error want: %s, got %s", value, "error", "nil")
+			t.Fail()
+		}
+	}
+}
+
+func Test_EnqueueOrdersEarliestDeadlineFirst(t *testing.T) {
+	now := time.Now()
+	invocations := []Invocation{}
+	for i, offset := range []time.Duration{0, 3 * time.Second, time.Second, 0, 2 * time.Second, time.Second} {
+		invocation := Invocation{done: make(chan bool, 1), r: httptest.NewRequest(http.MethodGet, "/", nil)}
+		invocation.r.Header.Set("X-Order", string(rune('a'+i)))
+		if offset > 0 {
+			invocation.deadline = now.Add(offset)
+		}
+		invocations = enqueue(invocations, invocation)
+	}
+
+	order := ""
+	for _, invocation := range invocations {
+		order += invocation.r.Header.Get("X-Order")
+	}
+	if order != "cfebad" {
+		t.Errorf("enqueue - order want: %s, got %s", "cfebad", order)
+		t.Fail()
+	}
+}
+
+func Test_InvokeRejectsUnmeetableDeadline(t *testing.T) {
+	SetMetrics(metrics.BuildMetricsOptions())
+	defer func() { realtimeMetrics = nil }()
+
+	functionName := "invoke-deadline"
+	SetFunctionHandler(requests.CreateFunctionRequest{
+		Service:  functionName,
+		Realtime: 1,
+		Timeout:  100,
+	})
+	defer RemoveFunctionHandler(functionName)
+
+	called := 0
+	next := func(w http.ResponseWriter, r *http.Request) { called++ }
+
+	r := httptest.NewRequest(http.MethodGet, "/function/"+functionName, nil)
+	r.Header.Set(DeadlineHeader, "500")
+	w := httptest.NewRecorder()
+	Invoke(next, w, r)
+	if w.Code != http.StatusOK || called != 1 {
+		t.Errorf("Invoke - feasible deadline want: %d, got %d (%d calls)", http.StatusOK, w.Code, called)
+		t.Fail()
+	}
+
+	// The next token is a second away, so 500ms cannot be met
+	r = httptest.NewRequest(http.MethodGet, "/function/"+functionName, nil)
+	r.Header.Set(DeadlineHeader, "500")
+	w = httptest.NewRecorder()
+	Invoke(next, w, r)
+	if w.Code != http.StatusGatewayTimeout || called != 1 {
+		t.Errorf("Invoke - unmeetable deadline want: %d, got %d (%d calls)", http.StatusGatewayTimeout, w.Code, called)
+		t.Fail()
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/function/"+functionName, nil)
+	r.Header.Set(DeadlineHeader, "tomorrow")
+	w = httptest.NewRecorder()
+	Invoke(next, w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Invoke - invalid deadline want: %d, got %d", http.StatusBadRequest, w.Code)
+		t.Fail()
+	}
+
+	for outcome, want := range map[string]float64{"hit": 1, "miss": 1} {
+		m := &dto.Metric{}
+		counter := realtimeMetrics.Deadline.WithLabelValues(functionName, outcome)
+		counter.(prometheus.Metric).Write(m)
+		if got := m.GetCounter().GetValue(); got != want {
+			t.Errorf("Invoke - deadline %s want: %v, got %v", outcome, want, got)
+			t.Fail()
+		}
+	}
+}
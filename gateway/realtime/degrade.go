@@ -0,0 +1,376 @@
+package realtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ngduchai/faas/gateway/realtime/specs"
+	"github.com/ngduchai/faas/gateway/requests"
+	"github.com/ngduchai/faas/gateway/scaling"
+)
+
+// degradation is a best-effort function scaled down to make room for
+// realtime functions
+type degradation struct {
+	// Replicas the function had before it was degraded
+	Replicas uint64 `json:"replicas"`
+	// CPU and Memory committed to reservations when it was degraded, the
+	// function is restored once the commitment drops below them
+	CPU    int64 `json:"cpu"`
+	Memory int64 `json:"memory"`
+	// busy is set while the function is being scaled, so that it is
+	// degraded or restored only once
+	busy bool
+}
+
+// Degrader makes room for realtime functions when the cluster is full by
+// scaling best-effort functions down to their minimum replicas, and gives
+// them their replicas back once the pressure subsides. Only the functions
+// in Order are degraded, first to last.
+type Degrader struct {
+	Order  []string
+	Events *EventLog
+	// Dir keeps a file for every degraded function, so that they are
+	// restored after a restart of the gateway. They are only kept in memory
+	// when empty.
+	Dir      string
+	degraded map[string]degradation
+	sync     sync.Mutex
+}
+
+// NewDegrader creates a degrader which degrades the functions in order
+func NewDegrader(order []string, events *EventLog) *Degrader {
+	return &Degrader{
+		Order:    order,
+		Events:   events,
+		degraded: make(map[string]degradation),
+	}
+}
+
+// Enabled tells whether any function may be degraded
+func (d *Degrader) Enabled() bool {
+	return len(d.Order) > 0
+}
+
+// occupancy is what the replicas of a best-effort function hold
+type occupancy struct {
+	CPU    int64
+	Memory int64
+}
+
+// Occupy records the CPU and memory held by the replicas of a best-effort
+// function which may be degraded, zero forgets the function
+func (l *CapacityLedger) Occupy(functionName string, cpu int64, memory int64) {
+	l.sync.Lock()
+	defer l.sync.Unlock()
+
+	if cpu <= 0 && memory <= 0 {
+		delete(l.occupied, functionName)
+		return
+	}
+	l.occupied[functionName] = occupancy{CPU: cpu, Memory: memory}
+}
+
+// Occupied returns the CPU and memory held by best-effort functions which
+// may be degraded
+func (l *CapacityLedger) Occupied() (int64, int64) {
+	l.sync.Lock()
+	defer l.sync.Unlock()
+
+	return l.occupiedTotal()
+}
+
+// occupiedTotal sums what best-effort functions hold. The caller holds the
+// lock.
+func (l *CapacityLedger) occupiedTotal() (int64, int64) {
+	cpu := int64(0)
+	memory := int64(0)
+	for _, held := range l.occupied {
+		cpu += held.CPU
+		memory += held.Memory
+	}
+	return cpu, memory
+}
+
+// Keep keeps the degraded functions in a file each in the directory,
+// creating it if needed, and reads back those degraded before the gateway
+// restarted
+func (d *Degrader) Keep(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	d.sync.Lock()
+	defer d.sync.Unlock()
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		body, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return err
+		}
+		degraded := degradation{}
+		if err := json.Unmarshal(body, &degraded); err != nil {
+			log.Printf("Skip unreadable degradation %s: %s", file.Name(), err)
+			continue
+		}
+		d.degraded[strings.TrimSuffix(file.Name(), ".json")] = degraded
+	}
+	d.Dir = dir
+	return nil
+}
+
+// keep records the degradation of the function in Dir
+func (d *Degrader) keep(name string, degraded degradation) {
+	if len(d.Dir) == 0 {
+		return
+	}
+	body, err := json.Marshal(degraded)
+	if err == nil {
+		err = replaceFile(filepath.Join(d.Dir, name+".json"), body)
+	}
+	if err != nil {
+		log.Printf("Unable to keep the degradation of %s: %s", name, err)
+	}
+}
+
+// Track records what the replicas of the functions which may be degraded
+// hold in the ledger, so that realtime functions are admitted against the
+// capacity they leave
+func (d *Degrader) Track() {
+	query := scaling.GetScalerInstance().Config.ServiceQuery
+	ledger := GetLedger()
+	for _, name := range d.Order {
+		info, err := query.GetReplicas(name)
+		if err != nil {
+			ledger.Occupy(name, 0, 0)
+			continue
+		}
+		occupy(name, info, info.Replicas)
+	}
+}
+
+// occupy records in the ledger what the given replicas of the function hold
+func occupy(name string, info scaling.ServiceQueryResponse, replicas uint64) {
+	if specs.QoSClass(info.QoS, info.Realtime) != requests.QoSBestEffort {
+		GetLedger().Occupy(name, 0, 0)
+		return
+	}
+	GetLedger().Occupy(name, int64(replicas)*info.CPU, int64(replicas)*info.Memory)
+}
+
+// MakeRoom degrades best-effort functions one at a time, in order, until
+// ready reports the replicas of the realtime function are available. It
+// returns false if they are still not once every function is degraded.
+func (d *Degrader) MakeRoom(functionName string, ready func() bool) bool {
+	for _, name := range d.Order {
+		if name == functionName || !d.degrade(name, functionName, time.Now()) {
+			continue
+		}
+		if ready() {
+			return true
+		}
+	}
+	return false
+}
+
+// degrade scales the function down to its minimum replicas, it returns
+// false if the function could not give any replica up
+func (d *Degrader) degrade(name string, beneficiary string, now time.Time) bool {
+	if !d.claim(name) {
+		return false
+	}
+	degraded := false
+	defer func() {
+		if !degraded {
+			d.forget(name)
+		}
+	}()
+
+	query := scaling.GetScalerInstance().Config.ServiceQuery
+	info, err := query.GetReplicas(name)
+	if err != nil {
+		log.Printf("Unable to degrade %s: %s", name, err)
+		return false
+	}
+	if specs.QoSClass(info.QoS, info.Realtime) != requests.QoSBestEffort || info.Replicas <= info.MinReplicas {
+		return false
+	}
+	event := requests.RealtimeEvent{
+		Action:           requests.EventDegrade,
+		Function:         name,
+		PreviousReplicas: info.Replicas,
+		Replicas:         info.MinReplicas,
+		Reason:           fmt.Sprintf("make room for realtime function %s", beneficiary),
+	}
+	if err := query.SetReplicas(name, info.MinReplicas); err != nil {
+		event.Action = requests.EventDegradeFailed
+		event.Replicas = info.Replicas
+		event.Reason += ": " + err.Error()
+		d.Events.Record(event, now)
+		return false
+	}
+
+	occupy(name, info, info.MinReplicas)
+	cpu, memory := GetLedger().Committed()
+	done := degradation{Replicas: info.Replicas, CPU: cpu, Memory: memory}
+	d.sync.Lock()
+	d.degraded[name] = done
+	d.sync.Unlock()
+	d.keep(name, done)
+	degraded = true
+	d.Events.Record(event, now)
+	return true
+}
+
+// claim marks the function as being degraded, it returns false if it
+// already is or is being changed through the gateway
+func (d *Degrader) claim(name string) bool {
+	d.sync.Lock()
+	defer d.sync.Unlock()
+	if _, done := d.degraded[name]; done || isPending(name) {
+		return false
+	}
+	d.degraded[name] = degradation{busy: true}
+	return true
+}
+
+// Restore gives their replicas back to the degraded functions, last
+// degraded first, once less is committed to reservations than when they
+// were degraded. A function which cannot be scaled is tried again on the
+// next round.
+func (d *Degrader) Restore(now time.Time) {
+	cpu, memory := GetLedger().Committed()
+	query := scaling.GetScalerInstance().Config.ServiceQuery
+	for i := len(d.Order) - 1; i >= 0; i-- {
+		name := d.Order[i]
+		degraded, ok := d.claimRestore(name, cpu, memory)
+		if !ok {
+			continue
+		}
+
+		event := requests.RealtimeEvent{
+			Action:   requests.EventRestore,
+			Function: name,
+			Replicas: degraded.Replicas,
+			Reason:   "realtime pressure subsided",
+		}
+		info, err := query.GetReplicas(name)
+		if err == nil {
+			event.PreviousReplicas = info.Replicas
+			err = query.SetReplicas(name, degraded.Replicas)
+		}
+		if err != nil {
+			event.Action = requests.EventDegradeFailed
+			event.Replicas = event.PreviousReplicas
+			event.Reason += ": " + err.Error()
+			d.Events.Record(event, now)
+			d.sync.Lock()
+			d.degraded[name] = degraded
+			d.sync.Unlock()
+			continue
+		}
+		occupy(name, info, degraded.Replicas)
+		d.forget(name)
+		d.Events.Record(event, now)
+	}
+}
+
+// claimRestore marks the degraded function as being restored if less is
+// committed than when it was degraded, and returns its degradation
+func (d *Degrader) claimRestore(name string, cpu int64, memory int64) (degradation, bool) {
+	d.sync.Lock()
+	defer d.sync.Unlock()
+	degraded, ok := d.degraded[name]
+	if !ok || degraded.busy || (cpu >= degraded.CPU && memory >= degraded.Memory) {
+		return degraded, false
+	}
+	busy := degraded
+	busy.busy = true
+	d.degraded[name] = busy
+	return degraded, true
+}
+
+// Degraded tells whether the function was scaled down, or is being, to
+// make room for realtime functions
+func (d *Degrader) Degraded(name string) bool {
+	d.sync.Lock()
+	defer d.sync.Unlock()
+	_, degraded := d.degraded[name]
+	return degraded
+}
+
+// forget drops the degradation of a function
+func (d *Degrader) forget(name string) {
+	d.sync.Lock()
+	delete(d.degraded, name)
+	d.sync.Unlock()
+
+	if len(d.Dir) == 0 {
+		return
+	}
+	if err := os.Remove(filepath.Join(d.Dir, name+".json")); err != nil && !os.IsNotExist(err) {
+		log.Printf("Unable to forget the degradation of %s: %s", name, err)
+	}
+}
+
+// Start tracks what the functions which may be degraded hold and looks for
+// degraded functions to restore at the given interval
+func (d *Degrader) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			d.Track()
+			d.Restore(time.Now())
+			<-ticker.C
+		}
+	}()
+}
+
+// DegradeAwareServiceQuery leaves the replicas of degraded functions alone,
+// so that the alert-driven autoscaler does not scale them back up before
+// the degrader restores them
+type DegradeAwareServiceQuery struct {
+	scaling.ServiceQuery
+}
+
+// SetReplicas scales the function unless it is degraded
+func (q DegradeAwareServiceQuery) SetReplicas(serviceName string, count uint64) error {
+	if GetDegrader().Degraded(serviceName) {
+		log.Printf("Function %s is degraded, leave its replicas to the degrader", serviceName)
+		return nil
+	}
+	return q.ServiceQuery.SetReplicas(serviceName, count)
+}
+
+var degraderInstance *Degrader
+var degraderOnce sync.Once
+
+// SetupDegrader sets the best-effort functions degraded to make room for
+// realtime functions, in order
+func SetupDegrader(order []string) {
+	degraderInstance = NewDegrader(order, GetEventLog())
+}
+
+// GetDegrader returns the degrader shared by the gateway, it degrades no
+// function unless set up
+func GetDegrader() *Degrader {
+	degraderOnce.Do(func() {
+		if degraderInstance == nil {
+			SetupDegrader(nil)
+		}
+	})
+	return degraderInstance
+}
@@ -0,0 +1,225 @@
+package realtime
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ngduchai/faas/gateway/requests"
+	"github.com/ngduchai/faas/gateway/scaling"
+)
+
+func degradeServiceQuery() TestServiceQuery {
+	return TestServiceQuery{Info: map[string]*scaling.ServiceQueryResponse{
+		"degrade-guaranteed": {Replicas: 4, MinReplicas: 1, Realtime: 2},
+		"degrade-idle":       {Replicas: 1, MinReplicas: 1},
+		"degrade-batch":      {Replicas: 5, MinReplicas: 1},
+		"degrade-thumbnails": {Replicas: 3, MinReplicas: 1},
+	}}
+}
+
+func Test_DegraderMakesRoomInOrder(t *testing.T) {
+	scaler := scaling.GetScalerInstance()
+	defer func(query scaling.ServiceQuery) { scaler.Config.ServiceQuery = query }(scaler.Config.ServiceQuery)
+	serviceQuery := degradeServiceQuery()
+	scaler.Config.ServiceQuery = serviceQuery
+	SetupLedger(10000, 0, PolicyNone)
+	defer SetupLedger(0, 0, PolicyEDF)
+	GetLedger().Reserve("degrade-rt", Reservation{CPU: 2000, Task: Task{Rate: 10, Budget: 100, CPU: 2000}})
+
+	events := NewEventLog(10)
+	degrader := NewDegrader([]string{"degrade-guaranteed", "degrade-idle", "degrade-batch", "degrade-thumbnails"}, events)
+	checks := 0
+	ready := func() bool {
+		checks++
+		return true
+	}
+	if !degrader.MakeRoom("degrade-rt", ready) {
+		t.Errorf("MakeRoom - want: room made, got none")
+		t.FailNow()
+	}
+	// Guaranteed functions and functions at their minimum are left alone
+	for name, want := range map[string]uint64{"degrade-guaranteed": 4, "degrade-idle": 1, "degrade-batch": 1, "degrade-thumbnails": 3} {
+		if got := serviceQuery.Info[name].Replicas; got != want {
+			t.Errorf("MakeRoom - %s replicas want: %d, got %d", name, want, got)
+			t.Fail()
+		}
+	}
+	if checks != 1 {
+		t.Errorf("MakeRoom - readiness checks want: %d, got %d", 1, checks)
+		t.Fail()
+	}
+
+	// Pressure has not subsided while the reservation is held
+	degrader.Restore(time.Now())
+	if got := serviceQuery.Info["degrade-batch"].Replicas; got != 1 {
+		t.Errorf("Restore - replicas want: %d, got %d", 1, got)
+		t.Fail()
+	}
+
+	GetLedger().Release("degrade-rt")
+	degrader.Restore(time.Now())
+	if got := serviceQuery.Info["degrade-batch"].Replicas; got != 5 {
+		t.Errorf("Restore - replicas want: %d, got %d", 5, got)
+		t.Fail()
+	}
+
+	logged := events.Events()
+	if len(logged) != 2 {
+		t.Errorf("Events - want: %d, got %+v", 2, logged)
+		t.FailNow()
+	}
+	want := []requests.RealtimeEvent{
+		{Action: requests.EventDegrade, Function: "degrade-batch", PreviousReplicas: 5, Replicas: 1},
+		{Action: requests.EventRestore, Function: "degrade-batch", PreviousReplicas: 1, Replicas: 5},
+	}
+	for i, event := range logged {
+		if event.Action != want[i].Action || event.Function != want[i].Function ||
+			event.PreviousReplicas != want[i].PreviousReplicas || event.Replicas != want[i].Replicas || len(event.Time) == 0 {
+			t.Errorf("Events - want: %+v, got %+v", want[i], event)
+			t.Fail()
+		}
+	}
+}
+
+func Test_DegraderRunsOutOfFunctions(t *testing.T) {
+	scaler := scaling.GetScalerInstance()
+	defer func(query scaling.ServiceQuery) { scaler.Config.ServiceQuery = query }(scaler.Config.ServiceQuery)
+	serviceQuery := degradeServiceQuery()
+	scaler.Config.ServiceQuery = serviceQuery
+
+	events := NewEventLog(10)
+	degrader := NewDegrader([]string{"degrade-batch", "degrade-missing", "degrade-thumbnails"}, events)
+	if degrader.MakeRoom("degrade-rt", func() bool { return false }) {
+		t.Errorf("MakeRoom - want: no room, got room made")
+		t.Fail()
+	}
+	if batch, thumbnails := serviceQuery.Info["degrade-batch"].Replicas, serviceQuery.Info["degrade-thumbnails"].Replicas; batch != 1 || thumbnails != 1 {
+		t.Errorf("MakeRoom - replicas want: %d %d, got %d %d", 1, 1, batch, thumbnails)
+		t.Fail()
+	}
+	// A degraded function is not degraded twice
+	if degrader.MakeRoom("degrade-rt", func() bool { return true }) {
+		t.Errorf("MakeRoom - want: nothing left to degrade, got room made")
+		t.Fail()
+	}
+	if got := len(events.Events()); got != 2 {
+		t.Errorf("Events - want: %d, got %d", 2, got)
+		t.Fail()
+	}
+
+	if (&Degrader{}).Enabled() {
+		t.Errorf("Enabled - want: disabled without order, got enabled")
+		t.Fail()
+	}
+}
+
+func Test_DegradeAwareServiceQueryLeavesDegradedFunctions(t *testing.T) {
+	scaler := scaling.GetScalerInstance()
+	defer func(query scaling.ServiceQuery) { scaler.Config.ServiceQuery = query }(scaler.Config.ServiceQuery)
+	serviceQuery := degradeServiceQuery()
+	scaler.Config.ServiceQuery = serviceQuery
+	SetupDegrader([]string{"degrade-batch"})
+	defer SetupDegrader(nil)
+
+	if !GetDegrader().MakeRoom("degrade-rt", func() bool { return true }) {
+		t.Errorf("MakeRoom - want: room made, got none")
+		t.FailNow()
+	}
+	if !GetDegrader().Degraded("degrade-batch") || GetDegrader().Degraded("degrade-thumbnails") {
+		t.Errorf("Degraded - want: %v %v, got %v %v", true, false, GetDegrader().Degraded("degrade-batch"), GetDegrader().Degraded("degrade-thumbnails"))
+		t.Fail()
+	}
+
+	// The alert-driven autoscaler does not scale the degraded function up
+	query := DegradeAwareServiceQuery{ServiceQuery: serviceQuery}
+	query.SetReplicas("degrade-batch", 5)
+	query.SetReplicas("degrade-thumbnails", 5)
+	if batch, thumbnails := serviceQuery.Info["degrade-batch"].Replicas, serviceQuery.Info["degrade-thumbnails"].Replicas; batch != 1 || thumbnails != 5 {
+		t.Errorf("SetReplicas - replicas want: %d %d, got %d %d", 1, 5, batch, thumbnails)
+		t.Fail()
+	}
+}
+
+func Test_ReserveMakesRoomOnCapacityRejection(t *testing.T) {
+	scaler := scaling.GetScalerInstance()
+	defer func(query scaling.ServiceQuery) { scaler.Config.ServiceQuery = query }(scaler.Config.ServiceQuery)
+	serviceQuery := degradeServiceQuery()
+	serviceQuery.Info["degrade-batch"].CPU = 100
+	scaler.Config.ServiceQuery = serviceQuery
+	SetupLedger(1000, 0, PolicyNone)
+	defer SetupLedger(0, 0, PolicyEDF)
+	SetupDegrader([]string{"degrade-batch"})
+	defer SetupDegrader(nil)
+
+	GetDegrader().Track()
+	if cpu, _ := GetLedger().Occupied(); cpu != 500 {
+		t.Errorf("Track - occupied cpu want: %d, got %d", 500, cpu)
+		t.Fail()
+	}
+	reservation := Reservation{CPU: 600, Task: Task{Rate: 6, Budget: 100, CPU: 1000}}
+	if _, _, err := (ReserveAdmissionControl{}).reserve("degrade-rt", reservation); err != nil {
+		t.Errorf("reserve - error want: nil, got %s", err)
+		t.FailNow()
+	}
+	if got := serviceQuery.Info["degrade-batch"].Replicas; got != 1 {
+		t.Errorf("reserve - degraded replicas want: %d, got %d", 1, got)
+		t.Fail()
+	}
+	if cpu, _ := GetLedger().Occupied(); cpu != 100 {
+		t.Errorf("reserve - occupied cpu want: %d, got %d", 100, cpu)
+		t.Fail()
+	}
+
+	// Nothing is left to degrade for a reservation beyond the capacity
+	reservation.CPU = 400
+	if _, _, err := (ReserveAdmissionControl{}).reserve("degrade-rt2", reservation); err == nil {
+		t.Errorf("reserve beyond capacity - error want: rejection, got nil")
+		t.Fail()
+	}
+}
+
+func Test_DegradedFunctionsSurviveRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "realtime-degraded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	scaler := scaling.GetScalerInstance()
+	defer func(query scaling.ServiceQuery) { scaler.Config.ServiceQuery = query }(scaler.Config.ServiceQuery)
+	serviceQuery := degradeServiceQuery()
+	scaler.Config.ServiceQuery = serviceQuery
+	SetupLedger(10000, 0, PolicyNone)
+	defer SetupLedger(0, 0, PolicyEDF)
+	GetLedger().Reserve("degrade-rt", Reservation{CPU: 2000, Task: Task{Rate: 10, Budget: 100, CPU: 2000}})
+
+	degrader := NewDegrader([]string{"degrade-batch"}, NewEventLog(10))
+	if err := degrader.Keep(dir); err != nil {
+		t.Fatal(err)
+	}
+	if !degrader.MakeRoom("degrade-rt", func() bool { return true }) {
+		t.Errorf("MakeRoom - want: room made, got none")
+		t.FailNow()
+	}
+
+	// The gateway restarts and reads the degraded function back
+	restarted := NewDegrader([]string{"degrade-batch"}, NewEventLog(10))
+	if err := restarted.Keep(dir); err != nil {
+		t.Fatal(err)
+	}
+	if !restarted.Degraded("degrade-batch") {
+		t.Errorf("Keep - want: %s degraded, got restored", "degrade-batch")
+		t.FailNow()
+	}
+	GetLedger().Release("degrade-rt")
+	restarted.Restore(time.Now())
+	if got := serviceQuery.Info["degrade-batch"].Replicas; got != 5 {
+		t.Errorf("Restore - replicas want: %d, got %d", 5, got)
+		t.Fail()
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Restore - kept degradations want: none, got %d", len(files))
+		t.Fail()
+	}
+}
@@ -0,0 +1,332 @@
+package realtime
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ngduchai/faas/gateway/realtime/specs"
+	"github.com/ngduchai/faas/gateway/requests"
+)
+
+// DurationHeader is set by the watchdog to the execution time of an
+// invocation in seconds
+const DurationHeader = "X-Duration-Seconds"
+
+// PeakMemoryHeader is set by the watchdog to the peak resident memory of an
+// invocation in bytes
+const PeakMemoryHeader = "X-Peak-Memory-Bytes"
+
+const (
+	// maxSamples is the number of recent samples kept per function
+	maxSamples = 1000
+	// minDemandSamples is the number of durations needed before the
+	// demand of a function is trusted
+	minDemandSamples = 50
+	// resizeThreshold is the relative change in demand that triggers a resize
+	resizeThreshold = 0.1
+)
+
+// sampleWindow keeps the most recent samples
+type sampleWindow struct {
+	values []float64
+	next   int
+}
+
+func (d *sampleWindow) add(value float64) {
+	if len(d.values) < maxSamples {
+		d.values = append(d.values, value)
+		return
+	}
+	d.values[d.next] = value
+	d.next = (d.next + 1) % maxSamples
+}
+
+// percentile returns the duration below which the given share of the
+// samples fall
+func (d *sampleWindow) percentile(p float64) float64 {
+	sorted := append([]float64{}, d.values...)
+	sort.Float64s(sorted)
+	index := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	return sorted[index]
+}
+
+// functionSamples holds the durations (in ms) measured by the watchdog and
+// the latencies observed by the gateway, the former are preferred, along
+// with the peak memory (in bytes) reported by the watchdog
+type functionSamples struct {
+	execution sampleWindow
+	latency   sampleWindow
+	memory    sampleWindow
+	sync      sync.Mutex
+}
+
+// DemandTracker measures how long invocations actually run and derives the
+// demand reservations are sized for
+type DemandTracker struct {
+	// Percentile of the durations taken as demand
+	Percentile float64
+	// Sizing enables reservations sized from the demand rather than from
+	// the declared timeout
+	Sizing bool
+	// RightSize enables reservations sized from the recommendation, bounded
+	// by the declared size and Floor times of it
+	RightSize bool
+	Floor     float64
+	functions sync.Map
+}
+
+// NewDemandTracker creates a tracker taking the given percentile as demand
+func NewDemandTracker(percentile float64, sizing bool) *DemandTracker {
+	return &DemandTracker{
+		Percentile: percentile,
+		Sizing:     sizing,
+	}
+}
+
+func (t *DemandTracker) samples(functionName string) *functionSamples {
+	entry, _ := t.functions.LoadOrStore(functionName, &functionSamples{})
+	return entry.(*functionSamples)
+}
+
+// ObserveExecution records the execution time reported by the watchdog
+func (t *DemandTracker) ObserveExecution(functionName string, duration time.Duration) {
+	d := t.samples(functionName)
+	d.sync.Lock()
+	defer d.sync.Unlock()
+	d.execution.add(float64(duration) / float64(time.Millisecond))
+}
+
+// ObserveLatency records the time the provider took to answer an invocation
+func (t *DemandTracker) ObserveLatency(functionName string, duration time.Duration) {
+	d := t.samples(functionName)
+	d.sync.Lock()
+	defer d.sync.Unlock()
+	d.latency.add(float64(duration) / float64(time.Millisecond))
+}
+
+// ObservePeakMemory records the peak memory reported by the watchdog
+func (t *DemandTracker) ObservePeakMemory(functionName string, bytes int64) {
+	d := t.samples(functionName)
+	d.sync.Lock()
+	defer d.sync.Unlock()
+	d.memory.add(float64(bytes))
+}
+
+// Forget drops the durations measured for a function
+func (t *DemandTracker) Forget(functionName string) {
+	t.functions.Delete(functionName)
+}
+
+// Measured returns the demand percentile of the function in milliseconds
+// and whether enough durations were measured
+func (t *DemandTracker) Measured(functionName string) (float64, bool) {
+	entry, ok := t.functions.Load(functionName)
+	if !ok {
+		return 0, false
+	}
+	d := entry.(*functionSamples)
+	d.sync.Lock()
+	defer d.sync.Unlock()
+
+	if len(d.execution.values) >= minDemandSamples {
+		return d.execution.percentile(t.Percentile), true
+	}
+	if len(d.latency.values) >= minDemandSamples {
+		return d.latency.percentile(t.Percentile), true
+	}
+	return 0, false
+}
+
+// Demand returns the duration (in ms) the reservation of the function is
+// sized for, capped by its timeout. Zero means the reservation is sized
+// for the timeout.
+func (t *DemandTracker) Demand(functionName string, timeout uint64) uint64 {
+	if !t.Sizing {
+		return 0
+	}
+	measured, ok := t.Measured(functionName)
+	if !ok {
+		return 0
+	}
+	demand := uint64(math.Ceil(measured))
+	if demand < 1 {
+		demand = 1
+	}
+	if demand >= timeout {
+		return 0
+	}
+	return demand
+}
+
+var demandTracker *DemandTracker
+var demandOnce sync.Once
+
+// SetupDemandTracker sets the percentile taken as demand and whether
+// reservations are sized from it
+func SetupDemandTracker(percentile float64, sizing bool) {
+	demandTracker = NewDemandTracker(percentile, sizing)
+}
+
+// GetDemandTracker returns the tracker shared by the gateway
+func GetDemandTracker() *DemandTracker {
+	demandOnce.Do(func() {
+		if demandTracker == nil {
+			SetupDemandTracker(0.99, false)
+		}
+	})
+	return demandTracker
+}
+
+// DemandNotifier feeds the latency of function invocations to the demand tracker
+type DemandNotifier struct {
+}
+
+// Notify records the latency of successful invocations of known functions
+func (DemandNotifier) Notify(method string, URL string, originalURL string, statusCode int, duration time.Duration) {
+	if statusCode < 200 || statusCode > 299 {
+		return
+	}
+	functionName := functionFromURL(originalURL)
+	if _, ok := functionHandlers.Load(functionName); ok {
+		GetDemandTracker().ObserveLatency(functionName, duration)
+	}
+}
+
+// functionFromURL extracts the function name from /function/<name>/...
+func functionFromURL(url string) string {
+	path := strings.TrimPrefix(strings.TrimPrefix(url, "/"), "function/")
+	if i := strings.IndexAny(path, "/?"); i >= 0 {
+		path = path[:i]
+	}
+	return path
+}
+
+// durationRecorder picks the execution time and peak memory reported by the
+// watchdog out of the response of an invocation
+type durationRecorder struct {
+	http.ResponseWriter
+	functionName string
+}
+
+func (d *durationRecorder) WriteHeader(statusCode int) {
+	if statusCode >= 200 && statusCode <= 299 {
+		if value := d.Header().Get(DurationHeader); len(value) > 0 {
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+				GetDemandTracker().ObserveExecution(d.functionName, time.Duration(seconds*float64(time.Second)))
+			}
+		}
+		if value := d.Header().Get(PeakMemoryHeader); len(value) > 0 {
+			if bytes, err := strconv.ParseInt(value, 10, 64); err == nil && bytes > 0 {
+				GetDemandTracker().ObservePeakMemory(d.functionName, bytes)
+			}
+		}
+	}
+	d.ResponseWriter.WriteHeader(statusCode)
+}
+
+func recordDurations(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	functionName := mux.Vars(r)["name"]
+	if _, ok := functionHandlers.Load(functionName); !ok {
+		return w
+	}
+	return &durationRecorder{ResponseWriter: w, functionName: functionName}
+}
+
+// declaredReservation is the reservation needed if every invocation runs
+// for the whole timeout with the declared memory
+func declaredReservation(request requests.CreateFunctionRequest, cpus int64, memory int64) Reservation {
+	request.Demand = 0
+	request.DemandMemory = 0
+	return reservationFor(request, cpus, memory)
+}
+
+// resized tells whether the reservation moved by more than the threshold
+func resized(current Reservation, next Reservation) bool {
+	moved := func(from int64, to int64) bool {
+		if from == 0 {
+			return to != 0
+		}
+		return math.Abs(float64(to-from))/float64(from) >= resizeThreshold
+	}
+	return moved(current.CPU, next.CPU) || moved(current.Memory, next.Memory)
+}
+
+// Resizer periodically sizes the reservations of realtime functions for
+// their measured demand
+type Resizer struct {
+	Tracker *DemandTracker
+}
+
+// NewResizer creates a resizer following the demand measured by the tracker
+func NewResizer(tracker *DemandTracker) *Resizer {
+	return &Resizer{Tracker: tracker}
+}
+
+// Start resizes at every interval
+func (rs *Resizer) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			rs.Resize()
+		}
+	}()
+}
+
+// Resize renegotiates the functions whose reservation, sized for their
+// current demand, moved by more than the threshold
+func (rs *Resizer) Resize() {
+	renegotiator := GetRenegotiator()
+	if renegotiator == nil || (!rs.Tracker.Sizing && !rs.Tracker.RightSize) {
+		return
+	}
+	ledger := GetLedger()
+	rm := ResourceManager{}
+
+	// Functions deployed before the gateway started are only known to the
+	// ledger, their spec is rebuilt from the provider
+	for _, functionName := range ledger.Functions() {
+		if isPending(functionName) {
+			continue
+		}
+		spec, known := renegotiator.lookupDeployed(functionName)
+		if !known || specs.PeakRealtime(spec) <= 0 || spec.Resources == nil {
+			continue
+		}
+		current, reserved := ledger.Get(functionName)
+		if !reserved {
+			continue
+		}
+		cpus, memory, err := rm.GetResourceQuantity(*spec.Resources)
+		if err != nil {
+			continue
+		}
+		rm.RightSize(&spec, memory)
+		next, err := scheduledReservation(spec, cpus, memory, time.Now())
+		if err != nil || current.Task.Rate != next.Task.Rate {
+			// Leave switching windows to the switcher
+			continue
+		}
+		if !resized(current, next) {
+			continue
+		}
+		log.Printf("Resize reservation of %s: duration %dms -> %dms, CPU: %d -> %d Memory %d -> %d",
+			functionName, current.Task.Budget, next.Task.Budget, current.CPU, next.CPU, current.Memory, next.Memory)
+
+		realtime := spec.Realtime
+		statusCode, body, err := renegotiator.Renegotiate(functionName, requests.RealtimeRenegotiation{Realtime: &realtime})
+		if err != nil {
+			log.Printf("Unable to resize %s: %d %s", functionName, statusCode, body)
+		}
+	}
+}
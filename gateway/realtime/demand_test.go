@@ -0,0 +1,190 @@
+package realtime
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ngduchai/faas/gateway/handlers"
+	"github.com/ngduchai/faas/gateway/requests"
+	"github.com/ngduchai/faas/gateway/scaling"
+)
+
+func Test_DemandPrefersWatchdogDurations(t *testing.T) {
+	tracker := NewDemandTracker(0.9, true)
+	for i := 1; i <= 100; i++ {
+		tracker.ObserveLatency("demand", time.Duration(i)*2*time.Millisecond)
+	}
+	if demand := tracker.Demand("demand", 1000); demand != 180 {
+		t.Errorf("Demand - latency want: %d, got %d", 180, demand)
+		t.Fail()
+	}
+
+	for i := 1; i <= 100; i++ {
+		tracker.ObserveExecution("demand", time.Duration(i)*time.Millisecond)
+	}
+	if demand := tracker.Demand("demand", 1000); demand != 90 {
+		t.Errorf("Demand - execution want: %d, got %d", 90, demand)
+		t.Fail()
+	}
+
+	// The demand never exceeds the timeout
+	if demand := tracker.Demand("demand", 50); demand != 0 {
+		t.Errorf("Demand - capped want: %d, got %d", 0, demand)
+		t.Fail()
+	}
+
+	tracker.Sizing = false
+	if demand := tracker.Demand("demand", 1000); demand != 0 {
+		t.Errorf("Demand - sizing disabled want: %d, got %d", 0, demand)
+		t.Fail()
+	}
+}
+
+func Test_DemandNeedsEnoughSamples(t *testing.T) {
+	tracker := NewDemandTracker(0.99, true)
+	for i := 0; i < minDemandSamples-1; i++ {
+		tracker.ObserveExecution("demand", 10*time.Millisecond)
+	}
+	if _, ok := tracker.Measured("demand"); ok {
+		t.Errorf("Measured - want: %v, got %v", false, ok)
+		t.Fail()
+	}
+	tracker.ObserveExecution("demand", 10*time.Millisecond)
+	if measured, ok := tracker.Measured("demand"); !ok || measured != 10 {
+		t.Errorf("Measured - want: %v %v, got %v %v", 10, true, measured, ok)
+		t.Fail()
+	}
+}
+
+func Test_InvokeRecordsWatchdogDuration(t *testing.T) {
+	functionName := "demand-watchdog"
+	SetupDemandTracker(0.99, true)
+	defer SetupDemandTracker(0.99, false)
+	SetFunctionHandler(requests.CreateFunctionRequest{Service: functionName})
+	defer RemoveFunctionHandler(functionName)
+
+	handler := MakeRealtimeInvokeHandler(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(DurationHeader, "0.025")
+		w.WriteHeader(http.StatusOK)
+	})
+	for i := 0; i < minDemandSamples; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/function/"+functionName, nil)
+		handler(httptest.NewRecorder(), mux.SetURLVars(r, map[string]string{"name": functionName}))
+	}
+	if demand := GetDemandTracker().Demand(functionName, 1000); demand != 25 {
+		t.Errorf("Demand - want: %d, got %d", 25, demand)
+		t.Fail()
+	}
+}
+
+func Test_DemandNotifierRecordsLatency(t *testing.T) {
+	functionName := "demand-notifier"
+	SetupDemandTracker(0.99, true)
+	defer SetupDemandTracker(0.99, false)
+	SetFunctionHandler(requests.CreateFunctionRequest{Service: functionName})
+	defer RemoveFunctionHandler(functionName)
+
+	notifier := DemandNotifier{}
+	for i := 0; i < minDemandSamples; i++ {
+		notifier.Notify(http.MethodPost, "/function/"+functionName, "/function/"+functionName+"/path?q=1", http.StatusOK, 40*time.Millisecond)
+		notifier.Notify(http.MethodPost, "/function/"+functionName, "/function/"+functionName, http.StatusBadGateway, time.Second)
+	}
+	if demand := GetDemandTracker().Demand(functionName, 1000); demand != 40 {
+		t.Errorf("Demand - want: %d, got %d", 40, demand)
+		t.Fail()
+	}
+}
+
+func Test_ResizerSizesRequestsForDemand(t *testing.T) {
+	functionName := "demand-resize"
+	SetupLedger(1000, 0, PolicyNone)
+	defer SetupLedger(0, 0, PolicyEDF)
+	SetupDemandTracker(0.99, true)
+	defer SetupDemandTracker(0.99, false)
+	provider := newAdmissionProvider()
+	defer provider.server.Close()
+	scaling.GetScalerInstance().Config.ServiceQuery = ReadyServiceQuery{
+		Response: scaling.ServiceQueryResponse{Replicas: 1, AvailableReplicas: 1, Realtime: 5, CPU: 1000, Duration: 100},
+	}
+
+	ac := ReserveAdmissionControl{}
+	SetupRenegotiator(&Renegotiator{
+		AdmissionControl: ac,
+		Client:           http.DefaultClient,
+		BaseURLResolver:  handlers.SingleHostBaseURLResolver{BaseURL: provider.server.URL},
+		Timeout:          time.Second,
+	})
+	defer SetupRenegotiator(nil)
+	defer undeploy(ac, provider, functionName)
+
+	if code := deploy(ac, provider, http.MethodPost, admissionSpec(functionName, 5)); code != http.StatusAccepted {
+		t.Errorf("Register - status want: %d, got %d", http.StatusAccepted, code)
+		t.FailNow()
+	}
+	if res, _ := GetLedger().Get(functionName); res.CPU != 500 {
+		t.Errorf("Register - reservation want: %d, got %d", 500, res.CPU)
+		t.Fail()
+	}
+
+	for i := 0; i < minDemandSamples; i++ {
+		GetDemandTracker().ObserveExecution(functionName, 20*time.Millisecond)
+	}
+	NewResizer(GetDemandTracker()).Resize()
+
+	if res, _ := GetLedger().Get(functionName); res.CPU != 100 || res.Task.Budget != 20 {
+		t.Errorf("Resize - reservation want: %d %d, got %d %d", 100, 20, res.CPU, res.Task.Budget)
+		t.Fail()
+	}
+	deployed := provider.lastDeployed()
+	if deployed.Requests == nil || deployed.Requests.CPU != "100m" || deployed.Limits == nil || deployed.Limits.CPU != "500m" {
+		t.Errorf("Resize - sandbox want: requests %s limits %s, got %+v %+v", "100m", "500m", deployed.Requests, deployed.Limits)
+		t.Fail()
+	}
+	if spec := deployedSpec(deployed); spec.Demand != 20 {
+		t.Errorf("Resize - spec demand want: %d, got %+v", 20, spec)
+		t.Fail()
+	}
+}
+
+func Test_ResizerResizesFunctionsDeployedBeforeRestart(t *testing.T) {
+	functionName := "demand-resize-restarted"
+	SetupLedger(1000, 0, PolicyNone)
+	defer SetupLedger(0, 0, PolicyEDF)
+	SetupDemandTracker(0.99, true)
+	defer SetupDemandTracker(0.99, false)
+	provider := newAdmissionProvider()
+	defer provider.server.Close()
+	scaling.GetScalerInstance().Config.ServiceQuery = ReadyServiceQuery{
+		Response: scaling.ServiceQueryResponse{Replicas: 1, AvailableReplicas: 1, Realtime: 5, CPU: 1000, Duration: 100},
+	}
+
+	ac := ReserveAdmissionControl{}
+	SetupRenegotiator(&Renegotiator{
+		AdmissionControl: ac,
+		Client:           http.DefaultClient,
+		BaseURLResolver:  handlers.SingleHostBaseURLResolver{BaseURL: provider.server.URL},
+		Timeout:          time.Second,
+	})
+	defer SetupRenegotiator(nil)
+	defer undeploy(ac, provider, functionName)
+
+	if code := deploy(ac, provider, http.MethodPost, admissionSpec(functionName, 5)); code != http.StatusAccepted {
+		t.Errorf("Register - status want: %d, got %d", http.StatusAccepted, code)
+		t.FailNow()
+	}
+	// The gateway restarted, the reservation was restored from the provider
+	forgetSpec(functionName)
+
+	for i := 0; i < minDemandSamples; i++ {
+		GetDemandTracker().ObserveExecution(functionName, 20*time.Millisecond)
+	}
+	NewResizer(GetDemandTracker()).Resize()
+
+	if res, _ := GetLedger().Get(functionName); res.CPU != 100 || res.Task.Budget != 20 {
+		t.Errorf("Resize - reservation want: %d %d, got %d %d", 100, 20, res.CPU, res.Task.Budget)
+		t.Fail()
+	}
+}
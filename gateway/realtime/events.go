@@ -0,0 +1,75 @@
+package realtime
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ngduchai/faas/gateway/requests"
+)
+
+// eventLogSize is how many events the gateway keeps, older ones are dropped
+const eventLogSize = 1000
+
+// EventLog keeps the latest actions the gateway took on functions on its
+// own, oldest first
+type EventLog struct {
+	Size   int
+	events []requests.RealtimeEvent
+	sync   sync.Mutex
+}
+
+// NewEventLog creates an event log keeping up to size events
+func NewEventLog(size int) *EventLog {
+	return &EventLog{Size: size}
+}
+
+// Record appends the event, stamped with the given time
+func (l *EventLog) Record(event requests.RealtimeEvent, now time.Time) {
+	event.Time = now.Format(time.RFC3339Nano)
+	log.Printf("Realtime event %s %s: %d => %d replicas, %s",
+		event.Action, event.Function, event.PreviousReplicas, event.Replicas, event.Reason)
+
+	l.sync.Lock()
+	defer l.sync.Unlock()
+	l.events = append(l.events, event)
+	if len(l.events) > l.Size {
+		l.events = append([]requests.RealtimeEvent{}, l.events[len(l.events)-l.Size:]...)
+	}
+}
+
+// Events returns the recorded events, oldest first
+func (l *EventLog) Events() []requests.RealtimeEvent {
+	l.sync.Lock()
+	defer l.sync.Unlock()
+	return append([]requests.RealtimeEvent{}, l.events...)
+}
+
+var eventLogInstance *EventLog
+var eventLogOnce sync.Once
+
+// GetEventLog returns the event log shared by the gateway
+func GetEventLog() *EventLog {
+	eventLogOnce.Do(func() {
+		eventLogInstance = NewEventLog(eventLogSize)
+	})
+	return eventLogInstance
+}
+
+// MakeRealtimeEventsHandler lists the actions the gateway took on functions
+// on its own
+func MakeRealtimeEventsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		out, err := json.Marshal(GetEventLog().Events())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(out)
+	}
+}
@@ -0,0 +1,44 @@
+package realtime
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ngduchai/faas/gateway/requests"
+)
+
+func Test_EventLogKeepsLatest(t *testing.T) {
+	events := NewEventLog(2)
+	now := time.Now()
+	for _, name := range []string{"events-a", "events-b", "events-c"} {
+		events.Record(requests.RealtimeEvent{Action: requests.EventDegrade, Function: name}, now)
+	}
+	logged := events.Events()
+	if len(logged) != 2 || logged[0].Function != "events-b" || logged[1].Function != "events-c" {
+		t.Errorf("Events - want: %v, got %+v", []string{"events-b", "events-c"}, logged)
+		t.Fail()
+	}
+}
+
+func Test_RealtimeEventsHandler(t *testing.T) {
+	GetEventLog().Record(requests.RealtimeEvent{Action: requests.EventRestore, Function: "events-handler"}, time.Now())
+
+	w := httptest.NewRecorder()
+	MakeRealtimeEventsHandler()(w, httptest.NewRequest(http.MethodGet, "/system/realtime-events", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Events - status want: %d, got %d", http.StatusOK, w.Code)
+		t.FailNow()
+	}
+	logged := []requests.RealtimeEvent{}
+	if err := json.Unmarshal(w.Body.Bytes(), &logged); err != nil || len(logged) == 0 {
+		t.Errorf("Events - want: events, got %s", w.Body.String())
+		t.FailNow()
+	}
+	if last := logged[len(logged)-1]; last.Function != "events-handler" || last.Action != requests.EventRestore {
+		t.Errorf("Events - last want: %s %s, got %+v", requests.EventRestore, "events-handler", last)
+		t.Fail()
+	}
+}
@@ -117,7 +117,7 @@ func MakeRealtimeDeleteHandler(
 
 func MakeRealtimeInvokeHandler(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		Invoke(next, w, r)
+		Invoke(next, recordDurations(w, r), r)
 		// originalURL := r.URL.String()
 		// tokens := strings.Split(originalURL, "/")
 		// functionName := tokens[len(tokens)-1]
@@ -1,14 +1,21 @@
 package realtime
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/ngduchai/faas/gateway/realtime/specs"
 	"github.com/ngduchai/faas/gateway/requests"
+	"github.com/ngduchai/faas/gateway/scaling"
 )
 
 // Invocation holds information of pending requests
@@ -16,24 +23,116 @@ type Invocation struct {
 	next http.HandlerFunc
 	w    http.ResponseWriter
 	r    *http.Request
-	cond *sync.Cond
+	// arrived is when the invocation reached the gateway
+	arrived time.Time
+	// ctx is cancelled when the caller goes away
+	ctx context.Context
+	// deadline is when the result stops being useful, zero if none
+	deadline time.Time
+	// expires is the latest dispatch time allowed by the queue-wait budget
+	// and the deadline, zero if unbounded
+	expires time.Time
+	// done receives true once the invocation has run and false if it was
+	// skipped because the caller left or its budget ran out
+	done chan bool
 }
 
 var functionHandlers sync.Map
 
+// defaultMaxQueueWait bounds the time an invocation may wait for a token
+// when neither the function nor the caller sets a budget
+var defaultMaxQueueWait time.Duration
+
+// SetDefaultMaxQueueWait sets the queue-wait budget of functions deployed
+// without one, zero leaves the wait unbounded
+func SetDefaultMaxQueueWait(budget time.Duration) {
+	defaultMaxQueueWait = budget
+}
+
+// MaxQueueWaitHeader lets a caller tighten the queue-wait budget (in ms) of
+// a single invocation
+const MaxQueueWaitHeader = "X-Max-Queue-Wait"
+
+// handlerParams are the parameters of a function a handler is tuned to.
+// They are replaced as a whole when the function changes, never modified.
+type handlerParams struct {
+	Realtime float64
+	Burst    float64
+	Pool     string
+	// Weight of the function in its pool
+	Weight float64
+	// QoS is the class of service of the function
+	QoS string
+	// MaxQueueWait bounds the time an invocation waits for a token
+	MaxQueueWait time.Duration
+	// Timeout is the declared execution time of an invocation
+	Timeout time.Duration
+}
+
+// paramsOf returns the parameters a handler of the function is tuned to
+func paramsOf(f requests.CreateFunctionRequest) handlerParams {
+	return handlerParams{
+		Realtime:     f.Realtime,
+		Burst:        f.Burst,
+		Pool:         f.Pool,
+		Weight:       poolWeight(f),
+		QoS:          specs.QoSClass(f.QoS, f.Realtime),
+		MaxQueueWait: maxQueueWait(f),
+		Timeout:      time.Duration(f.Timeout) * time.Millisecond,
+	}
+}
+
 // InvocationHandler process new invocations
 type InvocationHandler struct {
-	// Channel for pending invocations
-	SyncInvs  chan Invocation
-	AsyncInvs chan Invocation
-	Realtime  float64
+	Name      string
 	AsyncWait sync.Map
-	//FreeAsync int32
-	Timing     *time.Ticker
-	Stop       chan bool
-	Update     chan bool
+	// Bucket admits invocations within the (burst, realtime) envelope
+	Bucket     *scaling.TokenBucket
 	BufferSize int
-	Idle       chan bool
+	// current holds the handlerParams, read without locking by invocations
+	// while the function is updated
+	current atomic.Value
+	// Pending invocations, waiting for a token
+	syncInvs  []Invocation
+	asyncInvs []Invocation
+	// queued counts the pending invocations, read without locking
+	queued    int32
+	sync      sync.Mutex
+	scheduler *Scheduler
+	// entry is owned by the scheduler
+	entry *scheduleEntry
+	// achieved measures the rate invocations are actually dispatched at
+	achieved rateMeter
+	created  time.Time
+	// poolShare is set by rebalancePool
+	poolShare poolShare
+	shareSync sync.Mutex
+}
+
+// newInvocationHandler creates a handler dispatched by the given scheduler
+func newInvocationHandler(f requests.CreateFunctionRequest, scheduler *Scheduler) *InvocationHandler {
+	handler := &InvocationHandler{
+		Name:       f.Service,
+		BufferSize: 200,
+		Bucket:     scaling.NewTokenBucket(f.Realtime, f.Burst),
+		scheduler:  scheduler,
+		created:    time.Now(),
+	}
+	handler.current.Store(paramsOf(f))
+	return handler
+}
+
+// params returns the parameters the handler is currently tuned to
+func (handler *InvocationHandler) params() handlerParams {
+	return handler.current.Load().(handlerParams)
+}
+
+// maxQueueWait returns the queue-wait budget of the function
+func maxQueueWait(f requests.CreateFunctionRequest) time.Duration {
+	if f.MaxQueueWait > 0 {
+		return time.Duration(f.MaxQueueWait) * time.Millisecond
+	}
+	return defaultMaxQueueWait
 }
 
 // SetFunctionHandler create handler for a new function or update an existing one
@@ -44,139 +143,204 @@ func SetFunctionHandler(f requests.CreateFunctionRequest) {
 		// The function handler is already exists, we just need to adjust
 		// its parameters
 		handler := entry.(*InvocationHandler)
-		handler.Realtime = f.Realtime
-		if handler.Realtime > 0 {
-			if handler.Timing != nil {
-				handler.Timing.Stop()
-			}
-			handler.Timing = time.NewTicker(time.Duration(1 / f.Realtime * 1000000000))
-			handler.Update <- true
+		// Updates of the parameters and the bucket are not interleaved,
+		// invocations keep reading the parameters they started with
+		handler.sync.Lock()
+		pool := handler.params().Pool
+		handler.current.Store(paramsOf(f))
+		handler.Bucket.SetRate(f.Realtime, f.Burst)
+		handler.sync.Unlock()
+		if handler.pending() > 0 {
+			handler.scheduler.Schedule(handler)
+		}
+		if pool != f.Pool {
+			rebalancePool(pool)
 		}
 	} else {
 		log.Printf("Add new handler entry for %s\n", functionName)
-		buffersize := 200
-		handler := InvocationHandler{
-			BufferSize: buffersize,
-			SyncInvs:   make(chan Invocation, buffersize),
-			AsyncInvs:  make(chan Invocation, buffersize),
-			//FreeAsync: 1000,
-			Realtime: f.Realtime,
-			Stop:     make(chan bool),
-			Update:   make(chan bool),
-			Idle:     make(chan bool),
+		functionHandlers.Store(functionName, newInvocationHandler(f, GetScheduler()))
+	}
+	rebalancePool(f.Pool)
+}
+
+// changed tells whether the handler is not tuned to the function parameters
+func (handler *InvocationHandler) changed(f requests.CreateFunctionRequest) bool {
+	return handler.params() != paramsOf(f)
+}
+
+// admit dispatches the invocation right away if nothing is waiting and the
+// bucket has a token, otherwise the invocation is queued. Invocations of
+// burstable functions beyond the guaranteed rate are dispatched right away
+// too, on whatever capacity the provider has left. It returns false when
+// the queue is full.
+func (handler *InvocationHandler) admit(invocation Invocation, async bool) (bool, bool) {
+	handler.sync.Lock()
+	defer handler.sync.Unlock()
+	defer handler.countQueued()
+
+	now := time.Now()
+	if len(handler.syncInvs) == 0 && len(handler.asyncInvs) == 0 && handler.take(now) {
+		recordDispatch(handler, 0, now)
+		return true, true
+	}
+	if handler.opportunistic() {
+		recordOpportunistic(handler.Name)
+		return true, true
+	}
+	if async {
+		if len(handler.asyncInvs) >= handler.BufferSize {
+			return false, false
 		}
-		if f.Realtime > 0 {
-			handler.Timing = time.NewTicker(time.Duration(1 / f.Realtime * 1000000000))
-		} else {
-			// Set timming but stop immediately since we dont need periodic control over invocations
-			handler.Timing = time.NewTicker(time.Duration(1 * 1000000000))
-			handler.Timing.Stop()
+		handler.asyncInvs = enqueue(handler.asyncInvs, invocation)
+	} else {
+		if len(handler.syncInvs) >= handler.BufferSize {
+			return false, false
 		}
-		functionHandlers.Store(functionName, &handler)
-		log.Printf("Starting handler for %s\n", functionName)
-		go func() {
-			timeout := 10000 * time.Second
-			if handler.Realtime > 0 {
-				timeout = time.Duration(1 / f.Realtime * 1000000000000)
-			}
-			checktime := time.Now()
-			for {
-				select {
-				case <-handler.Stop:
-					handler.Timing.Stop()
-					log.Printf("Handler for %s stops", functionName)
-					return
-				case <-handler.Update:
-					log.Printf("Update handler timing %s\n", functionName)
-				case <-handler.Timing.C:
-					// log.Printf("Looking for new invocation %s\n", functionName)
-					select {
-					case asyncInvocation, ok := <-handler.AsyncInvs:
-						if !ok {
-							log.Println("Invocation channels are closed, stop scheduling invocations")
-							return
-						} else {
-							log.Printf("Executing async %s after %d\n", functionName, time.Since(checktime)/time.Millisecond)
-							checktime = time.Now()
-							go func() {
-								asyncInvocation.next(asyncInvocation.w, asyncInvocation.r)
-								asyncInvocation.cond.Signal()
-							}()
-						}
-					case syncInvocation, ok := <-handler.SyncInvs:
-						if !ok {
-							log.Println("Invocation channels are closed, stop scheduling invocations")
-							return
-						} else {
-							// log.Printf("Executing sync %s", functionName)
-							go func() {
-								syncInvocation.next(syncInvocation.w, syncInvocation.r)
-								syncInvocation.cond.Signal()
-							}()
-						}
-					case <-handler.Stop:
-						handler.Timing.Stop()
-						log.Printf("Handler for %s stops", functionName)
-						return
-					case <-handler.Update:
-						log.Printf("Update handler timing %s\n", functionName)
-					case <-time.After(timeout):
-						handler.Timing = time.NewTicker(time.Duration(1 / f.Realtime * 1000000000))
-						//default:
-						// log.Printf("Invocation queue of %s is empty at timer tick, stop timming", functionName)
-						// handler.Timing.Stop()
-						// log.Printf("Enter idle state, waiting for invocation %s", functionName)
-						// select {
-						// case asyncInvocation, ok := <-handler.AsyncInvs:
-						// 	if !ok {
-						// 		log.Println("Invocation channels are closed, stop scheduling invocations")
-						// 		return
-						// 	} else {
-						// 		// log.Printf("Executing sync %s", functionName)
-						// 		go func() {
-						// 			asyncInvocation.next(asyncInvocation.w, asyncInvocation.r)
-						// 			asyncInvocation.cond.Signal()
-						// 		}()
-						// 	}
-						// case syncInvocation, ok := <-handler.SyncInvs:
-						// 	if !ok {
-						// 		log.Println("Invocation channels are closed, stop scheduling invocations")
-						// 		return
-						// 	} else {
-						// 		// log.Printf("Executing async %s", functionName)
-						// 		go func() {
-						// 			syncInvocation.next(syncInvocation.w, syncInvocation.r)
-						// 			syncInvocation.cond.Signal()
-						// 		}()
-						// 	}
-						// }
-						// // Restart timmer if the function is realtime
-						// if f.Realtime > 0 {
-						// 	log.Printf("Invocation to %s, restart timmer\n", functionName)
-						// 	handler.Timing = time.NewTicker(time.Duration(1 / f.Realtime * 1000000000))
-						// }
-					}
-					if len(handler.Timing.C) > 0 && f.Realtime > 0 {
-						handler.Timing = time.NewTicker(time.Duration(1 / f.Realtime * 1000000000))
-					}
-				}
-			}
-		}()
+		handler.syncInvs = enqueue(handler.syncInvs, invocation)
+	}
+	return false, true
+}
+
+// next takes a token and pops the oldest pending invocation. Invocations
+// whose caller left or whose budget ran out are skipped without using a token.
+func (handler *InvocationHandler) next(now time.Time) (Invocation, bool) {
+	handler.sync.Lock()
+	defer handler.sync.Unlock()
+	defer handler.countQueued()
+
+	handler.asyncInvs = skipStale(handler.asyncInvs, now)
+	handler.syncInvs = skipStale(handler.syncInvs, now)
+	if len(handler.syncInvs) == 0 && len(handler.asyncInvs) == 0 {
+		return Invocation{}, false
+	}
+	if !handler.take(now) {
+		return Invocation{}, false
+	}
+	// Asynchronous invocations have been accepted earlier, serve them first
+	// unless a synchronous one has to finish sooner
+	var invocation Invocation
+	if len(handler.asyncInvs) > 0 && (len(handler.syncInvs) == 0 || !earlier(handler.syncInvs[0], handler.asyncInvs[0])) {
+		invocation = handler.asyncInvs[0]
+		handler.asyncInvs = handler.asyncInvs[1:]
+	} else {
+		invocation = handler.syncInvs[0]
+		handler.syncInvs = handler.syncInvs[1:]
+	}
+	recordDispatch(handler, now.Sub(invocation.arrived), now)
+	return invocation, true
+}
+
+// skipStale drops the stale invocations at the head of the queue
+func skipStale(invocations []Invocation, now time.Time) []Invocation {
+	for len(invocations) > 0 && invocations[0].stale(now) {
+		invocations[0].done <- false
+		invocations = invocations[1:]
+	}
+	return invocations
+}
+
+// withdraw removes the invocation from the queue, it returns false if the
+// invocation has already left the queue
+func (handler *InvocationHandler) withdraw(invocation Invocation) bool {
+	handler.sync.Lock()
+	defer handler.sync.Unlock()
+	defer handler.countQueued()
+
+	var removed bool
+	if handler.asyncInvs, removed = remove(handler.asyncInvs, invocation); removed {
+		return true
+	}
+	handler.syncInvs, removed = remove(handler.syncInvs, invocation)
+	return removed
+}
+
+func remove(invocations []Invocation, invocation Invocation) ([]Invocation, bool) {
+	for i := range invocations {
+		if invocations[i].done == invocation.done {
+			return append(invocations[:i], invocations[i+1:]...), true
+		}
+	}
+	return invocations, false
+}
+
+// earliestStart estimates when a new invocation could be dispatched
+func (handler *InvocationHandler) earliestStart(now time.Time) time.Time {
+	if handler.opportunistic() {
+		return now
+	}
+	if handler.pending() == 0 {
+		return now.Add(handler.slotWait(now))
+	}
+	return now.Add(handler.retryAfter(now))
+}
+
+// retryAfter estimates how long a new invocation would wait for a token
+func (handler *InvocationHandler) retryAfter(now time.Time) time.Duration {
+	realtime := handler.params().Realtime
+	if realtime <= 0 {
+		return time.Second
+	}
+	wait := handler.Bucket.Wait(now) + time.Duration(float64(handler.pending())/realtime*float64(time.Second))
+	if wait < time.Second {
+		return time.Second
+	}
+	return wait
+}
+
+// pending returns the number of queued invocations
+func (handler *InvocationHandler) pending() int {
+	handler.sync.Lock()
+	defer handler.sync.Unlock()
+
+	return len(handler.syncInvs) + len(handler.asyncInvs)
+}
+
+// drain removes all pending invocations
+func (handler *InvocationHandler) drain() []Invocation {
+	handler.sync.Lock()
+	defer handler.sync.Unlock()
+	defer handler.countQueued()
+
+	invocations := append(handler.asyncInvs, handler.syncInvs...)
+	handler.asyncInvs = nil
+	handler.syncInvs = nil
+	return invocations
+}
+
+// stale tells whether the invocation should no longer be dispatched
+func (invocation Invocation) stale(now time.Time) bool {
+	if invocation.ctx != nil && invocation.ctx.Err() != nil {
+		return true
+	}
+	return !invocation.expires.IsZero() && now.After(invocation.expires)
+}
+
+func (invocation Invocation) execute() {
+	if invocation.ctx != nil && invocation.ctx.Err() != nil {
+		invocation.done <- false
+		return
 	}
+	invocation.next(invocation.w, invocation.r)
+	invocation.done <- true
 }
 
 func RemoveFunctionHandler(functionName string) {
+	removeConcurrencyLimiter(functionName)
+	GetDegrader().forget(functionName)
 	entry, ok := functionHandlers.Load(functionName)
 	if !ok {
 		// no handler exist, forward for further processing
 		return
 	}
 	handler := entry.(*InvocationHandler)
-	handler.Stop <- true
-	handler.Timing.Stop()
-	close(handler.SyncInvs)
-	close(handler.AsyncInvs)
 	functionHandlers.Delete(functionName)
+	handler.scheduler.Remove(handler)
+	rebalancePool(handler.params().Pool)
+	// Let the provider answer the invocations that were still waiting
+	for _, invocation := range handler.drain() {
+		go invocation.execute()
+	}
+	log.Printf("Handler for %s stops", functionName)
 }
 
 func AsyncInvoke(functionName string, id string) error {
@@ -186,15 +350,13 @@ func AsyncInvoke(functionName string, id string) error {
 		return errors.New("Function handler not found")
 	}
 	handler := entry.(*InvocationHandler)
-	if handler.Realtime > 0 {
-		//avail := atomic.AddInt32(&handler.FreeAsync, -1)
-		avail := handler.BufferSize - len(handler.AsyncInvs)
-		// log.Printf("Available slot for %s: %d\n", functionName, avail)
+	if handler.params().Realtime > 0 {
+		handler.sync.Lock()
+		avail := handler.BufferSize - len(handler.asyncInvs)
+		handler.sync.Unlock()
 		if avail <= 0 {
-			// atomic.AddInt32(&handler.FreeAsync, 1)
 			return errors.New("Too many invocations")
 		} else {
-			// log.Printf("Adding new function for %s\n", functionName)
 			handler.AsyncWait.Store(id, time.Now())
 			return nil
 		}
@@ -202,6 +364,29 @@ func AsyncInvoke(functionName string, id string) error {
 	return nil
 }
 
+// wait blocks until the invocation has run or has been given up because the
+// caller left or its budget ran out. It returns true if the invocation ran.
+func (invocation Invocation) wait(handler *InvocationHandler) bool {
+	var expired <-chan time.Time
+	if !invocation.expires.IsZero() {
+		timer := time.NewTimer(time.Until(invocation.expires))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case executed := <-invocation.done:
+		return executed
+	case <-invocation.ctx.Done():
+	case <-expired:
+	}
+	if handler.withdraw(invocation) {
+		return false
+	}
+	// The scheduler has already taken the invocation
+	return <-invocation.done
+}
+
 // Invoke execute a deployed function
 func Invoke(next http.HandlerFunc, w http.ResponseWriter, r *http.Request) error {
 	// Infer function name from the url
@@ -221,56 +406,103 @@ func Invoke(next http.HandlerFunc, w http.ResponseWriter, r *http.Request) error
 		return errors.New("Function handler not found")
 	}
 	handler := entry.(*InvocationHandler)
-	if handler.Realtime == 0 {
+	params := handler.params()
+	if params.Realtime == 0 && !handler.backfills() {
 		// Best-effort serverless, go forward
 		// log.Printf("Realtime = 0, run as best-effort function %s\n", functionName)
 		next(w, r)
 	} else {
-		// Real-time serverless, go through real-time scheduling
-		invocation := Invocation{
-			next: next,
-			w:    w,
-			r:    r,
-			cond: sync.NewCond(&sync.Mutex{}),
-		}
-		// Check if the invocation is an asynchronous call that has been added
+		// Real-time serverless, invocations within the arrival envelope are
+		// dispatched immediately, the excess waits for a token. Best-effort
+		// invocations of a pool wait for a slot left idle by the pool.
 		callid := r.Header.Get("X-Call-Id")
-		if _, added := handler.AsyncWait.Load(callid); added {
+		_, async := handler.AsyncWait.Load(callid)
+		if async {
 			handler.AsyncWait.Delete(callid)
-			// atomic.AddInt32(&handler.FreeAsync, 1)
-			select {
-			case handler.AsyncInvs <- invocation:
-				// Successfully add new invocation to the channel for real-time scheduling
-				// Wait until the execution success
-				log.Printf("Add invocation to async queue %s: %d\n", functionName, time.Since(start)/time.Millisecond)
-				invocation.cond.L.Lock()
-				invocation.cond.Wait()
-				invocation.cond.L.Unlock()
+		}
+		budget := params.MaxQueueWait
+		if value := r.Header.Get(MaxQueueWaitHeader); len(value) > 0 {
+			if ms, err := strconv.ParseUint(value, 10, 64); err == nil {
+				requested := time.Duration(ms) * time.Millisecond
+				if budget == 0 || requested < budget {
+					budget = requested
+				}
+			}
+		}
+		invocation := Invocation{
+			next:    next,
+			w:       w,
+			r:       r,
+			arrived: start,
+			ctx:     r.Context(),
+			done:    make(chan bool, 1),
+		}
+		if budget > 0 {
+			invocation.expires = start.Add(budget)
+		}
+		if value := r.Header.Get(DeadlineHeader); len(value) > 0 {
+			deadline, err := parseDeadline(value, start)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return err
+			}
+			// Reject up front an invocation that cannot finish in time
+			if handler.earliestStart(start).Add(params.Timeout).After(deadline) {
+				recordDeadline(functionName, deadline, false)
+				recordRejection(functionName, rejectDeadline)
+				w.WriteHeader(http.StatusGatewayTimeout)
+				w.Write([]byte("Deadline cannot be met"))
+				log.Printf("Cannot invoke function %s: deadline %s cannot be met\n", functionName, deadline.Format(time.RFC3339Nano))
+				return errors.New("Deadline cannot be met")
+			}
+			invocation.deadline = deadline
+			if latest := deadline.Add(-params.Timeout); invocation.expires.IsZero() || latest.Before(invocation.expires) {
+				invocation.expires = latest
+			}
+		}
+		dispatched, queued := handler.admit(invocation, async)
+		if dispatched {
+			next(w, r)
+			recordDeadline(functionName, invocation.deadline, !time.Now().After(invocation.deadline))
+			return nil
+		}
+		if queued {
+			// Wait until the scheduler releases the invocation
+			handler.scheduler.Schedule(handler)
+			log.Printf("Add invocation to queue %s: %d\n", functionName, time.Since(start)/time.Millisecond)
+			if invocation.wait(handler) {
+				recordDeadline(functionName, invocation.deadline, !time.Now().After(invocation.deadline))
 				log.Printf("Execute invocation %s: %d ms\n", functionName, time.Since(start)/time.Millisecond)
 				return nil
-			default:
-				// Unable to add new invocation because the buffer is full
-				w.WriteHeader(http.StatusForbidden)
-				w.Write([]byte("Too many requests"))
-				log.Printf("Cannot invoke function asynchronously %s: Too many requests\n", functionName)
 			}
-		} else {
-			select {
-			case handler.SyncInvs <- invocation:
-				// Successfully add new invocation to the channel for real-time scheduling
-				// Wait until the execution success
-				log.Printf("Add invocation to sync queue %s\n", functionName)
-				invocation.cond.L.Lock()
-				invocation.cond.Wait()
-				invocation.cond.L.Unlock()
-				return nil
-			default:
-				// Unable to add new invocation because the buffer is full
-				w.WriteHeader(http.StatusForbidden)
-				w.Write([]byte("Too many requests"))
-				log.Printf("Cannot invoke function %s: Too many requests\n", functionName)
+			if r.Context().Err() != nil {
+				log.Printf("Caller of %s left after %d ms\n", functionName, time.Since(start)/time.Millisecond)
+				return r.Context().Err()
+			}
+			if !invocation.deadline.IsZero() && !time.Now().Before(invocation.deadline.Add(-params.Timeout)) {
+				recordDeadline(functionName, invocation.deadline, false)
+				recordRejection(functionName, rejectDeadline)
+				w.WriteHeader(http.StatusGatewayTimeout)
+				w.Write([]byte("Deadline cannot be met"))
+				log.Printf("Cannot invoke function %s: deadline missed in queue\n", functionName)
+				return errors.New("Deadline cannot be met")
 			}
+			recordDeadline(functionName, invocation.deadline, false)
+			recordRejection(functionName, rejectQueueWait)
+			retryAfter := handler.retryAfter(time.Now())
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(fmt.Sprintf("Queue-wait budget of %s exceeded", budget)))
+			log.Printf("Cannot invoke function %s: queue-wait budget %s exceeded\n", functionName, budget)
+			return errors.New("Queue-wait budget exceeded")
 		}
+		// Unable to add new invocation because the buffer is full
+		recordDeadline(functionName, invocation.deadline, false)
+		recordRejection(functionName, rejectQueueFull)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Too many requests"))
+		log.Printf("Cannot invoke function %s: Too many requests\n", functionName)
 	}
 	return nil
 
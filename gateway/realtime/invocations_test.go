@@ -0,0 +1,202 @@
+package realtime
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ngduchai/faas/gateway/requests"
+)
+
+func Test_InvokeDispatchesBurstImmediately(t *testing.T) {
+	functionName := "invoke-burst"
+	SetFunctionHandler(requests.CreateFunctionRequest{
+		Service:  functionName,
+		Realtime: 5,
+		Burst:    2,
+	})
+	defer RemoveFunctionHandler(functionName)
+
+	start := time.Now()
+	finished := make([]time.Duration, 3)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodGet, "/function/"+functionName, nil)
+			w := httptest.NewRecorder()
+			Invoke(func(w http.ResponseWriter, r *http.Request) {
+				finished[i] = time.Since(start)
+			}, w, r)
+		}(i)
+	}
+	wg.Wait()
+
+	immediate := 0
+	for _, elapsed := range finished {
+		if elapsed < 100*time.Millisecond {
+			immediate++
+		} else if elapsed < 150*time.Millisecond {
+			t.Errorf("Invoke - excess invocation should wait for a token, got %s", elapsed)
+			t.Fail()
+		}
+	}
+	if immediate != 2 {
+		t.Errorf("Invoke - immediate invocations want: %d, got %d", 2, immediate)
+		t.Fail()
+	}
+}
+
+func Test_InvokeBestEffortGoesStraightThrough(t *testing.T) {
+	functionName := "invoke-best-effort"
+	SetFunctionHandler(requests.CreateFunctionRequest{Service: functionName})
+	defer RemoveFunctionHandler(functionName)
+
+	called := 0
+	for i := 0; i < 10; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/function/"+functionName, nil)
+		Invoke(func(w http.ResponseWriter, r *http.Request) {
+			called++
+		}, httptest.NewRecorder(), r)
+	}
+	if called != 10 {
+		t.Errorf("Invoke - best-effort calls want: %d, got %d", 10, called)
+		t.Fail()
+	}
+}
+
+func Test_InvokeRejectsAfterQueueWaitBudget(t *testing.T) {
+	functionName := "invoke-budget"
+	SetFunctionHandler(requests.CreateFunctionRequest{
+		Service:      functionName,
+		Realtime:     0.5,
+		MaxQueueWait: 50,
+	})
+	defer RemoveFunctionHandler(functionName)
+
+	called := 0
+	next := func(w http.ResponseWriter, r *http.Request) { called++ }
+	Invoke(next, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/function/"+functionName, nil))
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	Invoke(next, w, httptest.NewRequest(http.MethodGet, "/function/"+functionName, nil))
+	elapsed := time.Since(start)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Invoke - status want: %d, got %d", http.StatusServiceUnavailable, w.Code)
+		t.Fail()
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "2" {
+		t.Errorf("Invoke - Retry-After want: %s, got %s", "2", retryAfter)
+		t.Fail()
+	}
+	if elapsed < 50*time.Millisecond || elapsed > 500*time.Millisecond {
+		t.Errorf("Invoke - rejection want: ~%s, got %s", 50*time.Millisecond, elapsed)
+		t.Fail()
+	}
+	if called != 1 {
+		t.Errorf("Invoke - executions want: %d, got %d", 1, called)
+		t.Fail()
+	}
+}
+
+func Test_InvokeHeaderTightensQueueWaitBudget(t *testing.T) {
+	functionName := "invoke-budget-header"
+	SetFunctionHandler(requests.CreateFunctionRequest{Service: functionName, Realtime: 0.5})
+	defer RemoveFunctionHandler(functionName)
+
+	next := func(w http.ResponseWriter, r *http.Request) {}
+	Invoke(next, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/function/"+functionName, nil))
+
+	r := httptest.NewRequest(http.MethodGet, "/function/"+functionName, nil)
+	r.Header.Set(MaxQueueWaitHeader, "20")
+	w := httptest.NewRecorder()
+	Invoke(next, w, r)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Invoke - status want: %d, got %d", http.StatusServiceUnavailable, w.Code)
+		t.Fail()
+	}
+}
+
+func Test_InvokeSkipsCancelledInvocation(t *testing.T) {
+	functionName := "invoke-cancel"
+	SetFunctionHandler(requests.CreateFunctionRequest{Service: functionName, Realtime: 20})
+	defer RemoveFunctionHandler(functionName)
+
+	entry, _ := functionHandlers.Load(functionName)
+	handler := entry.(*InvocationHandler)
+	handler.Bucket.Take(time.Now())
+
+	called := 0
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, "/function/"+functionName, nil).WithContext(ctx)
+	finished := make(chan error)
+	go func() {
+		finished <- Invoke(func(w http.ResponseWriter, r *http.Request) { called++ }, httptest.NewRecorder(), r)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-finished:
+		if err != context.Canceled {
+			t.Errorf("Invoke - error want: %s, got %v", context.Canceled, err)
+			t.Fail()
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Invoke - cancelled invocation did not return")
+		t.FailNow()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if called != 0 || handler.pending() != 0 {
+		t.Errorf("Invoke - cancelled invocation want: skipped, got %d executions, %d pending", called, handler.pending())
+		t.Fail()
+	}
+}
+
+func Test_SetFunctionHandlerWhileInvoking(t *testing.T) {
+	functionName := "invoke-retuned"
+	SetFunctionHandler(requests.CreateFunctionRequest{Service: functionName, Realtime: 1000, Burst: 1000, Timeout: 1})
+	defer RemoveFunctionHandler(functionName)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			SetFunctionHandler(requests.CreateFunctionRequest{
+				Service:      functionName,
+				Realtime:     float64(1000 + i%2),
+				Burst:        1000,
+				QoS:          requests.QoSBurstable,
+				MaxQueueWait: uint64(100 + i%2),
+				Timeout:      1,
+			})
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				r := httptest.NewRequest(http.MethodGet, "/function/"+functionName, nil)
+				Invoke(func(w http.ResponseWriter, r *http.Request) {}, httptest.NewRecorder(), r)
+			}
+		}()
+	}
+	wg.Wait()
+	<-done
+
+	entry, _ := functionHandlers.Load(functionName)
+	if params := entry.(*InvocationHandler).params(); params.Realtime != 1001 || params.MaxQueueWait != 101*time.Millisecond {
+		t.Errorf("SetFunctionHandler - want: %f %s, got %f %s", 1001.0, 101*time.Millisecond, params.Realtime, params.MaxQueueWait)
+		t.Fail()
+	}
+}
@@ -0,0 +1,342 @@
+package realtime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ngduchai/faas/gateway/realtime/specs"
+	"github.com/ngduchai/faas/gateway/requests"
+)
+
+// lease is what a function holds its reservation under
+type lease struct {
+	Duration time.Duration
+	Policy   string
+	Expires  time.Time
+}
+
+// leases holds the lease of every function deployed with one
+var leases sync.Map
+
+// parseLease returns the duration and policy of the lease of a function,
+// a zero duration when it has none
+func parseLease(request requests.CreateFunctionRequest) (time.Duration, string, error) {
+	if len(request.Lease) == 0 {
+		return 0, "", nil
+	}
+	duration, err := time.ParseDuration(request.Lease)
+	if err != nil || duration <= 0 {
+		return 0, "", fmt.Errorf("lease %q is not a positive duration", request.Lease)
+	}
+	policy := request.LeasePolicy
+	if len(policy) == 0 {
+		policy = requests.LeaseDowngrade
+	}
+	if policy != requests.LeaseDowngrade && policy != requests.LeaseDelete {
+		return 0, "", fmt.Errorf("unknown lease policy %s", policy)
+	}
+	return duration, policy, nil
+}
+
+// validateLease checks the lease of the function is valid and held by a
+// realtime function
+func validateLease(request requests.CreateFunctionRequest) error {
+	duration, _, err := parseLease(request)
+	if err != nil {
+		return err
+	}
+	if duration > 0 && specs.PeakRealtime(request) <= 0 {
+		return errors.New("only functions with a guaranteed rate hold a lease")
+	}
+	return nil
+}
+
+// stampLease records in the request when its lease expires, so that the
+// expiry is kept in the reservation spec and survives a restart of the
+// gateway. A function which already holds a lease keeps its expiry unless
+// renewed is set.
+func stampLease(request *requests.CreateFunctionRequest, now time.Time, renewed bool) {
+	request.LeaseExpires = ""
+	duration, _, err := parseLease(*request)
+	if err != nil || duration <= 0 {
+		return
+	}
+	expires := now.Add(duration)
+	if held, ok := leaseOf(request.Service); ok && !renewed {
+		expires = held.Expires
+	}
+	request.LeaseExpires = expires.Format(time.RFC3339)
+}
+
+// grantLease sets the lease of the function as requested, or drops it when
+// the function has none. The lease expires as recorded in the request, or
+// a whole lease from now when it is not. A function which already holds a
+// lease keeps its expiry unless renewed is set.
+func grantLease(request requests.CreateFunctionRequest, now time.Time, renewed bool) {
+	duration, policy, err := parseLease(request)
+	if err != nil || duration <= 0 {
+		revokeLease(request.Service)
+		return
+	}
+	granted := &lease{Duration: duration, Policy: policy, Expires: now.Add(duration)}
+	if expires, err := time.Parse(time.RFC3339, request.LeaseExpires); err == nil {
+		granted.Expires = expires
+	}
+	if entry, held := leases.Load(request.Service); held && !renewed {
+		granted.Expires = entry.(*lease).Expires
+	} else if !renewed {
+		// Renewals are kept apart from the reservation spec
+		if expires, ok := GetLeaseStore().Renewed(request.Service); ok && expires.After(granted.Expires) {
+			granted.Expires = expires
+		}
+	} else if err := GetLeaseStore().Delete(request.Service); err != nil {
+		log.Printf("Unable to forget the renewal of the lease of %s: %s", request.Service, err)
+	}
+	leases.Store(request.Service, granted)
+}
+
+// renewLease pushes the expiry of the lease of a function a lease duration
+// away from now
+func renewLease(functionName string, now time.Time) (lease, bool) {
+	entry, held := leases.Load(functionName)
+	if !held {
+		return lease{}, false
+	}
+	renewed := *entry.(*lease)
+	renewed.Expires = now.Add(renewed.Duration)
+	leases.Store(functionName, &renewed)
+	return renewed, true
+}
+
+func revokeLease(functionName string) {
+	if _, held := leases.Load(functionName); !held {
+		return
+	}
+	leases.Delete(functionName)
+	if err := GetLeaseStore().Delete(functionName); err != nil {
+		log.Printf("Unable to forget the renewal of the lease of %s: %s", functionName, err)
+	}
+}
+
+// LeaseStore keeps when the renewed leases expire. Renewing a lease only
+// records the expiry here rather than redeploying the function, the
+// reservation spec catches up at the next update.
+type LeaseStore interface {
+	Save(functionName string, expires time.Time) error
+	Delete(functionName string) error
+	Renewed(functionName string) (time.Time, bool)
+}
+
+// MemoryLeaseStore keeps renewals for the life of the gateway only
+type MemoryLeaseStore struct {
+	renewals sync.Map
+}
+
+func (ls *MemoryLeaseStore) Save(functionName string, expires time.Time) error {
+	ls.renewals.Store(functionName, expires)
+	return nil
+}
+
+func (ls *MemoryLeaseStore) Delete(functionName string) error {
+	ls.renewals.Delete(functionName)
+	return nil
+}
+
+func (ls *MemoryLeaseStore) Renewed(functionName string) (time.Time, bool) {
+	entry, ok := ls.renewals.Load(functionName)
+	if !ok {
+		return time.Time{}, false
+	}
+	return entry.(time.Time), true
+}
+
+// FileLeaseStore keeps the renewal of every lease in a file of Dir
+type FileLeaseStore struct {
+	Dir string
+}
+
+// NewFileLeaseStore creates a store in the directory, creating it if needed
+func NewFileLeaseStore(dir string) (*FileLeaseStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileLeaseStore{Dir: dir}, nil
+}
+
+func (ls *FileLeaseStore) path(functionName string) string {
+	return filepath.Join(ls.Dir, functionName)
+}
+
+func (ls *FileLeaseStore) Save(functionName string, expires time.Time) error {
+	return replaceFile(ls.path(functionName), []byte(expires.Format(time.RFC3339)))
+}
+
+func (ls *FileLeaseStore) Delete(functionName string) error {
+	if err := os.Remove(ls.path(functionName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (ls *FileLeaseStore) Renewed(functionName string) (time.Time, bool) {
+	body, err := ioutil.ReadFile(ls.path(functionName))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Unable to read the renewal of the lease of %s: %s", functionName, err)
+		}
+		return time.Time{}, false
+	}
+	expires, err := time.Parse(time.RFC3339, strings.TrimSpace(string(body)))
+	if err != nil {
+		log.Printf("Skip unreadable renewal of the lease of %s: %s", functionName, err)
+		return time.Time{}, false
+	}
+	return expires, true
+}
+
+var leaseStore LeaseStore
+var leaseStoreOnce sync.Once
+
+// SetupLeaseStore sets the store the renewals of leases are kept in
+func SetupLeaseStore(ls LeaseStore) {
+	leaseStore = ls
+}
+
+// GetLeaseStore returns the store shared by the gateway, renewals are kept
+// in memory until one is set up
+func GetLeaseStore() LeaseStore {
+	leaseStoreOnce.Do(func() {
+		if leaseStore == nil {
+			SetupLeaseStore(&MemoryLeaseStore{})
+		}
+	})
+	return leaseStore
+}
+
+// leaseOf returns the lease of a function
+func leaseOf(functionName string) (lease, bool) {
+	entry, held := leases.Load(functionName)
+	if !held {
+		return lease{}, false
+	}
+	return *entry.(*lease), true
+}
+
+func leaseStatus(l lease) *requests.RealtimeLease {
+	return &requests.RealtimeLease{
+		Duration: l.Duration.String(),
+		Policy:   l.Policy,
+		Expires:  l.Expires.Format(time.RFC3339),
+	}
+}
+
+// LeaseExpirer applies the lease policy of the functions whose lease was
+// not renewed in time
+type LeaseExpirer struct {
+}
+
+// NewLeaseExpirer creates an expirer
+func NewLeaseExpirer() *LeaseExpirer {
+	return &LeaseExpirer{}
+}
+
+// Start expires leases at every interval
+func (e *LeaseExpirer) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			e.Expire(time.Now())
+		}
+	}()
+}
+
+// Expire downgrades or deletes the functions whose lease expired at the
+// given time. A function is tried again on the next round when the policy
+// could not be applied.
+func (e *LeaseExpirer) Expire(now time.Time) {
+	renegotiator := GetRenegotiator()
+	if renegotiator == nil {
+		return
+	}
+	leases.Range(func(key interface{}, value interface{}) bool {
+		functionName := key.(string)
+		expired := *value.(*lease)
+		if now.Before(expired.Expires) || isPending(functionName) {
+			return true
+		}
+		log.Printf("Lease of %s expired at %s, %s", functionName, expired.Expires.Format(time.RFC3339), expired.Policy)
+
+		var statusCode int
+		var body []byte
+		var err error
+		if expired.Policy == requests.LeaseDelete {
+			statusCode, body, err = renegotiator.Remove(functionName)
+		} else {
+			// The deployed spec is rewritten, otherwise the reconciler would
+			// restore the reservation it still holds
+			statusCode, body, err = renegotiator.Downgrade(functionName)
+		}
+		if err != nil || statusCode < 200 || statusCode > 299 {
+			log.Printf("Unable to expire lease of %s: %d %s", functionName, statusCode, body)
+			return true
+		}
+		revokeLease(functionName)
+		recordLeaseExpiry(functionName, expired.Policy)
+		return true
+	})
+}
+
+// MakeRealtimeLeaseHandler renews the lease of a function
+func MakeRealtimeLeaseHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName := mux.Vars(r)["name"]
+		current, held := leaseOf(functionName)
+		if !held {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Function " + functionName + " holds no lease"))
+			return
+		}
+		if !claimPending(functionName) {
+			writeChangeInFlight(w, functionName)
+			return
+		}
+		defer clearPending(functionName)
+
+		// The lease is only renewed once its expiry is kept, so that a
+		// restart of the gateway neither extends nor shortens it
+		now := time.Now()
+		if err := GetLeaseStore().Save(functionName, now.Add(current.Duration)); err != nil {
+			log.Printf("Unable to record the lease of %s: %s", functionName, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		renewed, held := renewLease(functionName, now)
+		if !held {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Function " + functionName + " holds no lease"))
+			return
+		}
+
+		out, err := json.Marshal(leaseStatus(renewed))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(out)
+	}
+}
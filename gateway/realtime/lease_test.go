@@ -0,0 +1,304 @@
+package realtime
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ngduchai/faas/gateway/handlers"
+	"github.com/ngduchai/faas/gateway/requests"
+	"github.com/ngduchai/faas/gateway/scaling"
+)
+
+func Test_ValidateLease(t *testing.T) {
+	cases := []struct {
+		lease    string
+		policy   string
+		realtime float64
+		valid    bool
+	}{
+		{"", "", 0, true},
+		{"24h", "", 10, true},
+		{"30m", requests.LeaseDelete, 10, true},
+		{"forever", "", 10, false},
+		{"-1h", "", 10, false},
+		{"1h", "archive", 10, false},
+		{"1h", "", 0, false},
+	}
+	for _, c := range cases {
+		err := validateLease(requests.CreateFunctionRequest{Service: "lease", Realtime: c.realtime, Lease: c.lease, LeasePolicy: c.policy})
+		if (err == nil) != c.valid {
+			t.Errorf("validateLease %q %q at %v - valid want: %v, got %v", c.lease, c.policy, c.realtime, c.valid, err == nil)
+			t.Fail()
+		}
+	}
+}
+
+func Test_GrantLeaseKeepsExpiryUntilRenewed(t *testing.T) {
+	functionName := "leased"
+	defer revokeLease(functionName)
+	request := requests.CreateFunctionRequest{Service: functionName, Realtime: 1, Lease: "1h"}
+	now := time.Now()
+
+	grantLease(request, now, true)
+	request.LeasePolicy = requests.LeaseDelete
+	grantLease(request, now.Add(30*time.Minute), false)
+	granted, held := leaseOf(functionName)
+	if !held || !granted.Expires.Equal(now.Add(time.Hour)) || granted.Policy != requests.LeaseDelete {
+		t.Errorf("grantLease - want: %s %s, got %v %s %s", now.Add(time.Hour), requests.LeaseDelete, held, granted.Expires, granted.Policy)
+		t.Fail()
+	}
+
+	renewed, _ := renewLease(functionName, now.Add(45*time.Minute))
+	if want := now.Add(105 * time.Minute); !renewed.Expires.Equal(want) {
+		t.Errorf("renewLease - want: %s, got %s", want, renewed.Expires)
+		t.Fail()
+	}
+
+	request.Lease = ""
+	grantLease(request, now, false)
+	if _, held := leaseOf(functionName); held {
+		t.Errorf("grantLease without lease - want: revoked, got held")
+		t.Fail()
+	}
+}
+
+// leasedDeployment deploys a realtime function holding a lease through the
+// reserve admission control, with the renegotiator set up to reach the provider
+func leasedDeployment(t *testing.T, functionName string, policy string) (*admissionProvider, func()) {
+	SetupLedger(1000, 0, PolicyNone)
+	provider := newAdmissionProvider()
+	scaling.GetScalerInstance().Config.ServiceQuery = ReadyServiceQuery{
+		Response: scaling.ServiceQueryResponse{Replicas: 1, AvailableReplicas: 1, Realtime: 2, CPU: 1000, Duration: 100},
+	}
+	ac := ReserveAdmissionControl{}
+	SetupRenegotiator(&Renegotiator{
+		AdmissionControl: ac,
+		Client:           http.DefaultClient,
+		BaseURLResolver:  handlers.SingleHostBaseURLResolver{BaseURL: provider.server.URL},
+		Timeout:          time.Second,
+	})
+
+	spec := admissionSpec(functionName, 2)
+	spec.Lease = "1h"
+	spec.LeasePolicy = policy
+	if code := deploy(ac, provider, http.MethodPost, spec); code != http.StatusAccepted {
+		t.Errorf("Register - status want: %d, got %d", http.StatusAccepted, code)
+		t.FailNow()
+	}
+	return provider, func() {
+		SetupRenegotiator(nil)
+		SetupLedger(0, 0, PolicyEDF)
+		RemoveFunctionHandler(functionName)
+		forgetSpec(functionName)
+		revokeLease(functionName)
+		provider.server.Close()
+	}
+}
+
+func Test_LeaseExpiryDowngrades(t *testing.T) {
+	functionName := "lease-downgrade"
+	provider, cleanup := leasedDeployment(t, functionName, "")
+	defer cleanup()
+
+	if spec := deployedSpec(provider.lastDeployed()); spec.Lease != "1h" {
+		t.Errorf("Register - lease want: %s, got %+v", "1h", spec)
+		t.Fail()
+	}
+
+	// Not expired yet
+	NewLeaseExpirer().Expire(time.Now().Add(30 * time.Minute))
+	if _, reserved := GetLedger().Get(functionName); !reserved {
+		t.Errorf("Expire before expiry - want: reserved, got released")
+		t.Fail()
+	}
+
+	NewLeaseExpirer().Expire(time.Now().Add(2 * time.Hour))
+	if _, reserved := GetLedger().Get(functionName); reserved {
+		t.Errorf("Expire - want: released, got reserved")
+		t.Fail()
+	}
+	deployed := provider.lastDeployed()
+	if spec := deployedSpec(deployed); deployed.Realtime != 0 || spec.QoS != requests.QoSBestEffort || len(spec.Lease) > 0 {
+		t.Errorf("Expire - want: best-effort deployment without lease, got %v %+v", deployed.Realtime, spec)
+		t.Fail()
+	}
+	entry, _ := functionHandlers.Load(functionName)
+	if realtime := entry.(*InvocationHandler).params().Realtime; realtime != 0 {
+		t.Errorf("Expire - handler rate want: %f, got %f", 0.0, realtime)
+		t.Fail()
+	}
+	if _, held := leaseOf(functionName); held {
+		t.Errorf("Expire - want: lease revoked, got held")
+		t.Fail()
+	}
+}
+
+func Test_LeaseExpirySurvivesRestart(t *testing.T) {
+	functionName := "lease-restart"
+	provider, cleanup := leasedDeployment(t, functionName, "")
+	defer cleanup()
+
+	recorded, err := time.Parse(time.RFC3339, deployedSpec(provider.lastDeployed()).LeaseExpires)
+	if err != nil || time.Until(recorded) < 59*time.Minute {
+		t.Errorf("Register - lease expiry want: in 1h, got %v %s", recorded, err)
+		t.FailNow()
+	}
+	restart := func() {
+		deployed := provider.lastDeployed()
+		revokeLease(functionName)
+		forgetSpec(functionName)
+		reconcileFunctions([]reportedFunction{{Function: requests.Function{Name: functionName, Image: deployed.Image, Annotations: deployed.Annotations}}})
+	}
+
+	// The gateway restarts with the expiry it recorded, not a whole lease
+	restart()
+	if held, ok := leaseOf(functionName); !ok || !held.Expires.Equal(recorded) {
+		t.Errorf("Reconcile - lease expiry want: %s, got %v %s", recorded, ok, held.Expires)
+		t.Fail()
+	}
+
+	NewLeaseExpirer().Expire(recorded.Add(time.Minute))
+	if spec := deployedSpec(provider.lastDeployed()); spec.Realtime != 0 || len(spec.Lease) > 0 || len(spec.LeaseExpires) > 0 {
+		t.Errorf("Expire - want: best-effort spec without lease, got %+v", spec)
+		t.Fail()
+	}
+
+	// The downgrade is not undone by the next reconciliation
+	restart()
+	if _, reserved := GetLedger().Get(functionName); reserved {
+		t.Errorf("Reconcile after expiry - want: released, got reserved")
+		t.Fail()
+	}
+	if _, held := leaseOf(functionName); held {
+		t.Errorf("Reconcile after expiry - want: no lease, got held")
+		t.Fail()
+	}
+}
+
+func Test_RealtimeLeaseHandlerRecordsRenewal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "realtime-leases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewFileLeaseStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetupLeaseStore(store)
+	defer SetupLeaseStore(&MemoryLeaseStore{})
+	functionName := "lease-record"
+	provider, cleanup := leasedDeployment(t, functionName, "")
+	defer cleanup()
+	deployed := provider.lastDeployed()
+	granted, _ := leaseOf(functionName)
+
+	provider.sync.Lock()
+	provider.deployed = requests.CreateFunctionRequest{}
+	provider.sync.Unlock()
+	renew := func() int {
+		r := httptest.NewRequest(http.MethodPost, "/system/realtime/"+functionName+"/lease", nil)
+		w := httptest.NewRecorder()
+		MakeRealtimeLeaseHandler()(w, mux.SetURLVars(r, map[string]string{"name": functionName}))
+		return w.Code
+	}
+
+	// A renewal which cannot be kept leaves the lease as it was
+	store.Dir = dir + "/missing"
+	if code := renew(); code != http.StatusInternalServerError {
+		t.Errorf("Renew unrecorded - status want: %d, got %d", http.StatusInternalServerError, code)
+		t.Fail()
+	}
+	if held, _ := leaseOf(functionName); !held.Expires.Equal(granted.Expires) {
+		t.Errorf("Renew unrecorded - expiry want: %s, got %s", granted.Expires, held.Expires)
+		t.Fail()
+	}
+
+	store.Dir = dir
+	time.Sleep(time.Until(granted.Expires.Add(-time.Hour).Add(time.Second)))
+	if code := renew(); code != http.StatusOK {
+		t.Errorf("Renew - status want: %d, got %d", http.StatusOK, code)
+		t.FailNow()
+	}
+	if redeployed := provider.lastDeployed(); len(redeployed.Service) > 0 {
+		t.Errorf("Renew - want: function left as deployed, got %+v", redeployed)
+		t.Fail()
+	}
+	held, _ := leaseOf(functionName)
+	if !held.Expires.After(granted.Expires) {
+		t.Errorf("Renew - expiry want: after %s, got %s", granted.Expires, held.Expires)
+		t.Fail()
+	}
+
+	// The gateway restarts, the renewal outlives the expiry in the spec
+	leases.Delete(functionName)
+	reconcileFunctions([]reportedFunction{{Function: requests.Function{
+		Name:        deployed.Service,
+		Image:       deployed.Image,
+		Labels:      deployed.Labels,
+		Annotations: deployed.Annotations,
+	}}})
+	want := held.Expires.Format(time.RFC3339)
+	if restored, _ := leaseOf(functionName); restored.Expires.Format(time.RFC3339) != want {
+		t.Errorf("Restart - expiry want: %s, got %s", want, restored.Expires.Format(time.RFC3339))
+		t.Fail()
+	}
+}
+
+func Test_LeaseExpiryDeletes(t *testing.T) {
+	functionName := "lease-delete"
+	_, cleanup := leasedDeployment(t, functionName, requests.LeaseDelete)
+	defer cleanup()
+
+	NewLeaseExpirer().Expire(time.Now().Add(2 * time.Hour))
+	if _, reserved := GetLedger().Get(functionName); reserved {
+		t.Errorf("Expire - want: released, got reserved")
+		t.Fail()
+	}
+	if _, handled := functionHandlers.Load(functionName); handled {
+		t.Errorf("Expire - want: handler removed, got handled")
+		t.Fail()
+	}
+	if _, known := lookupSpec(functionName); known {
+		t.Errorf("Expire - want: spec forgotten, got known")
+		t.Fail()
+	}
+}
+
+func Test_RealtimeLeaseHandlerRenews(t *testing.T) {
+	functionName := "lease-renew"
+	defer revokeLease(functionName)
+	handler := MakeRealtimeLeaseHandler()
+	renew := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/system/realtime/"+functionName+"/lease", nil)
+		r = mux.SetURLVars(r, map[string]string{"name": functionName})
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	if w := renew(); w.Code != http.StatusNotFound {
+		t.Errorf("Renew without lease - status want: %d, got %d", http.StatusNotFound, w.Code)
+		t.Fail()
+	}
+
+	grantLease(requests.CreateFunctionRequest{Service: functionName, Realtime: 1, Lease: "1h"}, time.Now().Add(-50*time.Minute), true)
+	w := renew()
+	if w.Code != http.StatusOK {
+		t.Errorf("Renew - status want: %d, got %d", http.StatusOK, w.Code)
+		t.FailNow()
+	}
+	out := requests.RealtimeLease{}
+	json.Unmarshal(w.Body.Bytes(), &out)
+	expires, _ := time.Parse(time.RFC3339, out.Expires)
+	if out.Policy != requests.LeaseDowngrade || time.Until(expires) < 59*time.Minute {
+		t.Errorf("Renew - want: %s expiring in 1h, got %+v", requests.LeaseDowngrade, out)
+		t.Fail()
+	}
+}
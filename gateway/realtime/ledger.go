@@ -0,0 +1,415 @@
+package realtime
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ngduchai/faas/gateway/requests"
+)
+
+// CapacityQuery is implemented by providers that can report the total
+// resources allocatable to functions
+type CapacityQuery interface {
+	GetCapacity() (cpu int64, memory int64, err error)
+}
+
+// Reservation holds the resources committed to a realtime function
+type Reservation struct {
+	// CPU in millicores
+	CPU int64
+	// Memory in bytes
+	Memory int64
+	// Pool the function shares its reservation with
+	Pool string
+	// Task holds the timing requirements used by the schedulability test
+	Task Task
+	// Schedule holds the reservations of the function over time, the
+	// reservation is the one in force when it was committed
+	Schedule *ReservationSchedule
+	// Warm is the number of spare replicas kept ready, their resources are
+	// part of CPU and Memory
+	Warm uint64
+}
+
+// CapacityError reports a reservation that does not fit into the remaining capacity
+type CapacityError struct {
+	Function string
+	// Pool is set instead of Function when a pool budget does not fit
+	Pool      string
+	Resource  string
+	Requested int64
+	Available int64
+	// At is the time a window of a schedule runs short, zero if it does now
+	At time.Time
+}
+
+func (e *CapacityError) Error() string {
+	if len(e.Function) == 0 {
+		return fmt.Sprintf("Insufficient %s capacity for pool %s: requested %d, available %d",
+			e.Resource, e.Pool, e.Requested, e.Available)
+	}
+	message := fmt.Sprintf("Insufficient %s capacity for function %s: requested %d, available %d",
+		e.Resource, e.Function, e.Requested, e.Available)
+	if !e.At.IsZero() {
+		message += " at " + e.At.Format(time.RFC3339)
+	}
+	return message
+}
+
+// CapacityLedger tracks the resources reserved by every realtime function
+// against the total allocatable capacity of the cluster. A zero total means
+// the corresponding resource is not limited.
+type CapacityLedger struct {
+	TotalCPU    int64
+	TotalMemory int64
+	// Policy selects the schedulability test run over every pool
+	Policy       string
+	reservations map[string]Reservation
+	// pools holds the budgets of the declared pools, members of a declared
+	// pool are accounted against its budget rather than the capacity
+	pools map[string]PoolBudget
+	// occupied holds what the replicas of the best-effort functions which
+	// may be degraded hold, it counts against the capacity left to
+	// reservations
+	occupied map[string]occupancy
+	sync     sync.Mutex
+}
+
+// NewCapacityLedger creates an empty ledger with the given capacity
+func NewCapacityLedger(totalCPU int64, totalMemory int64) *CapacityLedger {
+	return &CapacityLedger{
+		TotalCPU:     totalCPU,
+		TotalMemory:  totalMemory,
+		Policy:       PolicyEDF,
+		reservations: make(map[string]Reservation),
+		pools:        make(map[string]PoolBudget),
+		occupied:     make(map[string]occupancy),
+	}
+}
+
+// Reserve commits the reservation for a function, replacing any reservation
+// it already holds. The previous reservation is returned so that callers can
+// restore it if the deployment fails later on.
+func (l *CapacityLedger) Reserve(functionName string, res Reservation) (Reservation, bool, error) {
+	return l.reserve(functionName, res, 1, nil)
+}
+
+// Overcommit commits the reservation for a function as long as the nominal
+// reservations stay within ratio times the capacity and the reservations,
+// weighted by the utilization observed for each function, fit the capacity
+func (l *CapacityLedger) Overcommit(functionName string, res Reservation, ratio float64, utilization func(string) float64) (Reservation, bool, error) {
+	return l.reserve(functionName, res, ratio, utilization)
+}
+
+func (l *CapacityLedger) reserve(functionName string, res Reservation, ratio float64, utilization func(string) float64) (Reservation, bool, error) {
+	l.sync.Lock()
+	defer l.sync.Unlock()
+
+	prev, existed := l.reservations[functionName]
+	if err := l.admit(functionName, res, ratio, utilization); err != nil {
+		return prev, existed, err
+	}
+	l.reservations[functionName] = res
+	return prev, existed, nil
+}
+
+// admits tells why the reservation would be rejected, nil if it would be
+// admitted, without committing it
+func (l *CapacityLedger) admits(functionName string, res Reservation, ratio float64, utilization func(string) float64) error {
+	l.sync.Lock()
+	defer l.sync.Unlock()
+
+	return l.admit(functionName, res, ratio, utilization)
+}
+
+// admit checks the reservation fits along with the others. The caller holds
+// the lock.
+func (l *CapacityLedger) admit(functionName string, res Reservation, ratio float64, utilization func(string) float64) error {
+	prev := l.reservations[functionName]
+	if budget, declared := l.pools[res.Pool]; declared {
+		if err := l.fitPool(functionName, res, budget, time.Time{}); err != nil {
+			return err
+		}
+		if err := l.checkSchedulability(functionName, res, utilization, time.Time{}); err != nil {
+			return err
+		}
+		return l.checkSchedule(functionName, res, 1)
+	}
+	usedCPU, usedMemory := l.committed()
+	if !l.pooled(prev) {
+		usedCPU -= prev.CPU
+		usedMemory -= prev.Memory
+	}
+	occupiedCPU, occupiedMemory := l.occupiedTotal()
+	usedCPU += occupiedCPU
+	usedMemory += occupiedMemory
+
+	limitCPU := int64(float64(l.TotalCPU) * ratio)
+	limitMemory := int64(float64(l.TotalMemory) * ratio)
+	if l.TotalCPU > 0 && usedCPU+res.CPU > limitCPU {
+		return &CapacityError{
+			Function:  functionName,
+			Resource:  "cpu",
+			Requested: res.CPU,
+			Available: limitCPU - usedCPU,
+		}
+	}
+	if l.TotalMemory > 0 && usedMemory+res.Memory > limitMemory {
+		return &CapacityError{
+			Function:  functionName,
+			Resource:  "memory",
+			Requested: res.Memory,
+			Available: limitMemory - usedMemory,
+		}
+	}
+
+	if utilization != nil {
+		observedCPU, observedMemory := l.observed(functionName, utilization)
+		if l.TotalCPU > 0 && observedCPU+res.CPU > l.TotalCPU {
+			return &CapacityError{
+				Function:  functionName,
+				Resource:  "cpu",
+				Requested: res.CPU,
+				Available: l.TotalCPU - observedCPU,
+			}
+		}
+		if l.TotalMemory > 0 && observedMemory+res.Memory > l.TotalMemory {
+			return &CapacityError{
+				Function:  functionName,
+				Resource:  "memory",
+				Requested: res.Memory,
+				Available: l.TotalMemory - observedMemory,
+			}
+		}
+	}
+
+	if err := l.checkSchedulability(functionName, res, utilization, time.Time{}); err != nil {
+		return err
+	}
+	return l.checkSchedule(functionName, res, ratio)
+}
+
+// observed returns the CPU and memory the other functions use given their
+// utilization, declared pools are assumed to use their whole budget
+func (l *CapacityLedger) observed(functionName string, utilization func(string) float64) (int64, int64) {
+	cpu := 0.0
+	memory := 0.0
+	for _, budget := range l.pools {
+		cpu += float64(budget.CPU)
+		memory += float64(budget.Memory)
+	}
+	for name, other := range l.reservations {
+		if name != functionName && !l.pooled(other) {
+			u := utilization(name)
+			cpu += float64(other.CPU) * u
+			memory += float64(other.Memory) * u
+		}
+	}
+	return int64(math.Ceil(cpu)), int64(math.Ceil(memory))
+}
+
+// checkSchedulability runs the schedulability test over the pool the
+// reservation belongs to as if the reservation was admitted, with the
+// other reservations in force at the given time. When a utilization is
+// given, the rate of the other functions is scaled by it.
+func (l *CapacityLedger) checkSchedulability(functionName string, res Reservation, utilization func(string) float64, at time.Time) error {
+	if _, declared := l.pools[res.Pool]; (l.TotalCPU == 0 && !declared) || l.Policy == PolicyNone {
+		return nil
+	}
+
+	pool := poolName(res.Pool)
+	tasks := []Task{res.Task}
+	for name, other := range l.reservations {
+		if name != functionName && poolName(other.Pool) == pool {
+			task := other.At(at).Task
+			if utilization != nil {
+				task.Rate *= utilization(name)
+			}
+			tasks = append(tasks, task)
+		}
+	}
+
+	processors := float64(l.TotalCPU) / 1000
+	if budget, declared := l.pools[res.Pool]; declared {
+		processors = float64(budget.CPU) / 1000
+	}
+	utilizationSum, bound, schedulable := CheckSchedulability(l.Policy, tasks, processors)
+	if !schedulable {
+		return &SchedulabilityError{
+			Function:    functionName,
+			Pool:        pool,
+			Policy:      l.Policy,
+			Utilization: utilizationSum,
+			Bound:       bound,
+			At:          at,
+		}
+	}
+	return nil
+}
+
+func poolName(pool string) string {
+	if len(pool) == 0 {
+		return DefaultPool
+	}
+	return pool
+}
+
+// Release frees the reservation held by a function
+func (l *CapacityLedger) Release(functionName string) (Reservation, bool) {
+	l.sync.Lock()
+	defer l.sync.Unlock()
+
+	res, existed := l.reservations[functionName]
+	delete(l.reservations, functionName)
+	return res, existed
+}
+
+// Restore puts back a reservation returned by Reserve or Release
+func (l *CapacityLedger) Restore(functionName string, res Reservation, existed bool) {
+	l.sync.Lock()
+	defer l.sync.Unlock()
+
+	if existed {
+		l.reservations[functionName] = res
+	} else {
+		delete(l.reservations, functionName)
+	}
+}
+
+// Get returns the reservation held by a function
+func (l *CapacityLedger) Get(functionName string) (Reservation, bool) {
+	l.sync.Lock()
+	defer l.sync.Unlock()
+
+	res, existed := l.reservations[functionName]
+	return res, existed
+}
+
+// Functions returns the names of the functions holding a reservation
+func (l *CapacityLedger) Functions() []string {
+	l.sync.Lock()
+	defer l.sync.Unlock()
+
+	names := make([]string, 0, len(l.reservations))
+	for name := range l.reservations {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Committed returns the total CPU and memory reserved by all functions,
+// declared pools count for their budget
+func (l *CapacityLedger) Committed() (int64, int64) {
+	l.sync.Lock()
+	defer l.sync.Unlock()
+
+	return l.committed()
+}
+
+func (l *CapacityLedger) committed() (int64, int64) {
+	return l.committedAt(time.Time{})
+}
+
+// committedAt returns the CPU and memory reserved at the given time
+func (l *CapacityLedger) committedAt(at time.Time) (int64, int64) {
+	cpu := int64(0)
+	memory := int64(0)
+	for _, budget := range l.pools {
+		cpu += budget.CPU
+		memory += budget.Memory
+	}
+	for _, res := range l.reservations {
+		if !l.pooled(res) {
+			res = res.At(at)
+			cpu += res.CPU
+			memory += res.Memory
+		}
+	}
+	return cpu, memory
+}
+
+// ResolveCapacity determines the capacity managed by the ledger. Configured
+// quantities take precedence, missing ones are requested from the provider.
+func ResolveCapacity(cpu string, memory string, provider CapacityQuery) (int64, int64, error) {
+	rm := ResourceManager{}
+	totalCPU := int64(0)
+	totalMemory := int64(0)
+	var err error
+
+	if len(cpu) > 0 {
+		if totalCPU, err = rm.GetCPUQuantity(cpu); err != nil {
+			return 0, 0, err
+		}
+	}
+	if len(memory) > 0 {
+		if totalMemory, err = rm.GetMemoryQuantity(memory); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if (totalCPU == 0 || totalMemory == 0) && provider != nil {
+		providerCPU, providerMemory, queryErr := provider.GetCapacity()
+		if queryErr != nil {
+			log.Printf("Unable to obtain capacity from provider: %s", queryErr)
+		} else {
+			if totalCPU == 0 {
+				totalCPU = providerCPU
+			}
+			if totalMemory == 0 {
+				totalMemory = providerMemory
+			}
+		}
+	}
+
+	return totalCPU, totalMemory, nil
+}
+
+// reservationFor computes the resources a function needs to sustain its
+// guaranteed invocation rate, given invocations run for the measured demand
+// or, when none is set, for the timeout, and use the observed memory or the
+// declared one. The spare replicas of the warm pool are charged on top.
+func reservationFor(request requests.CreateFunctionRequest, cpus int64, memory int64) Reservation {
+	duration := request.Timeout
+	if request.Demand > 0 {
+		duration = request.Demand
+	}
+	if request.DemandMemory > 0 {
+		memory = request.DemandMemory
+	}
+	return withWarmPool(Reservation{
+		CPU:    int64(request.Realtime * float64(cpus) * float64(duration) / 1000),
+		Memory: int64(request.Realtime * float64(memory) * float64(duration) / 1000),
+		Pool:   request.Pool,
+		Task: Task{
+			Rate:   request.Realtime,
+			Budget: duration,
+			CPU:    cpus,
+			Memory: memory,
+		},
+	})
+}
+
+var ledgerInstance *CapacityLedger
+var ledgerOnce sync.Once
+
+// SetupLedger sets the capacity available to realtime functions and the
+// schedulability policy applied to them
+func SetupLedger(totalCPU int64, totalMemory int64, policy string) {
+	ledgerInstance = NewCapacityLedger(totalCPU, totalMemory)
+	if len(policy) > 0 {
+		ledgerInstance.Policy = policy
+	}
+}
+
+// GetLedger returns the ledger shared by the gateway
+func GetLedger() *CapacityLedger {
+	ledgerOnce.Do(func() {
+		if ledgerInstance == nil {
+			SetupLedger(0, 0, PolicyEDF)
+		}
+	})
+	return ledgerInstance
+}
@@ -0,0 +1,162 @@
+package realtime
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+type TestCapacityQuery struct {
+	CPU    int64
+	Memory int64
+	Err    error
+}
+
+func (cq TestCapacityQuery) GetCapacity() (int64, int64, error) {
+	return cq.CPU, cq.Memory, cq.Err
+}
+
+func Test_LedgerReserveWithinCapacity(t *testing.T) {
+	ledger := NewCapacityLedger(1000, 1000)
+
+	_, existed, err := ledger.Reserve("f1", Reservation{CPU: 600, Memory: 100})
+	if err != nil {
+		t.Errorf("Reserve - f1 error, want: %s, got %s", "nil", err.Error())
+		t.Fail()
+	}
+	if existed {
+		t.Errorf("Reserve - f1 existed, want: %v, got %v", false, existed)
+		t.Fail()
+	}
+
+	_, _, err = ledger.Reserve("f2", Reservation{CPU: 400, Memory: 900})
+	if err != nil {
+		t.Errorf("Reserve - f2 error, want: %s, got %s", "nil", err.Error())
+		t.Fail()
+	}
+
+	cpu, memory := ledger.Committed()
+	if cpu != 1000 || memory != 1000 {
+		t.Errorf("Committed - want: %d %d, got %d %d", 1000, 1000, cpu, memory)
+		t.Fail()
+	}
+}
+
+func Test_LedgerRejectsOverReservation(t *testing.T) {
+	ledger := NewCapacityLedger(1000, 1000)
+	ledger.Reserve("f1", Reservation{CPU: 600, Memory: 100})
+
+	_, _, err := ledger.Reserve("f2", Reservation{CPU: 500, Memory: 100})
+	capacityErr, ok := err.(*CapacityError)
+	if !ok {
+		t.Errorf("Reserve - f2 error, want: %s, got %v", "CapacityError", err)
+		t.FailNow()
+	}
+	if capacityErr.Resource != "cpu" || capacityErr.Available != 400 {
+		t.Errorf("Reserve - f2 error, want: %s %d, got %s %d", "cpu", 400, capacityErr.Resource, capacityErr.Available)
+		t.Fail()
+	}
+
+	_, _, err = ledger.Reserve("f2", Reservation{CPU: 100, Memory: 1000})
+	if capacityErr, ok = err.(*CapacityError); !ok || capacityErr.Resource != "memory" {
+		t.Errorf("Reserve - f2 memory error, want: %s, got %v", "memory", err)
+		t.Fail()
+	}
+
+	if _, exists := ledger.Get("f2"); exists {
+		t.Errorf("Reserve - rejected reservation must not be recorded")
+		t.Fail()
+	}
+}
+
+func Test_LedgerUpdateReplacesReservation(t *testing.T) {
+	ledger := NewCapacityLedger(1000, 0)
+	ledger.Reserve("f1", Reservation{CPU: 800})
+
+	prev, existed, err := ledger.Reserve("f1", Reservation{CPU: 1000})
+	if err != nil {
+		t.Errorf("Reserve - update error, want: %s, got %s", "nil", err.Error())
+		t.Fail()
+	}
+	if !existed || prev.CPU != 800 {
+		t.Errorf("Reserve - previous reservation, want: %d, got %d", 800, prev.CPU)
+		t.Fail()
+	}
+
+	ledger.Restore("f1", prev, existed)
+	if res, _ := ledger.Get("f1"); res.CPU != 800 {
+		t.Errorf("Restore - want: %d, got %d", 800, res.CPU)
+		t.Fail()
+	}
+}
+
+func Test_LedgerRelease(t *testing.T) {
+	ledger := NewCapacityLedger(1000, 1000)
+	ledger.Reserve("f1", Reservation{CPU: 1000, Memory: 1000})
+
+	res, existed := ledger.Release("f1")
+	if !existed || res.CPU != 1000 {
+		t.Errorf("Release - want: %d, got %d", 1000, res.CPU)
+		t.Fail()
+	}
+
+	if _, _, err := ledger.Reserve("f2", Reservation{CPU: 1000, Memory: 1000}); err != nil {
+		t.Errorf("Reserve - after release, want: %s, got %s", "nil", err.Error())
+		t.Fail()
+	}
+}
+
+func Test_LedgerConcurrentReservations(t *testing.T) {
+	ledger := NewCapacityLedger(1000, 0)
+	wg := sync.WaitGroup{}
+	admitted := make(chan string, 100)
+
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("f%d", i)
+			if _, _, err := ledger.Reserve(name, Reservation{CPU: 100}); err == nil {
+				admitted <- name
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(admitted)
+
+	if len(admitted) != 10 {
+		t.Errorf("Concurrent reservations - admitted want: %d, got %d", 10, len(admitted))
+		t.Fail()
+	}
+	if cpu, _ := ledger.Committed(); cpu != 1000 {
+		t.Errorf("Concurrent reservations - committed want: %d, got %d", 1000, cpu)
+		t.Fail()
+	}
+}
+
+func Test_ResolveCapacity(t *testing.T) {
+	provider := TestCapacityQuery{CPU: 8000, Memory: 2048}
+
+	cpu, memory, err := ResolveCapacity("4", "", provider)
+	if err != nil {
+		t.Errorf("ResolveCapacity - error want: %s, got %s", "nil", err.Error())
+		t.Fail()
+	}
+	if cpu != 4000 || memory != 2048 {
+		t.Errorf("ResolveCapacity - want: %d %d, got %d %d", 4000, 2048, cpu, memory)
+		t.Fail()
+	}
+
+	provider.Err = errors.New("not supported")
+	cpu, memory, _ = ResolveCapacity("", "", provider)
+	if cpu != 0 || memory != 0 {
+		t.Errorf("ResolveCapacity - unlimited want: %d %d, got %d %d", 0, 0, cpu, memory)
+		t.Fail()
+	}
+
+	if _, _, err = ResolveCapacity("lots", "", nil); err == nil {
+		t.Errorf("ResolveCapacity - invalid quantity, want: %s, got %s", "error", "nil")
+		t.Fail()
+	}
+}
@@ -0,0 +1,157 @@
+package realtime
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ngduchai/faas/gateway/metrics"
+)
+
+// realtimeMetrics is where realtime functions report, nil disables reporting
+var realtimeMetrics *metrics.RealtimeMetricOptions
+
+// concurrencyMetrics is where concurrency limiters report, nil disables
+// reporting
+var concurrencyMetrics *metrics.ConcurrencyMetricOptions
+
+// SetMetrics makes realtime functions report to the given metrics
+func SetMetrics(options metrics.MetricOptions) {
+	realtimeMetrics = options.RealtimeMetrics
+	concurrencyMetrics = options.ConcurrencyMetrics
+}
+
+// Reasons an invocation is turned away
+const (
+	rejectQueueFull = "queue_full"
+	rejectQueueWait = "queue_wait_budget"
+	rejectDeadline  = "deadline"
+)
+
+// recordDispatch reports an invocation released to the provider after
+// waiting in the queue
+func recordDispatch(handler *InvocationHandler, wait time.Duration, now time.Time) {
+	handler.achieved.mark(now)
+	if realtimeMetrics == nil {
+		return
+	}
+	realtimeMetrics.Dispatched.WithLabelValues(handler.Name).Inc()
+	realtimeMetrics.QueueWait.WithLabelValues(handler.Name).Observe(wait.Seconds())
+}
+
+// recordOpportunistic reports an invocation of a burstable function
+// dispatched beyond its guaranteed rate
+func recordOpportunistic(functionName string) {
+	if realtimeMetrics == nil {
+		return
+	}
+	realtimeMetrics.Opportunistic.WithLabelValues(functionName).Inc()
+}
+
+// recordRejection reports an invocation turned away by the gateway
+func recordRejection(functionName string, reason string) {
+	if realtimeMetrics == nil {
+		return
+	}
+	realtimeMetrics.Rejected.WithLabelValues(functionName, reason).Inc()
+}
+
+// recordConcurrencyRejection counts an invocation answered 429 beyond the
+// concurrency limit of the function
+func recordConcurrencyRejection(functionName string) {
+	if concurrencyMetrics == nil {
+		return
+	}
+	concurrencyMetrics.Rejected.WithLabelValues(functionName).Inc()
+}
+
+// recordLeaseExpiry counts a lease which expired and the policy applied
+func recordLeaseExpiry(functionName string, policy string) {
+	if realtimeMetrics == nil {
+		return
+	}
+	realtimeMetrics.LeaseExpired.WithLabelValues(functionName, policy).Inc()
+}
+
+// recordDeadline counts whether an invocation of the function met its
+// deadline, invocations without one are not counted
+func recordDeadline(functionName string, deadline time.Time, met bool) {
+	if realtimeMetrics == nil || deadline.IsZero() {
+		return
+	}
+	outcome := "hit"
+	if !met {
+		outcome = "miss"
+	}
+	realtimeMetrics.Deadline.WithLabelValues(functionName, outcome).Inc()
+}
+
+// Stats returns a snapshot of every realtime function for the exporter
+func Stats() []metrics.RealtimeStats {
+	now := time.Now()
+	stats := []metrics.RealtimeStats{}
+	functionHandlers.Range(func(key interface{}, value interface{}) bool {
+		handler := value.(*InvocationHandler)
+		params := handler.params()
+		if params.Realtime <= 0 {
+			return true
+		}
+		handler.sync.Lock()
+		syncQueue, asyncQueue := len(handler.syncInvs), len(handler.asyncInvs)
+		handler.sync.Unlock()
+		handlerStats := metrics.RealtimeStats{
+			Function:       handler.Name,
+			SyncQueue:      syncQueue,
+			AsyncQueue:     asyncQueue,
+			GuaranteedRate: params.Realtime,
+			AchievedRate:   handler.achieved.rate(now),
+		}
+		if l, held := leaseOf(handler.Name); held {
+			handlerStats.Leased = true
+			handlerStats.LeaseRemaining = math.Max(0, l.Expires.Sub(now).Seconds())
+		}
+		stats = append(stats, handlerStats)
+		return true
+	})
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Function < stats[j].Function })
+	return stats
+}
+
+// rateWindow is the time constant over which the achieved rate is averaged
+const rateWindow = 10 * time.Second
+
+// rateMeter estimates an event rate as an exponentially weighted moving
+// average which keeps decaying while no event happens
+type rateMeter struct {
+	value float64
+	last  time.Time
+	sync  sync.Mutex
+}
+
+func (m *rateMeter) decay(now time.Time) {
+	if now.After(m.last) {
+		if !m.last.IsZero() {
+			m.value *= math.Exp(-now.Sub(m.last).Seconds() / rateWindow.Seconds())
+		}
+		m.last = now
+	}
+}
+
+// mark records an event at the given time
+func (m *rateMeter) mark(now time.Time) {
+	m.sync.Lock()
+	defer m.sync.Unlock()
+
+	m.decay(now)
+	m.value += 1 / rateWindow.Seconds()
+}
+
+// rate returns the events per second at the given time
+func (m *rateMeter) rate(now time.Time) float64 {
+	m.sync.Lock()
+	defer m.sync.Unlock()
+
+	m.decay(now)
+	return m.value
+}
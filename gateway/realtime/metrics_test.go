@@ -0,0 +1,78 @@
+package realtime
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ngduchai/faas/gateway/metrics"
+	"github.com/ngduchai/faas/gateway/requests"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func counterValue(counter prometheus.Counter) float64 {
+	m := &dto.Metric{}
+	counter.Write(m)
+	return m.GetCounter().GetValue()
+}
+
+func Test_RateMeterConvergesAndDecays(t *testing.T) {
+	meter := rateMeter{}
+	start := time.Now()
+	now := start
+	for i := 0; i < 600; i++ {
+		now = start.Add(time.Duration(i) * 100 * time.Millisecond)
+		meter.mark(now)
+	}
+	if rate := meter.rate(now); math.Abs(rate-10) > 0.5 {
+		t.Errorf("rateMeter - rate want: ~%f, got %f", 10.0, rate)
+		t.Fail()
+	}
+	if rate := meter.rate(now.Add(time.Minute)); rate > 0.1 {
+		t.Errorf("rateMeter - idle rate want: ~%f, got %f", 0.0, rate)
+		t.Fail()
+	}
+}
+
+func Test_InvokeReportsRealtimeMetrics(t *testing.T) {
+	SetMetrics(metrics.BuildMetricsOptions())
+	defer func() { realtimeMetrics = nil }()
+
+	functionName := "invoke-metrics"
+	SetFunctionHandler(requests.CreateFunctionRequest{Service: functionName, Realtime: 0.5})
+	defer RemoveFunctionHandler(functionName)
+	entry, _ := functionHandlers.Load(functionName)
+	entry.(*InvocationHandler).BufferSize = 0
+
+	next := func(w http.ResponseWriter, r *http.Request) {}
+	for i := 0; i < 2; i++ {
+		Invoke(next, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/function/"+functionName, nil))
+	}
+
+	if got := counterValue(realtimeMetrics.Dispatched.WithLabelValues(functionName)); got != 1 {
+		t.Errorf("Invoke - dispatched want: %d, got %f", 1, got)
+		t.Fail()
+	}
+	if got := counterValue(realtimeMetrics.Rejected.WithLabelValues(functionName, rejectQueueFull)); got != 1 {
+		t.Errorf("Invoke - rejected want: %d, got %f", 1, got)
+		t.Fail()
+	}
+
+	found := false
+	for _, stats := range Stats() {
+		if stats.Function == functionName {
+			found = true
+			if stats.GuaranteedRate != 0.5 || stats.AchievedRate <= 0 || stats.SyncQueue != 0 {
+				t.Errorf("Stats - unexpected stats %+v", stats)
+				t.Fail()
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Stats - %s is missing", functionName)
+		t.Fail()
+	}
+}
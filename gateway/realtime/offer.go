@@ -0,0 +1,184 @@
+package realtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/ngduchai/faas/gateway/requests"
+)
+
+// Offer is what the ledger could admit in place of a rejected reservation:
+// either the requested invocation size at a lower rate, or the requested
+// rate with smaller invocations
+type Offer struct {
+	// Realtime is the highest rate admissible at the requested size
+	Realtime float64
+	// CPU (millicores) and Memory (bytes) are the largest invocation size
+	// admissible at the requested rate
+	CPU    int64
+	Memory int64
+}
+
+// CounterOffer searches the highest rate at the requested invocation size,
+// and the largest invocation size at the requested rate, that the ledger
+// admits for the request. The request is the one admission sized, CPU in
+// millicores and memory in bytes are the declared size of an invocation.
+func (l *CapacityLedger) CounterOffer(request requests.CreateFunctionRequest, cpus int64, memory int64) Offer {
+	return l.counterOffer(request, cpus, memory, time.Time{}, 1, nil)
+}
+
+// counterOffer searches the counter-offer with the overbooking ratio and
+// utilization admission applies. Every candidate is sized the way the
+// request was, right-sizing, warm pool and schedule included, and put
+// through the admission check. When at is set, the rate offered is the
+// one in force at that time rather than now.
+func (l *CapacityLedger) counterOffer(request requests.CreateFunctionRequest, cpus int64, memory int64, at time.Time, ratio float64, utilization func(string) float64) Offer {
+	if at.IsZero() {
+		at = time.Now()
+	}
+	fits := func(candidate requests.CreateFunctionRequest, cpus int64, memory int64) bool {
+		res, err := scheduledReservation(candidate, cpus, memory, time.Now())
+		return err == nil && l.admits(request.Service, res, ratio, utilization) == nil
+	}
+	rate := scheduledRate(request, at)
+	// The largest invocation is found CPU first, then memory for that CPU
+	withoutMemory := request
+	withoutMemory.DemandMemory = 0
+	cpu := int64(largest(cpus, func(n int64) bool {
+		return fits(withoutMemory, n, 0)
+	}))
+
+	return Offer{
+		Realtime: largest(int64(rate*1000), func(n int64) bool {
+			return fits(withRateAt(request, float64(n)/1000, at), cpus, memory)
+		}) / 1000,
+		CPU: cpu,
+		Memory: int64(largest(memory, func(n int64) bool {
+			// The memory an invocation is sized for follows the declared one
+			candidate := request
+			ResourceManager{}.RightSize(&candidate, n)
+			return fits(candidate, cpu, n)
+		})),
+	}
+}
+
+// largest returns the largest value up to max that fits, zero if none does.
+// Candidates are assumed to fit less the larger they are.
+func largest(max int64, fits func(int64) bool) float64 {
+	if max <= 0 || fits(max) {
+		return math.Max(0, float64(max))
+	}
+	low, high := int64(0), max
+	for high-low > 1 {
+		if middle := low + (high-low)/2; fits(middle) {
+			low = middle
+		} else {
+			high = middle
+		}
+	}
+	return float64(low)
+}
+
+// withRateAt returns the request with the rate in force at the given time,
+// the one of the open window with the highest rate or the base rate,
+// changed to rate
+func withRateAt(request requests.CreateFunctionRequest, rate float64, at time.Time) requests.CreateFunctionRequest {
+	schedule := append([]requests.RealtimeWindow{}, request.Schedule...)
+	open := -1
+	for i, w := range schedule {
+		window, err := parseWindow(w)
+		if err == nil && window.activeAt(at) && (open < 0 || w.Realtime > schedule[open].Realtime) {
+			open = i
+		}
+	}
+	if open < 0 {
+		request.Realtime = rate
+	} else {
+		schedule[open].Realtime = rate
+	}
+	if len(schedule) > 0 {
+		request.Schedule = schedule
+	}
+	return request
+}
+
+// rejectionReason names the constraint that rejected a reservation
+func rejectionReason(err error) string {
+	switch err.(type) {
+	case *CapacityError:
+		return "capacity"
+	case *SchedulabilityError:
+		return "schedulability"
+	case *PoolError:
+		return "pool"
+	default:
+		return "admission"
+	}
+}
+
+// rejectedAt returns the time a window of a schedule runs short, zero if
+// the reservation is rejected now
+func rejectedAt(err error) time.Time {
+	switch e := err.(type) {
+	case *CapacityError:
+		return e.At
+	case *PoolError:
+		return e.At
+	case *SchedulabilityError:
+		return e.At
+	default:
+		return time.Time{}
+	}
+}
+
+// writeRejection answers a rejected request with a counter-offer the
+// caller can deploy instead, checked the way the request was. When a window
+// is rejected, the offer is for the window.
+func (ac ReserveAdmissionControl) writeRejection(w http.ResponseWriter, request requests.CreateFunctionRequest, cpus int64, memory int64, err error) int {
+	at := rejectedAt(err)
+	offer := GetLedger().counterOffer(request, cpus, memory, at, ac.ratio(), ac.Utilization)
+	rate := request.Realtime
+	if !at.IsZero() {
+		rate = scheduledRate(request, at)
+	} else if len(request.Schedule) > 0 {
+		rate = scheduledRate(request, time.Now())
+	}
+	declared := requests.FunctionResources{
+		CPU:    fmt.Sprintf("%dm", cpus),
+		Memory: fmt.Sprint(memory),
+	}
+	rejection := requests.ReservationRejection{
+		Message:  err.Error(),
+		Function: request.Service,
+		Reason:   rejectionReason(err),
+		Requested: requests.RealtimeOffer{
+			Realtime:  rate,
+			Resources: declared,
+		},
+		AtRequestedSize: requests.RealtimeOffer{
+			Realtime:  offer.Realtime,
+			Resources: declared,
+		},
+		AtRequestedRate: requests.RealtimeOffer{
+			Realtime: rate,
+			Resources: requests.FunctionResources{
+				CPU:    fmt.Sprintf("%dm", offer.CPU),
+				Memory: fmt.Sprint(offer.Memory),
+			},
+		},
+	}
+
+	if !at.IsZero() {
+		rejection.At = at.Format(time.RFC3339)
+	}
+
+	statusCode := http.StatusConflict
+	body, _ := json.Marshal(rejection)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(body)
+	return statusCode
+}
@@ -0,0 +1,129 @@
+package realtime
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ngduchai/faas/gateway/requests"
+	"github.com/ngduchai/faas/gateway/scaling"
+)
+
+func Test_CounterOfferFitsRemainingCapacity(t *testing.T) {
+	ledger := NewCapacityLedger(1000, 4000)
+	ledger.Reserve("other", reservationFor(requests.CreateFunctionRequest{Realtime: 6, Timeout: 1000}, 100, 100))
+
+	request := requests.CreateFunctionRequest{Service: "rejected", Realtime: 10, Timeout: 1000}
+	res := reservationFor(request, 100, 100)
+	if _, _, err := ledger.Reserve("rejected", res); err == nil {
+		t.Errorf("Reserve - error want: %s, got %s", "error", "nil")
+		t.FailNow()
+	}
+
+	offer := ledger.CounterOffer(request, 100, 100)
+	if offer.Realtime != 4 || offer.CPU != 40 || offer.Memory != 100 {
+		t.Errorf("CounterOffer - want: %+v, got %+v", Offer{Realtime: 4, CPU: 40, Memory: 100}, offer)
+		t.Fail()
+	}
+
+	// The rate offered is admitted
+	request.Realtime = offer.Realtime
+	if _, _, err := ledger.Reserve("rejected", reservationFor(request, 100, 100)); err != nil {
+		t.Errorf("Reserve offered rate - error want: %s, got %s", "nil", err.Error())
+		t.Fail()
+	}
+}
+
+func Test_CounterOfferHonoursRateMonotonicBound(t *testing.T) {
+	ledger := NewCapacityLedger(1000, 0)
+	ledger.Policy = PolicyRM
+	ledger.Reserve("other", reservationFor(requests.CreateFunctionRequest{Realtime: 5, Timeout: 100}, 1000, 0))
+
+	offer := ledger.CounterOffer(requests.CreateFunctionRequest{Service: "rejected", Realtime: 5, Timeout: 100}, 1000, 0)
+
+	// Two tasks are bound by 2(sqrt(2)-1) = 0.828, the other one uses 0.5
+	if offer.Realtime < 3.28 || offer.Realtime > 3.29 {
+		t.Errorf("CounterOffer - rate want: ~%f, got %f", 3.28, offer.Realtime)
+		t.Fail()
+	}
+}
+
+func Test_WriteRejectionReturnsCounterOffer(t *testing.T) {
+	SetupLedger(500, 0, PolicyEDF)
+	defer SetupLedger(0, 0, PolicyEDF)
+
+	request := requests.CreateFunctionRequest{Service: "rejected", Realtime: 10, Timeout: 100}
+	_, _, err := GetLedger().Reserve("rejected", reservationFor(request, 1000, 64))
+
+	w := httptest.NewRecorder()
+	if statusCode := (ReserveAdmissionControl{}).writeRejection(w, request, 1000, 64, err); statusCode != http.StatusConflict {
+		t.Errorf("writeRejection - status want: %d, got %d", http.StatusConflict, statusCode)
+		t.Fail()
+	}
+	rejection := requests.ReservationRejection{}
+	if err := json.Unmarshal(w.Body.Bytes(), &rejection); err != nil {
+		t.Errorf("writeRejection - body error want: %s, got %s", "nil", err.Error())
+		t.FailNow()
+	}
+	if rejection.Reason != "capacity" || rejection.AtRequestedSize.Realtime != 5 || rejection.AtRequestedRate.Resources.CPU != "500m" {
+		t.Errorf("writeRejection - unexpected counter-offer %+v", rejection)
+		t.Fail()
+	}
+}
+
+func Test_CounterOfferAppliesOvercommit(t *testing.T) {
+	SetupLedger(1000, 0, PolicyEDF)
+	defer SetupLedger(0, 0, PolicyEDF)
+	GetLedger().Reserve("other", reservationFor(requests.CreateFunctionRequest{Realtime: 6, Timeout: 1000}, 100, 0))
+
+	// The other function uses half of its reservation: 300m observed plus
+	// 100m per invocation per second fit 1000m up to a rate of 7
+	ac := OvercommitAdmissionControl{Ratio: 2, Utilization: func(string) float64 { return 0.5 }}.reserveControl()
+	request := requests.CreateFunctionRequest{Service: "overcommitted", Realtime: 10, Timeout: 1000}
+	_, _, err := ac.reserve(request.Service, reservationFor(request, 100, 0))
+	if err == nil {
+		t.Errorf("Overcommit - error want: %s, got %s", "error", "nil")
+		t.FailNow()
+	}
+
+	w := httptest.NewRecorder()
+	ac.writeRejection(w, request, 100, 0, err)
+	rejection := requests.ReservationRejection{}
+	json.Unmarshal(w.Body.Bytes(), &rejection)
+	if rejection.AtRequestedSize.Realtime != 7 || rejection.AtRequestedRate.Resources.CPU != "70m" {
+		t.Errorf("writeRejection - offer want: %f %s, got %+v", 7.0, "70m", rejection)
+		t.Fail()
+	}
+
+	request.Realtime = rejection.AtRequestedSize.Realtime
+	if _, _, err := ac.reserve(request.Service, reservationFor(request, 100, 0)); err != nil {
+		t.Errorf("Overcommit offered rate - error want: %s, got %s", "nil", err.Error())
+		t.Fail()
+	}
+}
+
+func Test_CounterOfferChargesWarmPool(t *testing.T) {
+	config := &scaling.GetScalerInstance().Config
+	defer func(concurrency uint) { config.ContainerConcurrency = concurrency }(config.ContainerConcurrency)
+	config.ContainerConcurrency = 1
+	SetWarmPool(0.5, "")
+	defer SetWarmPool(0, "/_/health")
+	ledger := NewCapacityLedger(5000, 0)
+
+	// Without spares 5000m serve 50 invocations per second of 100ms, with
+	// them about 33 on 4 replicas of 1000m and 2 spares
+	request := requests.CreateFunctionRequest{Service: "warm-offer", Realtime: 40, Timeout: 100}
+	offer := ledger.CounterOffer(request, 1000, 0)
+	if offer.Realtime < 33.3 || offer.Realtime > 33.4 {
+		t.Errorf("CounterOffer - rate want: ~%f, got %f", 33.3, offer.Realtime)
+		t.Fail()
+	}
+
+	// The rate offered is admitted along with its spares
+	request.Realtime = offer.Realtime
+	if _, _, err := ledger.Reserve(request.Service, reservationFor(request, 1000, 0)); err != nil {
+		t.Errorf("Reserve offered rate - error want: %s, got %s", "nil", err.Error())
+		t.Fail()
+	}
+}
@@ -0,0 +1,87 @@
+package realtime
+
+import (
+	"math"
+	"net/http"
+	"time"
+)
+
+// DefaultOverbookingRatio is how far reservations may exceed the capacity
+// when no ratio is configured
+const DefaultOverbookingRatio = 1.5
+
+// OvercommitAdmissionControl admits realtime functions beyond the capacity,
+// up to Ratio times of it, relying on the functions not using their whole
+// reservation. A function is admitted only if the reservations weighted by
+// the utilization observed for each function still fit the capacity.
+type OvercommitAdmissionControl struct {
+	Ratio float64
+	// Utilization returns the share of its reservation a function uses, the
+	// achieved rate of its handler is used when nil
+	Utilization func(functionName string) float64
+}
+
+func (ac OvercommitAdmissionControl) reserveControl() ReserveAdmissionControl {
+	ratio := ac.Ratio
+	if ratio < 1 {
+		ratio = DefaultOverbookingRatio
+	}
+	utilization := ac.Utilization
+	if utilization == nil {
+		utilization = observedUtilization
+	}
+	return ReserveAdmissionControl{Ratio: ratio, Utilization: utilization}
+}
+
+// observedUtilization compares the achieved rate of a function to its
+// guaranteed rate. Functions not observed for long enough are assumed to
+// use their whole reservation.
+func observedUtilization(functionName string) float64 {
+	entry, ok := functionHandlers.Load(functionName)
+	if !ok {
+		return 1
+	}
+	handler := entry.(*InvocationHandler)
+	now := time.Now()
+	realtime := handler.params().Realtime
+	if realtime <= 0 || now.Sub(handler.created) < rateWindow {
+		return 1
+	}
+	return math.Min(1, handler.achieved.rate(now)/realtime)
+}
+
+func (ac OvercommitAdmissionControl) Register(
+	w http.ResponseWriter,
+	r *http.Request,
+	proxyClient *http.Client,
+	baseURL string,
+	requestURL string,
+	timeout time.Duration,
+	writeRequestURI bool) (int, error) {
+
+	return ac.reserveControl().Register(w, r, proxyClient, baseURL, requestURL, timeout, writeRequestURI)
+}
+
+func (ac OvercommitAdmissionControl) Update(
+	w http.ResponseWriter,
+	r *http.Request,
+	proxyClient *http.Client,
+	baseURL string,
+	requestURL string,
+	timeout time.Duration,
+	writeRequestURI bool) (int, error) {
+
+	return ac.reserveControl().Update(w, r, proxyClient, baseURL, requestURL, timeout, writeRequestURI)
+}
+
+func (ac OvercommitAdmissionControl) Unregister(
+	w http.ResponseWriter,
+	r *http.Request,
+	proxyClient *http.Client,
+	baseURL string,
+	requestURL string,
+	timeout time.Duration,
+	writeRequestURI bool) (int, error) {
+
+	return ac.reserveControl().Unregister(w, r, proxyClient, baseURL, requestURL, timeout, writeRequestURI)
+}
@@ -0,0 +1,92 @@
+package realtime
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ngduchai/faas/gateway/requests"
+	"github.com/ngduchai/faas/gateway/scaling"
+)
+
+func Test_OvercommitAdmitsUpToRatio(t *testing.T) {
+	SetupLedger(1000, 0, PolicyEDF)
+	defer SetupLedger(0, 0, PolicyEDF)
+	provider := newAdmissionProvider()
+	defer provider.server.Close()
+	serviceQuery := newScaleRecordingServiceQuery(scaling.ServiceQueryResponse{
+		Replicas: 1, AvailableReplicas: 1, Realtime: 6, CPU: 1000, Duration: 100,
+	})
+	scaling.GetScalerInstance().Config.ServiceQuery = serviceQuery
+
+	utilization := 0.5
+	ac := OvercommitAdmissionControl{
+		Ratio:       1.5,
+		Utilization: func(string) float64 { return utilization },
+	}
+	defer RemoveFunctionHandler("overcommit-a")
+	defer RemoveFunctionHandler("overcommit-b")
+	defer RemoveFunctionHandler("overcommit-c")
+
+	if code := deploy(ac, provider, http.MethodPost, admissionSpec("overcommit-a", 8)); code != http.StatusAccepted {
+		t.Errorf("Register overcommit-a - status want: %d, got %d", http.StatusAccepted, code)
+		t.Fail()
+	}
+	// 1400m reserved, 1000m used given the utilization
+	if code := deploy(ac, provider, http.MethodPost, admissionSpec("overcommit-b", 6)); code != http.StatusAccepted {
+		t.Errorf("Register overcommit-b - status want: %d, got %d", http.StatusAccepted, code)
+		t.Fail()
+	}
+	// 1600m would exceed the overbooking ratio
+	if code := deploy(ac, provider, http.MethodPost, admissionSpec("overcommit-c", 2)); code != http.StatusConflict {
+		t.Errorf("Register overcommit-c - status want: %d, got %d", http.StatusConflict, code)
+		t.Fail()
+	}
+
+	// Busier functions leave less room
+	utilization = 1
+	if code := deploy(ac, provider, http.MethodPut, admissionSpec("overcommit-b", 7)); code != http.StatusConflict {
+		t.Errorf("Update overcommit-b - status want: %d, got %d", http.StatusConflict, code)
+		t.Fail()
+	}
+	if res, _ := GetLedger().Get("overcommit-b"); res.CPU != 600 {
+		t.Errorf("Update overcommit-b - reservation want: %d, got %d", 600, res.CPU)
+		t.Fail()
+	}
+	if code := deploy(ac, provider, http.MethodPut, admissionSpec("overcommit-b", 2)); code != http.StatusAccepted {
+		t.Errorf("Update overcommit-b - status want: %d, got %d", http.StatusAccepted, code)
+		t.Fail()
+	}
+	if serviceQuery.scaleRequests() == 0 {
+		t.Errorf("Update overcommit-b - want: scaled, got no scale request")
+		t.Fail()
+	}
+}
+
+func Test_ObservedUtilization(t *testing.T) {
+	functionName := "overcommit-observed"
+	SetFunctionHandler(requests.CreateFunctionRequest{Service: functionName, Realtime: 10})
+	defer RemoveFunctionHandler(functionName)
+
+	if utilization := observedUtilization(functionName); utilization != 1 {
+		t.Errorf("observedUtilization - new handler want: %v, got %v", 1, utilization)
+		t.Fail()
+	}
+
+	entry, _ := functionHandlers.Load(functionName)
+	handler := entry.(*InvocationHandler)
+	handler.created = time.Now().Add(-2 * rateWindow)
+	now := time.Now()
+	for i := 0; i < 50; i++ {
+		handler.achieved.mark(now)
+	}
+	if utilization := observedUtilization(functionName); utilization < 0.45 || utilization > 0.5 {
+		t.Errorf("observedUtilization - want: ~%v, got %v", 0.5, utilization)
+		t.Fail()
+	}
+
+	if utilization := observedUtilization("overcommit-unknown"); utilization != 1 {
+		t.Errorf("observedUtilization - unknown function want: %v, got %v", 1, utilization)
+		t.Fail()
+	}
+}
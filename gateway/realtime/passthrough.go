@@ -0,0 +1,125 @@
+package realtime
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ngduchai/faas/gateway/requests"
+)
+
+// PassthroughAdmissionControl admits every function and hands the request to
+// the provider as is. No resources are reserved, invocations of every
+// function are served best-effort.
+type PassthroughAdmissionControl struct {
+}
+
+// bestEffort returns the request of a function served without guarantee
+func bestEffort(request requests.CreateFunctionRequest) requests.CreateFunctionRequest {
+	return requests.CreateFunctionRequest{Service: request.Service}
+}
+
+func (ac PassthroughAdmissionControl) Register(
+	w http.ResponseWriter,
+	r *http.Request,
+	proxyClient *http.Client,
+	baseURL string,
+	requestURL string,
+	timeout time.Duration,
+	writeRequestURI bool) (int, error) {
+
+	return ac.deploy(w, r, proxyClient, baseURL, requestURL, timeout, writeRequestURI, false)
+}
+
+func (ac PassthroughAdmissionControl) Update(
+	w http.ResponseWriter,
+	r *http.Request,
+	proxyClient *http.Client,
+	baseURL string,
+	requestURL string,
+	timeout time.Duration,
+	writeRequestURI bool) (int, error) {
+
+	return ac.deploy(w, r, proxyClient, baseURL, requestURL, timeout, writeRequestURI, true)
+}
+
+func (ac PassthroughAdmissionControl) deploy(
+	w http.ResponseWriter,
+	r *http.Request,
+	proxyClient *http.Client,
+	baseURL string,
+	requestURL string,
+	timeout time.Duration,
+	writeRequestURI bool,
+	update bool) (int, error) {
+
+	rm := ResourceManager{}
+	request, err := rm.ParseRequest(r)
+	if err != nil {
+		log.Printf("Reading parameters error: %s", err)
+		statusCode := http.StatusNotFound
+		w.WriteHeader(statusCode)
+		err = errors.New("Function parameters are invalid")
+		return statusCode, err
+	}
+	if !claimPending(request.Service) {
+		return writeChangeInFlight(w, request.Service)
+	}
+	defer clearPending(request.Service)
+
+	var res *http.Response
+	if update {
+		res, err = rm.UpdateImage(r, proxyClient, baseURL, requestURL, timeout, writeRequestURI)
+	} else {
+		res, err = rm.CreateImage(r, proxyClient, baseURL, requestURL, timeout, writeRequestURI)
+	}
+	if err != nil || res.StatusCode < 200 || res.StatusCode > 299 {
+		return writeUpstreamFailure(w, res, err)
+	}
+
+	// A reservation may be left from a deployment admitted by another strategy
+	GetLedger().Release(request.Service)
+	rememberSpec(request)
+	revokeLease(request.Service)
+	SetFunctionHandler(bestEffort(request))
+
+	w.WriteHeader(http.StatusAccepted)
+	return http.StatusAccepted, nil
+}
+
+func (ac PassthroughAdmissionControl) Unregister(
+	w http.ResponseWriter,
+	r *http.Request,
+	proxyClient *http.Client,
+	baseURL string,
+	requestURL string,
+	timeout time.Duration,
+	writeRequestURI bool) (int, error) {
+
+	rm := ResourceManager{}
+	request, err := rm.ParseDeleteRequest(r)
+	if err != nil {
+		log.Printf("Reading parameters error: %s", err)
+		statusCode := http.StatusBadRequest
+		w.WriteHeader(statusCode)
+		err = errors.New("Function parameters are invalid")
+		return statusCode, err
+	}
+	if !claimPending(request.FunctionName) {
+		return writeChangeInFlight(w, request.FunctionName)
+	}
+	defer clearPending(request.FunctionName)
+
+	res, err := rm.RemoveImage(r, proxyClient, baseURL, requestURL, timeout, writeRequestURI)
+	if err != nil || res.StatusCode < 200 || res.StatusCode > 299 {
+		return writeUpstreamFailure(w, res, err)
+	}
+
+	RemoveFunctionHandler(request.FunctionName)
+	forgetSpec(request.FunctionName)
+	revokeLease(request.FunctionName)
+	GetLedger().Release(request.FunctionName)
+	w.WriteHeader(http.StatusAccepted)
+	return http.StatusAccepted, nil
+}
@@ -0,0 +1,67 @@
+package realtime
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/ngduchai/faas/gateway/scaling"
+)
+
+func Test_PassthroughAdmitsBestEffort(t *testing.T) {
+	SetupLedger(1000, 0, PolicyNone)
+	defer SetupLedger(0, 0, PolicyEDF)
+	provider := newAdmissionProvider()
+	defer provider.server.Close()
+	serviceQuery := newScaleRecordingServiceQuery(scaling.ServiceQueryResponse{Replicas: 1, AvailableReplicas: 1})
+	scaling.GetScalerInstance().Config.ServiceQuery = serviceQuery
+
+	ac := PassthroughAdmissionControl{}
+	defer RemoveFunctionHandler("passthrough-a")
+	defer RemoveFunctionHandler("passthrough-b")
+
+	// Both functions are admitted although they could not both be reserved
+	for _, spec := range []struct {
+		name     string
+		realtime float64
+	}{{"passthrough-a", 8}, {"passthrough-b", 6}} {
+		if code := deploy(ac, provider, http.MethodPost, admissionSpec(spec.name, spec.realtime)); code != http.StatusAccepted {
+			t.Errorf("Register %s - status want: %d, got %d", spec.name, http.StatusAccepted, code)
+			t.Fail()
+		}
+	}
+	if cpu, _ := GetLedger().Committed(); cpu != 0 {
+		t.Errorf("Committed - cpu want: %d, got %d", 0, cpu)
+		t.Fail()
+	}
+
+	// The deployment reaches the provider untouched
+	deployed := provider.lastDeployed()
+	if deployed.Service != "passthrough-b" || deployed.Labels != nil || deployed.EnvVars != nil {
+		t.Errorf("Register - provider request want: unchanged, got %+v", deployed)
+		t.Fail()
+	}
+
+	entry, ok := functionHandlers.Load("passthrough-a")
+	if !ok || entry.(*InvocationHandler).params().Realtime != 0 {
+		t.Errorf("Register - want: best-effort handler, got %+v", entry)
+		t.Fail()
+	}
+
+	if code := deploy(ac, provider, http.MethodPut, admissionSpec("passthrough-a", 20)); code != http.StatusAccepted {
+		t.Errorf("Update - status want: %d, got %d", http.StatusAccepted, code)
+		t.Fail()
+	}
+	if serviceQuery.scaleRequests() != 0 {
+		t.Errorf("Update - scale requests want: %d, got %d", 0, serviceQuery.scaleRequests())
+		t.Fail()
+	}
+
+	if code := undeploy(ac, provider, "passthrough-a"); code != http.StatusAccepted {
+		t.Errorf("Unregister - status want: %d, got %d", http.StatusAccepted, code)
+		t.Fail()
+	}
+	if _, ok := functionHandlers.Load("passthrough-a"); ok {
+		t.Errorf("Unregister - want: handler removed, got handler")
+		t.Fail()
+	}
+}
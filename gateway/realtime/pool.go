@@ -0,0 +1,548 @@
+package realtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ngduchai/faas/gateway/requests"
+	"github.com/ngduchai/faas/gateway/scaling"
+)
+
+// PoolBudget is the invocation rate and the resources shared by the
+// functions attached to a declared reservation pool
+type PoolBudget struct {
+	Name string `json:"name"`
+	// Rate is the invocation rate shared by the members
+	Rate float64 `json:"rate"`
+	// CPU in millicores and Memory in bytes
+	CPU    int64 `json:"cpu"`
+	Memory int64 `json:"memory"`
+}
+
+// PoolStore keeps the declared pools, so that they are declared again once
+// the gateway restarts
+type PoolStore interface {
+	Save(budget PoolBudget) error
+	Delete(name string) error
+	Declared() ([]PoolBudget, error)
+}
+
+// MemoryPoolStore keeps pools for the life of the gateway only
+type MemoryPoolStore struct {
+	pools sync.Map
+}
+
+func (ps *MemoryPoolStore) Save(budget PoolBudget) error {
+	ps.pools.Store(budget.Name, budget)
+	return nil
+}
+
+func (ps *MemoryPoolStore) Delete(name string) error {
+	ps.pools.Delete(name)
+	return nil
+}
+
+func (ps *MemoryPoolStore) Declared() ([]PoolBudget, error) {
+	budgets := []PoolBudget{}
+	ps.pools.Range(func(key interface{}, value interface{}) bool {
+		budgets = append(budgets, value.(PoolBudget))
+		return true
+	})
+	return budgets, nil
+}
+
+// FilePoolStore keeps every pool in a JSON file of Dir
+type FilePoolStore struct {
+	Dir string
+}
+
+// NewFilePoolStore creates a store in the directory, creating it if needed
+func NewFilePoolStore(dir string) (*FilePoolStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FilePoolStore{Dir: dir}, nil
+}
+
+func (ps *FilePoolStore) path(name string) string {
+	return filepath.Join(ps.Dir, name+".json")
+}
+
+func (ps *FilePoolStore) Save(budget PoolBudget) error {
+	body, err := json.Marshal(budget)
+	if err != nil {
+		return err
+	}
+	return replaceFile(ps.path(budget.Name), body)
+}
+
+func (ps *FilePoolStore) Delete(name string) error {
+	if err := os.Remove(ps.path(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (ps *FilePoolStore) Declared() ([]PoolBudget, error) {
+	files, err := ioutil.ReadDir(ps.Dir)
+	if err != nil {
+		return nil, err
+	}
+	budgets := []PoolBudget{}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		body, err := ioutil.ReadFile(filepath.Join(ps.Dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		budget := PoolBudget{}
+		if err := json.Unmarshal(body, &budget); err != nil {
+			log.Printf("Skip unreadable pool %s: %s", file.Name(), err)
+			continue
+		}
+		budgets = append(budgets, budget)
+	}
+	return budgets, nil
+}
+
+var poolStore PoolStore
+var poolStoreOnce sync.Once
+
+// SetupPoolStore sets the store the declared pools are kept in
+func SetupPoolStore(ps PoolStore) {
+	poolStore = ps
+}
+
+// GetPoolStore returns the store shared by the gateway, pools are kept in
+// memory until one is set up
+func GetPoolStore() PoolStore {
+	poolStoreOnce.Do(func() {
+		if poolStore == nil {
+			SetupPoolStore(&MemoryPoolStore{})
+		}
+	})
+	return poolStore
+}
+
+// RestorePools declares again the pools kept in the store. It runs before
+// the reconciler restores the reservations of their functions, which are
+// otherwise accounted against the capacity.
+func RestorePools() error {
+	budgets, err := GetPoolStore().Declared()
+	if err != nil {
+		return err
+	}
+	ledger := GetLedger()
+	for _, budget := range budgets {
+		if _, _, err := ledger.DeclarePool(budget); err != nil {
+			log.Printf("Unable to restore pool %s: %s", budget.Name, err)
+			continue
+		}
+		log.Printf("Restored pool %s: realtime %f CPU: %d Memory %d", budget.Name, budget.Rate, budget.CPU, budget.Memory)
+	}
+	return nil
+}
+
+// PoolError reports a reservation that does not fit into the budget of its
+// pool, or a budget too small for the functions already in the pool
+type PoolError struct {
+	// Function is empty when the budget itself is rejected
+	Function  string
+	Pool      string
+	Resource  string
+	Requested float64
+	Available float64
+	// At is the time a window of a schedule runs short, zero if it does now
+	At time.Time
+}
+
+func (e *PoolError) Error() string {
+	if len(e.Function) == 0 {
+		return fmt.Sprintf("Budget of pool %s is too small for its functions: %s %v reserved, %v budgeted",
+			e.Pool, e.Resource, e.Requested, e.Available)
+	}
+	message := fmt.Sprintf("Insufficient %s in pool %s for function %s: requested %v, available %v",
+		e.Resource, e.Pool, e.Function, e.Requested, e.Available)
+	if !e.At.IsZero() {
+		message += " at " + e.At.Format(time.RFC3339)
+	}
+	return message
+}
+
+// pooled tells whether the reservation is accounted against a declared pool
+func (l *CapacityLedger) pooled(res Reservation) bool {
+	_, declared := l.pools[res.Pool]
+	return len(res.Pool) > 0 && declared
+}
+
+// poolUsage returns the rate, CPU and memory reserved by the members of the
+// pool other than the given function at the given time, now when zero
+func (l *CapacityLedger) poolUsage(pool string, functionName string, at time.Time) (float64, int64, int64) {
+	rate := 0.0
+	cpu := int64(0)
+	memory := int64(0)
+	for name, res := range l.reservations {
+		if name != functionName && res.Pool == pool {
+			res = res.At(at)
+			rate += res.Task.Rate
+			cpu += res.CPU
+			memory += res.Memory
+		}
+	}
+	return rate, cpu, memory
+}
+
+// fitPool checks the reservation fits into what the other members of the
+// pool left of its budget at the given time
+func (l *CapacityLedger) fitPool(functionName string, res Reservation, budget PoolBudget, at time.Time) error {
+	rate, cpu, memory := l.poolUsage(budget.Name, functionName, at)
+	if rate+res.Task.Rate > budget.Rate {
+		return &PoolError{Function: functionName, Pool: budget.Name, Resource: "rate", At: at,
+			Requested: res.Task.Rate, Available: budget.Rate - rate}
+	}
+	if cpu+res.CPU > budget.CPU {
+		return &PoolError{Function: functionName, Pool: budget.Name, Resource: "cpu", At: at,
+			Requested: float64(res.CPU), Available: float64(budget.CPU - cpu)}
+	}
+	if memory+res.Memory > budget.Memory {
+		return &PoolError{Function: functionName, Pool: budget.Name, Resource: "memory", At: at,
+			Requested: float64(res.Memory), Available: float64(budget.Memory - memory)}
+	}
+	return nil
+}
+
+// DeclarePool reserves the budget of a pool against the capacity, replacing
+// the budget it already has. From then on the functions of the pool are
+// admitted against the budget. The previous budget is returned.
+func (l *CapacityLedger) DeclarePool(budget PoolBudget) (PoolBudget, bool, error) {
+	l.sync.Lock()
+	defer l.sync.Unlock()
+
+	prev, existed := l.pools[budget.Name]
+	restore := func() {
+		if existed {
+			l.pools[budget.Name] = prev
+		} else {
+			delete(l.pools, budget.Name)
+		}
+	}
+
+	// The functions already in the pool must fit into the new budget
+	rate, cpu, memory := l.poolUsage(budget.Name, "", time.Time{})
+	if rate > budget.Rate {
+		return prev, existed, &PoolError{Pool: budget.Name, Resource: "rate", Requested: rate, Available: budget.Rate}
+	}
+	if cpu > budget.CPU {
+		return prev, existed, &PoolError{Pool: budget.Name, Resource: "cpu", Requested: float64(cpu), Available: float64(budget.CPU)}
+	}
+	if memory > budget.Memory {
+		return prev, existed, &PoolError{Pool: budget.Name, Resource: "memory", Requested: float64(memory), Available: float64(budget.Memory)}
+	}
+
+	l.pools[budget.Name] = budget
+	usedCPU, usedMemory := l.committed()
+	if l.TotalCPU > 0 && usedCPU > l.TotalCPU {
+		restore()
+		return prev, existed, &CapacityError{Pool: budget.Name, Resource: "cpu",
+			Requested: budget.CPU, Available: l.TotalCPU - (usedCPU - budget.CPU)}
+	}
+	if l.TotalMemory > 0 && usedMemory > l.TotalMemory {
+		restore()
+		return prev, existed, &CapacityError{Pool: budget.Name, Resource: "memory",
+			Requested: budget.Memory, Available: l.TotalMemory - (usedMemory - budget.Memory)}
+	}
+
+	if l.Policy != PolicyNone {
+		tasks := []Task{}
+		for _, res := range l.reservations {
+			if res.Pool == budget.Name {
+				tasks = append(tasks, res.Task)
+			}
+		}
+		if len(tasks) > 0 {
+			if utilization, bound, schedulable := CheckSchedulability(l.Policy, tasks, float64(budget.CPU)/1000); !schedulable {
+				restore()
+				return prev, existed, &SchedulabilityError{
+					Pool:        budget.Name,
+					Policy:      l.Policy,
+					Utilization: utilization,
+					Bound:       bound,
+				}
+			}
+		}
+	}
+	return prev, existed, nil
+}
+
+// restorePool puts back the budget of a pool, whether or not it fits, as
+// when undoing a change to it
+func (l *CapacityLedger) restorePool(budget PoolBudget) {
+	l.sync.Lock()
+	defer l.sync.Unlock()
+
+	l.pools[budget.Name] = budget
+}
+
+// RemovePool releases the budget of a pool which has no function left
+func (l *CapacityLedger) RemovePool(pool string) (PoolBudget, bool, error) {
+	l.sync.Lock()
+	defer l.sync.Unlock()
+
+	budget, existed := l.pools[pool]
+	if !existed {
+		return budget, false, nil
+	}
+	for name, res := range l.reservations {
+		if res.Pool == pool {
+			return budget, true, fmt.Errorf("Pool %s still holds function %s", pool, name)
+		}
+	}
+	delete(l.pools, pool)
+	return budget, true, nil
+}
+
+// Pool returns the budget of a declared pool
+func (l *CapacityLedger) Pool(pool string) (PoolBudget, bool) {
+	l.sync.Lock()
+	defer l.sync.Unlock()
+
+	budget, declared := l.pools[pool]
+	return budget, declared
+}
+
+// Pools returns the budgets of the declared pools
+func (l *CapacityLedger) Pools() []PoolBudget {
+	l.sync.Lock()
+	defer l.sync.Unlock()
+
+	budgets := make([]PoolBudget, 0, len(l.pools))
+	for _, budget := range l.pools {
+		budgets = append(budgets, budget)
+	}
+	sort.Slice(budgets, func(i, j int) bool { return budgets[i].Name < budgets[j].Name })
+	return budgets
+}
+
+// PoolUsage returns the rate, CPU and memory reserved by the members of a pool
+func (l *CapacityLedger) PoolUsage(pool string) (float64, int64, int64) {
+	l.sync.Lock()
+	defer l.sync.Unlock()
+
+	return l.poolUsage(pool, "", time.Time{})
+}
+
+// poolShare is what a member dispatches on beyond its own rate
+type poolShare struct {
+	// member is set when the function belongs to a declared pool
+	member bool
+	// bucket admits invocations at the share of the pool rate left
+	// unreserved by the members, nil when there is none
+	bucket *scaling.TokenBucket
+}
+
+// share returns the share of the pool the handler dispatches on
+func (handler *InvocationHandler) share() poolShare {
+	handler.shareSync.Lock()
+	defer handler.shareSync.Unlock()
+	return handler.poolShare
+}
+
+func (handler *InvocationHandler) setShare(member bool, rate float64) {
+	handler.shareSync.Lock()
+	defer handler.shareSync.Unlock()
+
+	handler.poolShare.member = member
+	if rate <= 0 {
+		handler.poolShare.bucket = nil
+	} else if handler.poolShare.bucket == nil {
+		handler.poolShare.bucket = scaling.NewTokenBucket(rate, 1)
+	} else {
+		handler.poolShare.bucket.SetRate(rate, 1)
+	}
+}
+
+// poolWeight returns the weight of the function in its pool
+func poolWeight(f requests.CreateFunctionRequest) float64 {
+	if f.PoolWeight > 0 {
+		return f.PoolWeight
+	}
+	return 1
+}
+
+// rebalancePool splits the rate of a declared pool left unreserved by its
+// realtime members among them in proportion to their weights
+func rebalancePool(pool string) {
+	if len(pool) == 0 {
+		return
+	}
+	budget, declared := GetLedger().Pool(pool)
+
+	members := []*InvocationHandler{}
+	memberWeights := []float64{}
+	reserved := 0.0
+	weights := 0.0
+	functionHandlers.Range(func(key interface{}, value interface{}) bool {
+		handler := value.(*InvocationHandler)
+		if params := handler.params(); params.Pool == pool && params.Realtime > 0 {
+			members = append(members, handler)
+			memberWeights = append(memberWeights, params.Weight)
+			reserved += params.Realtime
+			weights += params.Weight
+		}
+		return true
+	})
+	for i, member := range members {
+		rate := 0.0
+		if declared && budget.Rate > reserved {
+			rate = (budget.Rate - reserved) * memberWeights[i] / weights
+		}
+		member.setShare(declared, rate)
+		if member.pending() > 0 {
+			member.scheduler.Schedule(member)
+		}
+	}
+}
+
+// poolStatus reports the budget of a pool and what its members hold
+func poolStatus(budget PoolBudget) requests.RealtimePool {
+	rate, cpu, memory := GetLedger().PoolUsage(budget.Name)
+	status := requests.RealtimePool{
+		Name:     budget.Name,
+		Realtime: budget.Rate,
+		Resources: requests.FunctionResources{
+			CPU:    fmt.Sprintf("%dm", budget.CPU),
+			Memory: fmt.Sprint(budget.Memory),
+		},
+		Reserved:  rate,
+		Committed: requests.RealtimeResources{CPU: cpu, Memory: memory},
+		Members:   []requests.RealtimePoolMember{},
+	}
+	functionHandlers.Range(func(key interface{}, value interface{}) bool {
+		handler := value.(*InvocationHandler)
+		if params := handler.params(); params.Pool == budget.Name {
+			member := requests.RealtimePoolMember{Name: handler.Name, Realtime: params.Realtime, Weight: params.Weight}
+			if share := handler.share().bucket; share != nil {
+				member.Share = share.Rate
+			}
+			status.Members = append(status.Members, member)
+		}
+		return true
+	})
+	sort.Slice(status.Members, func(i, j int) bool { return status.Members[i].Name < status.Members[j].Name })
+	return status
+}
+
+// MakeRealtimePoolsHandler lists, declares and removes reservation pools
+func MakeRealtimePoolsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, named := mux.Vars(r)["name"]
+		ledger := GetLedger()
+
+		switch r.Method {
+		case http.MethodGet:
+			var out interface{}
+			if named {
+				budget, declared := ledger.Pool(name)
+				if !declared {
+					w.WriteHeader(http.StatusNotFound)
+					w.Write([]byte("Pool " + name + " not found"))
+					return
+				}
+				out = poolStatus(budget)
+			} else {
+				statuses := []requests.RealtimePool{}
+				for _, budget := range ledger.Pools() {
+					statuses = append(statuses, poolStatus(budget))
+				}
+				out = statuses
+			}
+			writePoolResponse(w, http.StatusOK, out)
+
+		case http.MethodPut:
+			body, _ := ioutil.ReadAll(r.Body)
+			pool := requests.RealtimePool{}
+			if err := json.Unmarshal(body, &pool); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			rm := ResourceManager{}
+			cpu, memory, err := rm.GetResourceQuantity(pool.Resources)
+			if err != nil || pool.Realtime <= 0 || cpu <= 0 || memory <= 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("A pool needs a positive realtime rate, cpu and memory"))
+				return
+			}
+
+			budget := PoolBudget{Name: name, Rate: pool.Realtime, CPU: cpu, Memory: memory}
+			prev, existed, err := ledger.DeclarePool(budget)
+			if err != nil {
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			if err := GetPoolStore().Save(budget); err != nil {
+				log.Printf("Unable to keep pool %s: %s", name, err)
+				if existed {
+					ledger.restorePool(prev)
+				} else {
+					ledger.RemovePool(name)
+				}
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			rebalancePool(name)
+			writePoolResponse(w, http.StatusOK, poolStatus(budget))
+
+		case http.MethodDelete:
+			budget, existed, err := ledger.RemovePool(name)
+			if !existed {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte("Pool " + name + " not found"))
+				return
+			}
+			if err != nil {
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			if err := GetPoolStore().Delete(name); err != nil {
+				log.Printf("Unable to forget pool %s: %s", name, err)
+				ledger.restorePool(budget)
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			rebalancePool(name)
+			w.WriteHeader(http.StatusAccepted)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func writePoolResponse(w http.ResponseWriter, statusCode int, out interface{}) {
+	body, err := json.Marshal(out)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
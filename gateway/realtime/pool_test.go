@@ -0,0 +1,254 @@
+package realtime
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ngduchai/faas/gateway/requests"
+)
+
+func Test_LedgerAccountsPoolMembersAgainstBudget(t *testing.T) {
+	ledger := NewCapacityLedger(4000, 4000)
+	ledger.Policy = PolicyNone
+
+	if _, _, err := ledger.DeclarePool(PoolBudget{Name: "etl", Rate: 10, CPU: 2000, Memory: 2000}); err != nil {
+		t.Errorf("DeclarePool - error want: %s, got %s", "nil", err.Error())
+		t.FailNow()
+	}
+	if cpu, _ := ledger.Committed(); cpu != 2000 {
+		t.Errorf("Committed - cpu want: %d, got %d", 2000, cpu)
+		t.Fail()
+	}
+
+	member := Reservation{CPU: 1500, Memory: 100, Pool: "etl", Task: Task{Rate: 6}}
+	if _, _, err := ledger.Reserve("extract", member); err != nil {
+		t.Errorf("Reserve extract - error want: %s, got %s", "nil", err.Error())
+		t.Fail()
+	}
+	// Members are accounted against the budget, not the capacity
+	if cpu, _ := ledger.Committed(); cpu != 2000 {
+		t.Errorf("Committed - cpu want: %d, got %d", 2000, cpu)
+		t.Fail()
+	}
+
+	_, _, err := ledger.Reserve("transform", Reservation{CPU: 600, Memory: 100, Pool: "etl", Task: Task{Rate: 1}})
+	if poolErr, ok := err.(*PoolError); !ok || poolErr.Resource != "cpu" || poolErr.Available != 500 {
+		t.Errorf("Reserve transform - error want: %s %d, got %v", "cpu", 500, err)
+		t.Fail()
+	}
+	_, _, err = ledger.Reserve("transform", Reservation{CPU: 100, Memory: 100, Pool: "etl", Task: Task{Rate: 5}})
+	if poolErr, ok := err.(*PoolError); !ok || poolErr.Resource != "rate" || poolErr.Available != 4 {
+		t.Errorf("Reserve transform - error want: %s %d, got %v", "rate", 4, err)
+		t.Fail()
+	}
+
+	// Functions outside the pool share what the budget left of the capacity
+	if _, _, err := ledger.Reserve("other", Reservation{CPU: 2000, Memory: 100}); err != nil {
+		t.Errorf("Reserve other - error want: %s, got %s", "nil", err.Error())
+		t.Fail()
+	}
+	_, _, err = ledger.DeclarePool(PoolBudget{Name: "etl", Rate: 10, CPU: 2500, Memory: 2000})
+	if capacityErr, ok := err.(*CapacityError); !ok || capacityErr.Pool != "etl" || capacityErr.Available != 2000 {
+		t.Errorf("DeclarePool grow - error want: %s %d, got %v", "cpu", 2000, err)
+		t.Fail()
+	}
+	if budget, _ := ledger.Pool("etl"); budget.CPU != 2000 {
+		t.Errorf("Pool - cpu want: %d, got %d", 2000, budget.CPU)
+		t.Fail()
+	}
+
+	if _, _, err := ledger.RemovePool("etl"); err == nil {
+		t.Errorf("RemovePool - error want: %s, got %s", "error", "nil")
+		t.Fail()
+	}
+	ledger.Release("extract")
+	if _, existed, err := ledger.RemovePool("etl"); !existed || err != nil {
+		t.Errorf("RemovePool - want: %v %v, got %v %v", true, nil, existed, err)
+		t.Fail()
+	}
+	if cpu, _ := ledger.Committed(); cpu != 2000 {
+		t.Errorf("Committed - cpu want: %d, got %d", 2000, cpu)
+		t.Fail()
+	}
+}
+
+func Test_DeclarePoolCoversMembers(t *testing.T) {
+	ledger := NewCapacityLedger(0, 0)
+	ledger.Reserve("extract", Reservation{CPU: 1500, Memory: 100, Pool: "etl", Task: Task{Rate: 6}})
+
+	_, _, err := ledger.DeclarePool(PoolBudget{Name: "etl", Rate: 10, CPU: 1000, Memory: 1000})
+	if poolErr, ok := err.(*PoolError); !ok || poolErr.Resource != "cpu" {
+		t.Errorf("DeclarePool - error want: %s, got %v", "cpu", err)
+		t.Fail()
+	}
+	if _, declared := ledger.Pool("etl"); declared {
+		t.Errorf("Pool - declared want: %v, got %v", false, declared)
+		t.Fail()
+	}
+}
+
+func Test_CounterOfferFitsPoolBudget(t *testing.T) {
+	ledger := NewCapacityLedger(0, 0)
+	ledger.DeclarePool(PoolBudget{Name: "etl", Rate: 10, CPU: 4000, Memory: 4000})
+	ledger.Reserve("extract", reservationFor(requests.CreateFunctionRequest{Realtime: 8, Timeout: 100, Pool: "etl"}, 100, 100))
+
+	offer := ledger.CounterOffer(requests.CreateFunctionRequest{Service: "transform", Realtime: 5, Timeout: 100, Pool: "etl"}, 100, 100)
+	if offer.Realtime != 2 {
+		t.Errorf("CounterOffer - rate want: %v, got %v", 2, offer.Realtime)
+		t.Fail()
+	}
+}
+
+func Test_PoolSharesUnreservedRateByWeight(t *testing.T) {
+	SetupLedger(0, 0, PolicyNone)
+	defer SetupLedger(0, 0, PolicyEDF)
+	GetLedger().DeclarePool(PoolBudget{Name: "weighted", Rate: 10, CPU: 1000, Memory: 1000})
+
+	SetFunctionHandler(requests.CreateFunctionRequest{Service: "weighted-a", Realtime: 2, Pool: "weighted", PoolWeight: 3})
+	defer RemoveFunctionHandler("weighted-a")
+	SetFunctionHandler(requests.CreateFunctionRequest{Service: "weighted-b", Realtime: 2, Pool: "weighted"})
+
+	share := func(functionName string) float64 {
+		entry, _ := functionHandlers.Load(functionName)
+		if bucket := entry.(*InvocationHandler).share().bucket; bucket != nil {
+			return bucket.Rate
+		}
+		return 0
+	}
+	if a, b := share("weighted-a"), share("weighted-b"); a != 4.5 || b != 1.5 {
+		t.Errorf("Share - want: %v %v, got %v %v", 4.5, 1.5, a, b)
+		t.Fail()
+	}
+
+	RemoveFunctionHandler("weighted-b")
+	if a := share("weighted-a"); a != 8 {
+		t.Errorf("Share - want: %v, got %v", 8, a)
+		t.Fail()
+	}
+}
+
+func Test_PoolMemberDispatchesOnItsShare(t *testing.T) {
+	SetupLedger(0, 0, PolicyNone)
+	defer SetupLedger(0, 0, PolicyEDF)
+	GetLedger().DeclarePool(PoolBudget{Name: "shared", Rate: 20, CPU: 1000, Memory: 1000})
+
+	SetFunctionHandler(requests.CreateFunctionRequest{Service: "shared-member", Realtime: 1, Pool: "shared"})
+	defer RemoveFunctionHandler("shared-member")
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/function/shared-member", nil)
+		Invoke(func(w http.ResponseWriter, r *http.Request) {}, httptest.NewRecorder(), r)
+	}
+	// On its own rate the member would take 3s at least
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Invoke - elapsed want: <%s, got %s", time.Second, elapsed)
+		t.Fail()
+	}
+}
+
+func Test_RealtimePoolsHandler(t *testing.T) {
+	SetupLedger(4000, 4*1024*1024*1024, PolicyEDF)
+	defer SetupLedger(0, 0, PolicyEDF)
+	handler := MakeRealtimePoolsHandler()
+	call := func(method string, name string, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/system/realtime-pools/"+name, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		if len(name) > 0 {
+			r = mux.SetURLVars(r, map[string]string{"name": name})
+		}
+		handler(w, r)
+		return w
+	}
+
+	if w := call(http.MethodPut, "api", `{"realtime":5,"resources":{"cpu":"2","memory":"1Gi"}}`); w.Code != http.StatusOK {
+		t.Errorf("PUT - status want: %d, got %d", http.StatusOK, w.Code)
+		t.Fail()
+	}
+	if w := call(http.MethodPut, "big", `{"realtime":5,"resources":{"cpu":"3","memory":"1Gi"}}`); w.Code != http.StatusConflict {
+		t.Errorf("PUT beyond capacity - status want: %d, got %d", http.StatusConflict, w.Code)
+		t.Fail()
+	}
+	if w := call(http.MethodPut, "empty", `{"realtime":5}`); w.Code != http.StatusBadRequest {
+		t.Errorf("PUT without budget - status want: %d, got %d", http.StatusBadRequest, w.Code)
+		t.Fail()
+	}
+
+	w := call(http.MethodGet, "", "")
+	pools := []requests.RealtimePool{}
+	json.Unmarshal(w.Body.Bytes(), &pools)
+	if w.Code != http.StatusOK || len(pools) != 1 || pools[0].Name != "api" || pools[0].Realtime != 5 {
+		t.Errorf("GET - want: %s, got %d %+v", "api", w.Code, pools)
+		t.Fail()
+	}
+
+	if w := call(http.MethodDelete, "api", ""); w.Code != http.StatusAccepted {
+		t.Errorf("DELETE - status want: %d, got %d", http.StatusAccepted, w.Code)
+		t.Fail()
+	}
+	if w := call(http.MethodGet, "api", ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET removed - status want: %d, got %d", http.StatusNotFound, w.Code)
+		t.Fail()
+	}
+}
+
+func Test_PoolsSurviveRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "realtime-pools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewFilePoolStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetupPoolStore(store)
+	defer SetupPoolStore(&MemoryPoolStore{})
+	SetupLedger(4000, 4*1024*1024*1024, PolicyEDF)
+	defer SetupLedger(0, 0, PolicyEDF)
+	handler := MakeRealtimePoolsHandler()
+	call := func(method string, name string, body string) int {
+		r := httptest.NewRequest(method, "/system/realtime-pools/"+name, strings.NewReader(body))
+		r = mux.SetURLVars(r, map[string]string{"name": name})
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	if code := call(http.MethodPut, "kept", `{"realtime":5,"resources":{"cpu":"2","memory":"1Gi"}}`); code != http.StatusOK {
+		t.Errorf("PUT - status want: %d, got %d", http.StatusOK, code)
+		t.FailNow()
+	}
+
+	// The gateway restarts with an empty ledger
+	SetupLedger(4000, 4*1024*1024*1024, PolicyEDF)
+	if err := RestorePools(); err != nil {
+		t.Errorf("RestorePools - error want: nil, got %s", err)
+		t.FailNow()
+	}
+	budget, declared := GetLedger().Pool("kept")
+	if !declared || budget.Rate != 5 || budget.CPU != 2000 {
+		t.Errorf("RestorePools - pool want: %s, got %+v", "kept", budget)
+		t.Fail()
+	}
+	if cpu, _ := GetLedger().Committed(); cpu != 2000 {
+		t.Errorf("Committed - cpu want: %d, got %d", 2000, cpu)
+		t.Fail()
+	}
+
+	if code := call(http.MethodDelete, "kept", ""); code != http.StatusAccepted {
+		t.Errorf("DELETE - status want: %d, got %d", http.StatusAccepted, code)
+		t.Fail()
+	}
+	if budgets, err := store.Declared(); err != nil || len(budgets) != 0 {
+		t.Errorf("Declared - pools want: none, got %+v %v", budgets, err)
+		t.Fail()
+	}
+}
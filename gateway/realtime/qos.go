@@ -0,0 +1,34 @@
+package realtime
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ngduchai/faas/gateway/realtime/specs"
+	"github.com/ngduchai/faas/gateway/requests"
+)
+
+// validateQoS checks the class of service of the function is known and
+// consistent with its guaranteed rate, the peak rate of its schedule
+func validateQoS(request requests.CreateFunctionRequest) error {
+	realtime := specs.PeakRealtime(request)
+	switch specs.QoSClass(request.QoS, realtime) {
+	case requests.QoSGuaranteed, requests.QoSBurstable:
+		if realtime <= 0 {
+			return fmt.Errorf("%s functions need a guaranteed rate", request.QoS)
+		}
+	case requests.QoSBestEffort:
+		if realtime > 0 {
+			return errors.New("best-effort functions cannot have a guaranteed rate")
+		}
+	default:
+		return fmt.Errorf("unknown QoS class %s", request.QoS)
+	}
+	return nil
+}
+
+// opportunistic tells whether invocations beyond the guaranteed rate are
+// dispatched right away rather than queued
+func (handler *InvocationHandler) opportunistic() bool {
+	return handler.params().QoS == requests.QoSBurstable
+}
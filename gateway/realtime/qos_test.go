@@ -0,0 +1,105 @@
+package realtime
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ngduchai/faas/gateway/requests"
+)
+
+func Test_ValidateQoS(t *testing.T) {
+	cases := []struct {
+		qos      string
+		realtime float64
+		valid    bool
+	}{
+		{"", 0, true},
+		{"", 5, true},
+		{requests.QoSGuaranteed, 5, true},
+		{requests.QoSBurstable, 5, true},
+		{requests.QoSBestEffort, 0, true},
+		{requests.QoSGuaranteed, 0, false},
+		{requests.QoSBurstable, 0, false},
+		{requests.QoSBestEffort, 5, false},
+		{"platinum", 5, false},
+	}
+	for _, c := range cases {
+		err := validateQoS(requests.CreateFunctionRequest{Service: "qos", QoS: c.qos, Realtime: c.realtime})
+		if (err == nil) != c.valid {
+			t.Errorf("validateQoS %q at %v - valid want: %v, got %v", c.qos, c.realtime, c.valid, err == nil)
+			t.Fail()
+		}
+	}
+}
+
+func Test_ReserveAdmissionControlRespectsQoS(t *testing.T) {
+	SetupLedger(0, 0, PolicyNone)
+	defer SetupLedger(0, 0, PolicyEDF)
+	provider := newAdmissionProvider()
+	defer provider.server.Close()
+
+	ac := ReserveAdmissionControl{}
+	defer RemoveFunctionHandler("qos-burstable")
+
+	spec := admissionSpec("qos-burstable", 4)
+	spec.QoS = requests.QoSBurstable
+	if code := deploy(ac, provider, http.MethodPost, spec); code != http.StatusAccepted {
+		t.Errorf("Register qos-burstable - status want: %d, got %d", http.StatusAccepted, code)
+		t.Fail()
+	}
+	if _, reserved := GetLedger().Get("qos-burstable"); !reserved {
+		t.Errorf("Register qos-burstable - want: base rate reserved, got no reservation")
+		t.Fail()
+	}
+	if spec := deployedSpec(provider.lastDeployed()); spec.QoS != requests.QoSBurstable {
+		t.Errorf("Register qos-burstable - spec QoS want: %s, got %+v", requests.QoSBurstable, spec)
+		t.Fail()
+	}
+	if status, _ := RealtimeStatus("qos-burstable"); status.QoS != requests.QoSBurstable {
+		t.Errorf("RealtimeStatus - QoS want: %s, got %s", requests.QoSBurstable, status.QoS)
+		t.Fail()
+	}
+
+	spec = admissionSpec("qos-best-effort", 4)
+	spec.QoS = requests.QoSBestEffort
+	if code := deploy(ac, provider, http.MethodPost, spec); code != http.StatusBadRequest {
+		t.Errorf("Register qos-best-effort - status want: %d, got %d", http.StatusBadRequest, code)
+		t.Fail()
+	}
+	if _, reserved := GetLedger().Get("qos-best-effort"); reserved {
+		t.Errorf("Register qos-best-effort - want: no reservation, got reserved")
+		t.Fail()
+	}
+}
+
+func Test_InvokeBurstableDispatchesBeyondRate(t *testing.T) {
+	SetFunctionHandler(requests.CreateFunctionRequest{Service: "qos-burst", Realtime: 1, Burst: 1, QoS: requests.QoSBurstable})
+	defer RemoveFunctionHandler("qos-burst")
+
+	start := time.Now()
+	called := 0
+	for i := 0; i < 5; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/function/qos-burst", nil)
+		Invoke(func(w http.ResponseWriter, r *http.Request) {
+			called++
+		}, httptest.NewRecorder(), r)
+	}
+	elapsed := time.Since(start)
+
+	if called != 5 {
+		t.Errorf("Invoke - executions want: %d, got %d", 5, called)
+		t.Fail()
+	}
+	// A guaranteed function would pace the excess at one per second
+	if elapsed > 500*time.Millisecond {
+		t.Errorf("Invoke - excess want: dispatched right away, got %s", elapsed)
+		t.Fail()
+	}
+	entry, _ := functionHandlers.Load("qos-burst")
+	if tokens := entry.(*InvocationHandler).Bucket.Tokens(time.Now()); tokens >= 1 {
+		t.Errorf("Tokens - want: base rate used, got %v", tokens)
+		t.Fail()
+	}
+}
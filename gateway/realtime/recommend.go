@@ -0,0 +1,133 @@
+package realtime
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/ngduchai/faas/gateway/requests"
+)
+
+const (
+	// recommendHeadroom is added on top of the observed p99
+	recommendHeadroom = 1.2
+	// DefaultRightSizeFloor is the smallest share of the declared size a
+	// reservation is right-sized to when no floor is configured
+	DefaultRightSizeFloor = 0.25
+)
+
+// Recommendation summarizes the invocations observed for a function
+type Recommendation struct {
+	DurationSamples int
+	MemorySamples   int
+	// DurationP50 and DurationP99 in ms
+	DurationP50 float64
+	DurationP99 float64
+	// MemoryP50 and MemoryP99 in bytes
+	MemoryP50 int64
+	MemoryP99 int64
+	// Timeout (ms) and Memory (bytes) recommended, zero when not observed
+	Timeout uint64
+	Memory  int64
+}
+
+// Recommend derives the size of an invocation of the function from the
+// p99 of its observed durations and peak memory
+func (t *DemandTracker) Recommend(functionName string) Recommendation {
+	recommendation := Recommendation{}
+	entry, ok := t.functions.Load(functionName)
+	if !ok {
+		return recommendation
+	}
+	d := entry.(*functionSamples)
+	d.sync.Lock()
+	defer d.sync.Unlock()
+
+	durations := d.execution
+	if len(durations.values) < minDemandSamples && len(d.latency.values) > len(durations.values) {
+		durations = d.latency
+	}
+	if recommendation.DurationSamples = len(durations.values); recommendation.DurationSamples > 0 {
+		recommendation.DurationP50 = durations.percentile(0.5)
+		recommendation.DurationP99 = durations.percentile(0.99)
+		recommendation.Timeout = uint64(math.Ceil(recommendation.DurationP99 * recommendHeadroom))
+	}
+	if recommendation.MemorySamples = len(d.memory.values); recommendation.MemorySamples > 0 {
+		recommendation.MemoryP50 = int64(d.memory.percentile(0.5))
+		recommendation.MemoryP99 = int64(d.memory.percentile(0.99))
+		recommendation.Memory = int64(math.Ceil(float64(recommendation.MemoryP99) * recommendHeadroom))
+	}
+	return recommendation
+}
+
+// bounded keeps the value between floor times the declared value and the
+// declared value
+func bounded(value int64, declared int64, floor float64) int64 {
+	if floor <= 0 || floor > 1 {
+		floor = DefaultRightSizeFloor
+	}
+	if lower := int64(math.Ceil(floor * float64(declared))); value < lower {
+		return lower
+	}
+	if value > declared {
+		return declared
+	}
+	return value
+}
+
+// MakeRealtimeRecommendationHandler compares the declared size of a
+// function with the size its invocations were observed to use
+func MakeRealtimeRecommendationHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		rm := ResourceManager{}
+		declared, err := rm.GetDeploymentParams(name)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Function " + name + " not found"))
+			return
+		}
+
+		tracker := GetDemandTracker()
+		observed := tracker.Recommend(name)
+		out := requests.RealtimeRecommendation{
+			Function:        name,
+			DurationSamples: observed.DurationSamples,
+			MemorySamples:   observed.MemorySamples,
+			Declared: requests.RealtimeSize{
+				Timeout: declared.Duration,
+				CPU:     declared.CPU,
+				Memory:  declared.Memory,
+			},
+			Observed: requests.RealtimeObservation{
+				DurationP50: observed.DurationP50,
+				DurationP99: observed.DurationP99,
+				MemoryP50:   observed.MemoryP50,
+				MemoryP99:   observed.MemoryP99,
+			},
+			Recommended: requests.RealtimeSize{
+				Timeout: declared.Duration,
+				CPU:     declared.CPU,
+				Memory:  declared.Memory,
+			},
+			RightSized: tracker.RightSize,
+		}
+		if observed.DurationSamples >= minDemandSamples {
+			out.Recommended.Timeout = observed.Timeout
+		}
+		if observed.MemorySamples >= minDemandSamples {
+			out.Recommended.Memory = observed.Memory
+		}
+
+		body, err := json.Marshal(out)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}
+}
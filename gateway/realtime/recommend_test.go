@@ -0,0 +1,140 @@
+package realtime
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ngduchai/faas/gateway/requests"
+	"github.com/ngduchai/faas/gateway/scaling"
+)
+
+func Test_RecommendFromObservedPercentiles(t *testing.T) {
+	tracker := NewDemandTracker(0.99, false)
+	for i := 1; i <= 100; i++ {
+		tracker.ObserveExecution("recommend", time.Duration(i)*time.Millisecond)
+		tracker.ObservePeakMemory("recommend", int64(i)*1000)
+	}
+
+	recommendation := tracker.Recommend("recommend")
+	if recommendation.DurationSamples != 100 || recommendation.DurationP50 != 50 || recommendation.DurationP99 != 99 {
+		t.Errorf("Recommend - durations want: %d %v %v, got %d %v %v", 100, 50, 99,
+			recommendation.DurationSamples, recommendation.DurationP50, recommendation.DurationP99)
+		t.Fail()
+	}
+	if recommendation.MemoryP50 != 50000 || recommendation.MemoryP99 != 99000 {
+		t.Errorf("Recommend - memory want: %d %d, got %d %d", 50000, 99000, recommendation.MemoryP50, recommendation.MemoryP99)
+		t.Fail()
+	}
+	if recommendation.Timeout != 119 || recommendation.Memory != 118800 {
+		t.Errorf("Recommend - size want: %d %d, got %d %d", 119, 118800, recommendation.Timeout, recommendation.Memory)
+		t.Fail()
+	}
+}
+
+func Test_RightSizeWithinBounds(t *testing.T) {
+	functionName := "recommend-rightsize"
+	SetupDemandTracker(0.99, false)
+	defer SetupDemandTracker(0.99, false)
+	tracker := GetDemandTracker()
+	tracker.RightSize = true
+	tracker.Floor = 0.5
+
+	for i := 0; i < minDemandSamples; i++ {
+		tracker.ObserveExecution(functionName, 10*time.Millisecond)
+		tracker.ObservePeakMemory(functionName, 300)
+	}
+
+	rm := ResourceManager{}
+	request := requests.CreateFunctionRequest{Service: functionName, Realtime: 10, Timeout: 100}
+	rm.RightSize(&request, 1000)
+	// 12ms is below half the declared timeout, 360 bytes below half the memory
+	if request.Demand != 50 || request.DemandMemory != 500 {
+		t.Errorf("RightSize - want: %d %d, got %d %d", 50, 500, request.Demand, request.DemandMemory)
+		t.Fail()
+	}
+	res := reservationFor(request, 1000, 1000)
+	if res.CPU != 500 || res.Memory != 250 {
+		t.Errorf("reservationFor - want: %d %d, got %d %d", 500, 250, res.CPU, res.Memory)
+		t.Fail()
+	}
+	if declared := declaredReservation(request, 1000, 1000); declared.CPU != 1000 || declared.Memory != 1000 {
+		t.Errorf("declaredReservation - want: %d %d, got %d %d", 1000, 1000, declared.CPU, declared.Memory)
+		t.Fail()
+	}
+
+	// Invocations larger than declared keep the declared size
+	for i := 0; i < maxSamples; i++ {
+		tracker.ObservePeakMemory(functionName, 5000)
+	}
+	rm.RightSize(&request, 1000)
+	if request.DemandMemory != 0 {
+		t.Errorf("RightSize - memory want: %d, got %d", 0, request.DemandMemory)
+		t.Fail()
+	}
+
+	tracker.RightSize = false
+	rm.RightSize(&request, 1000)
+	if request.Demand != 0 || request.DemandMemory != 0 {
+		t.Errorf("RightSize - disabled want: %d %d, got %d %d", 0, 0, request.Demand, request.DemandMemory)
+		t.Fail()
+	}
+}
+
+func Test_InvokeRecordsWatchdogPeakMemory(t *testing.T) {
+	functionName := "recommend-watchdog"
+	SetupDemandTracker(0.99, false)
+	defer SetupDemandTracker(0.99, false)
+	SetFunctionHandler(requests.CreateFunctionRequest{Service: functionName})
+	defer RemoveFunctionHandler(functionName)
+
+	handler := MakeRealtimeInvokeHandler(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(DurationHeader, "0.010")
+		w.Header().Set(PeakMemoryHeader, "2048")
+		w.WriteHeader(http.StatusOK)
+	})
+	r := httptest.NewRequest(http.MethodGet, "/function/"+functionName, nil)
+	handler(httptest.NewRecorder(), mux.SetURLVars(r, map[string]string{"name": functionName}))
+
+	if recommendation := GetDemandTracker().Recommend(functionName); recommendation.MemorySamples != 1 || recommendation.MemoryP99 != 2048 {
+		t.Errorf("Recommend - memory want: %d %d, got %d %d", 1, 2048, recommendation.MemorySamples, recommendation.MemoryP99)
+		t.Fail()
+	}
+}
+
+func Test_RecommendationHandler(t *testing.T) {
+	functionName := "recommend-handler"
+	SetupDemandTracker(0.99, false)
+	defer SetupDemandTracker(0.99, false)
+	scaling.GetScalerInstance().Config.ServiceQuery = ReadyServiceQuery{
+		Response: scaling.ServiceQueryResponse{Replicas: 1, AvailableReplicas: 1, Realtime: 5, CPU: 500, Memory: 1 << 20, Duration: 1000},
+	}
+	for i := 0; i < minDemandSamples; i++ {
+		GetDemandTracker().ObserveExecution(functionName, 100*time.Millisecond)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/system/realtime/"+functionName+"/recommendation", nil)
+	w := httptest.NewRecorder()
+	MakeRealtimeRecommendationHandler()(w, mux.SetURLVars(r, map[string]string{"name": functionName}))
+	if w.Code != http.StatusOK {
+		t.Errorf("Recommendation - status want: %d, got %d", http.StatusOK, w.Code)
+		t.FailNow()
+	}
+
+	recommendation := requests.RealtimeRecommendation{}
+	json.Unmarshal(w.Body.Bytes(), &recommendation)
+	want := requests.RealtimeRecommendation{
+		Function:        functionName,
+		DurationSamples: minDemandSamples,
+		Declared:        requests.RealtimeSize{Timeout: 1000, CPU: 500, Memory: 1 << 20},
+		Observed:        requests.RealtimeObservation{DurationP50: 100, DurationP99: 100},
+		Recommended:     requests.RealtimeSize{Timeout: 120, CPU: 500, Memory: 1 << 20},
+	}
+	if recommendation != want {
+		t.Errorf("Recommendation - want: %+v, got %+v", want, recommendation)
+		t.Fail()
+	}
+}
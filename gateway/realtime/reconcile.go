@@ -0,0 +1,267 @@
+package realtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ngduchai/faas/gateway/realtime/specs"
+	"github.com/ngduchai/faas/gateway/requests"
+	"github.com/openfaas/faas-provider/auth"
+)
+
+// pendingChanges holds the functions being deployed, updated or removed
+// through the gateway. The reconciler leaves them alone until the change
+// completes since the provider may not reflect it yet.
+var pendingChanges sync.Map
+
+// claimPending marks the function as being changed, it returns false when
+// another change is already in flight so that two changes to a function
+// never run at once
+func claimPending(functionName string) bool {
+	_, inFlight := pendingChanges.LoadOrStore(functionName, true)
+	return !inFlight
+}
+
+func clearPending(functionName string) {
+	pendingChanges.Delete(functionName)
+}
+
+func isPending(functionName string) bool {
+	_, pending := pendingChanges.Load(functionName)
+	return pending
+}
+
+// writeChangeInFlight turns away a change to a function which is already
+// being changed
+func writeChangeInFlight(w http.ResponseWriter, functionName string) (int, error) {
+	err := fmt.Errorf("A change to function %s is already in progress, retry once it completes", functionName)
+	log.Println(err)
+	w.WriteHeader(http.StatusConflict)
+	w.Write([]byte(err.Error()))
+	return http.StatusConflict, err
+}
+
+// Reconciler rebuilds invocation handlers and reservations of realtime
+// functions from the functions deployed on the provider
+type Reconciler struct {
+	URL         url.URL
+	Credentials *auth.BasicAuthCredentials
+	ProxyClient http.Client
+}
+
+// NewReconciler creates a reconciler for the provider found at externalURL
+func NewReconciler(externalURL url.URL, credentials *auth.BasicAuthCredentials) *Reconciler {
+	timeout := 3 * time.Second
+
+	proxyClient := http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   timeout,
+				KeepAlive: 0,
+			}).DialContext,
+			MaxIdleConns:          1,
+			DisableKeepAlives:     true,
+			IdleConnTimeout:       120 * time.Millisecond,
+			ExpectContinueTimeout: 1500 * time.Millisecond,
+		},
+	}
+
+	return &Reconciler{
+		URL:         externalURL,
+		Credentials: credentials,
+		ProxyClient: proxyClient,
+	}
+}
+
+// Start reconciles immediately and then at every interval
+func (rc *Reconciler) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := rc.Reconcile(); err != nil {
+				log.Printf("Unable to reconcile realtime functions: %s", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// Reconcile lists the functions deployed on the provider and brings the
+// realtime handlers in line with them
+func (rc *Reconciler) Reconcile() error {
+	functions, err := rc.listFunctions()
+	if err != nil {
+		return err
+	}
+	reconcileFunctions(functions)
+	return nil
+}
+
+func (rc *Reconciler) listFunctions() ([]reportedFunction, error) {
+	functions := []reportedFunction{}
+
+	urlPath := fmt.Sprintf("%ssystem/functions", rc.URL.String())
+	req, _ := http.NewRequest(http.MethodGet, urlPath, nil)
+	if rc.Credentials != nil {
+		req.SetBasicAuth(rc.Credentials.User, rc.Credentials.Password)
+	}
+
+	res, err := rc.ProxyClient.Do(req)
+	if err != nil {
+		return functions, err
+	}
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return functions, fmt.Errorf("server returned non-200 status code (%d) for %s", res.StatusCode, urlPath)
+	}
+
+	bytesOut, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return functions, err
+	}
+	err = json.Unmarshal(bytesOut, &functions)
+	return functions, err
+}
+
+// reconcileFunctions creates or retunes the handlers of the given functions,
+// restores their reservations and specs, and removes the handlers of
+// functions which no longer exist. Functions being changed through the
+// gateway are left alone.
+func reconcileFunctions(functions []reportedFunction) {
+	ledger := GetLedger()
+	deployed := map[string]bool{}
+
+	for _, function := range functions {
+		deployed[function.Name] = true
+		if !claimPending(function.Name) {
+			continue
+		}
+		reconcileFunction(function)
+		clearPending(function.Name)
+	}
+
+	functionHandlers.Range(func(key interface{}, value interface{}) bool {
+		functionName := key.(string)
+		if !deployed[functionName] && claimPending(functionName) {
+			log.Printf("Function %s no longer exists, remove handler", functionName)
+			RemoveFunctionHandler(functionName)
+			revokeLease(functionName)
+			ledger.Release(functionName)
+			clearPending(functionName)
+		}
+		return true
+	})
+	deployedSpecs.Range(func(key interface{}, value interface{}) bool {
+		functionName := key.(string)
+		if !deployed[functionName] && claimPending(functionName) {
+			forgetSpec(functionName)
+			clearPending(functionName)
+		}
+		return true
+	})
+}
+
+// reconcileFunction brings the handler, reservation, spec and lease of the
+// function in line with what the provider reports
+func reconcileFunction(function reportedFunction) {
+	ledger := GetLedger()
+	rm := ResourceManager{}
+
+	request, err := requestFromFunction(function.Function)
+	if err != nil {
+		log.Printf("Unable to read realtime parameters of %s: %s", function.Name, err)
+		return
+	}
+	// The spec the gateway deployed is kept over the one rebuilt from the
+	// provider, which only stands in after a restart
+	if _, known := lookupSpec(function.Name); !known && len(function.Image) > 0 {
+		rememberSpec(deployedFrom(function, request))
+	}
+
+	active := request
+	if specs.PeakRealtime(request) > 0 {
+		cpus, memory, err := rm.GetResourceQuantity(*request.Resources)
+		if err != nil {
+			log.Printf("Unable to read resources of %s: %s", function.Name, err)
+			return
+		}
+		reservation, err := scheduledReservation(request, cpus, memory, time.Now())
+		if err != nil {
+			log.Printf("Unable to read schedule of %s: %s", function.Name, err)
+			return
+		}
+		active.Realtime = reservation.Task.Rate
+		if current, exists := ledger.Get(function.Name); !exists || !current.same(reservation) {
+			if _, _, err := ledger.Reserve(function.Name, reservation); err != nil {
+				// The function is already running, so keep track of what it
+				// holds even though the cluster is over-committed
+				log.Printf("Reservation of %s exceeds capacity: %s", function.Name, err)
+				ledger.Restore(function.Name, reservation, true)
+			}
+			log.Printf("Restored reservation of %s: CPU: %d Memory %d", function.Name, reservation.CPU, reservation.Memory)
+		}
+	} else {
+		ledger.Release(function.Name)
+	}
+
+	// Best-effort functions go straight to the provider, so they only need
+	// a handler when they used to be realtime or may backfill a pool
+	entry, exists := functionHandlers.Load(function.Name)
+	if (!exists && (active.Realtime > 0 || len(request.Pool) > 0)) || (exists && entry.(*InvocationHandler).changed(active)) {
+		log.Printf("Reconcile handler of %s with realtime %f", function.Name, active.Realtime)
+		SetFunctionHandler(active)
+	}
+
+	// A function found with a lease the gateway does not hold expires as
+	// recorded in its spec, or is granted a whole lease if deployed before
+	// the expiry was recorded
+	grantLease(request, time.Now(), false)
+}
+
+// requestFromLabels rebuilds the realtime parameters of a function from the
+// labels PackageRequest wrote before the gateway kept a reservation spec
+func requestFromLabels(function requests.Function) (requests.CreateFunctionRequest, error) {
+	request := requests.CreateFunctionRequest{
+		Service: function.Name,
+		Resources: &requests.FunctionResources{
+			CPU:    "0",
+			Memory: "0",
+		},
+	}
+	if function.Labels == nil {
+		return request, nil
+	}
+	labels := *function.Labels
+
+	var err error
+	if value, ok := labels["realtime"]; ok && len(value) > 0 {
+		if request.Realtime, err = strconv.ParseFloat(value, 64); err != nil {
+			return request, err
+		}
+	}
+	if value, ok := labels["duration"]; ok && len(value) > 0 {
+		if request.Timeout, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return request, err
+		}
+	}
+	if value, ok := labels["cpu"]; ok && len(value) > 0 {
+		request.Resources.CPU = value
+	}
+	if value, ok := labels["memory"]; ok && len(value) > 0 {
+		request.Resources.Memory = value
+	}
+	return request, nil
+}
@@ -0,0 +1,114 @@
+package realtime
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/ngduchai/faas/gateway/requests"
+)
+
+func Test_RequestFromLabels(t *testing.T) {
+	function := requests.Function{
+		Name: "rt",
+		Labels: &map[string]string{
+			"realtime": "2.5",
+			"cpu":      "500m",
+			"memory":   "128Mi",
+			"duration": "200",
+		},
+	}
+
+	request, err := requestFromLabels(function)
+	if err != nil {
+		t.Errorf("requestFromLabels - error want: %s, got %s", "nil", err.Error())
+		t.FailNow()
+	}
+	if request.Service != "rt" || request.Realtime != 2.5 || request.Timeout != 200 {
+		t.Errorf("requestFromLabels - unexpected request %+v", request)
+		t.Fail()
+	}
+	if request.Resources.CPU != "500m" || request.Resources.Memory != "128Mi" {
+		t.Errorf("requestFromLabels - unexpected resources %+v", *request.Resources)
+		t.Fail()
+	}
+
+	(*function.Labels)["realtime"] = "fast"
+	if _, err = requestFromLabels(function); err == nil {
+		t.Errorf("requestFromLabels - invalid realtime want: %s, got %s", "error", "nil")
+		t.Fail()
+	}
+}
+
+func Test_ReconcileRebuildsAndRemovesHandlers(t *testing.T) {
+	SetupLedger(0, 0, PolicyEDF)
+
+	functions := []requests.Function{
+		{
+			Name:  "reconcile-rt",
+			Image: "functions/reconcile-rt:latest",
+			Labels: &map[string]string{
+				"realtime": "10",
+				"cpu":      "100m",
+				"memory":   "100",
+				"duration": "100",
+			},
+		},
+		{Name: "reconcile-be"},
+	}
+
+	testServer := httptest.NewServer(
+		http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			body, _ := json.Marshal(functions)
+			res.WriteHeader(http.StatusOK)
+			res.Write(body)
+		}))
+	defer testServer.Close()
+
+	providerURL, _ := url.Parse(testServer.URL + "/")
+	reconciler := NewReconciler(*providerURL, nil)
+
+	if err := reconciler.Reconcile(); err != nil {
+		t.Errorf("Reconcile - error want: %s, got %s", "nil", err.Error())
+		t.FailNow()
+	}
+
+	entry, exists := functionHandlers.Load("reconcile-rt")
+	if !exists || entry.(*InvocationHandler).params().Realtime != 10 {
+		t.Errorf("Reconcile - handler of reconcile-rt was not rebuilt")
+		t.Fail()
+	}
+	if res, exists := GetLedger().Get("reconcile-rt"); !exists || res.CPU != 100 || res.Memory != 100 {
+		t.Errorf("Reconcile - reservation of reconcile-rt want: %d %d, got %d %d", 100, 100, res.CPU, res.Memory)
+		t.Fail()
+	}
+	if _, exists := functionHandlers.Load("reconcile-be"); exists {
+		t.Errorf("Reconcile - best-effort function should not get a handler")
+		t.Fail()
+	}
+	// The spec is known again, so the function can be renegotiated
+	if spec, known := lookupSpec("reconcile-rt"); !known || spec.Image != "functions/reconcile-rt:latest" || spec.Realtime != 10 {
+		t.Errorf("Reconcile - spec of reconcile-rt want: image and rate %f, got %v %+v", 10.0, known, spec)
+		t.Fail()
+	}
+
+	functions = []requests.Function{}
+	if err := reconciler.Reconcile(); err != nil {
+		t.Errorf("Reconcile - error want: %s, got %s", "nil", err.Error())
+		t.FailNow()
+	}
+	if _, exists := functionHandlers.Load("reconcile-rt"); exists {
+		t.Errorf("Reconcile - handler of removed function still exists")
+		t.Fail()
+	}
+	if _, exists := GetLedger().Get("reconcile-rt"); exists {
+		t.Errorf("Reconcile - reservation of removed function still exists")
+		t.Fail()
+	}
+	if _, known := lookupSpec("reconcile-rt"); known {
+		t.Errorf("Reconcile - spec of removed function still known")
+		t.Fail()
+	}
+}
@@ -0,0 +1,280 @@
+package realtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ngduchai/faas/gateway/handlers"
+	"github.com/ngduchai/faas/gateway/requests"
+	"github.com/ngduchai/faas/gateway/types"
+)
+
+// deployedSpecs holds the last spec the gateway deployed for every function,
+// as sent by the caller before the sandbox was sized, so that the realtime
+// parameters can be changed without the caller sending the spec again
+var deployedSpecs sync.Map
+
+func rememberSpec(spec requests.CreateFunctionRequest) {
+	deployedSpecs.Store(spec.Service, cloneSpec(spec))
+}
+
+func forgetSpec(functionName string) {
+	deployedSpecs.Delete(functionName)
+}
+
+func lookupSpec(functionName string) (requests.CreateFunctionRequest, bool) {
+	entry, ok := deployedSpecs.Load(functionName)
+	if !ok {
+		return requests.CreateFunctionRequest{}, false
+	}
+	return cloneSpec(entry.(requests.CreateFunctionRequest)), true
+}
+
+// cloneSpec copies the spec so that packaging one copy for the provider
+// does not alter the other
+func cloneSpec(spec requests.CreateFunctionRequest) requests.CreateFunctionRequest {
+	clone := spec
+	if spec.Labels != nil {
+		labels := map[string]string{}
+		for k, v := range *spec.Labels {
+			labels[k] = v
+		}
+		clone.Labels = &labels
+	}
+	if spec.Annotations != nil {
+		annotations := map[string]string{}
+		for k, v := range *spec.Annotations {
+			annotations[k] = v
+		}
+		clone.Annotations = &annotations
+	}
+	if spec.EnvVars != nil {
+		clone.EnvVars = map[string]string{}
+		for k, v := range spec.EnvVars {
+			clone.EnvVars[k] = v
+		}
+	}
+	if spec.Resources != nil {
+		resources := *spec.Resources
+		clone.Resources = &resources
+	}
+	if spec.Requests != nil {
+		resources := *spec.Requests
+		clone.Requests = &resources
+	}
+	if spec.Limits != nil {
+		resources := *spec.Limits
+		clone.Limits = &resources
+	}
+	return clone
+}
+
+// Renegotiator changes the realtime parameters of deployed functions. The
+// change goes through the Update of the admission control, so the new
+// reservation is admitted, the sandbox resized through the provider and the
+// handler retuned in place, or the previous reservation is kept.
+type Renegotiator struct {
+	AdmissionControl AdmissionControl
+	Client           *http.Client
+	BaseURLResolver  handlers.BaseURLResolver
+	Timeout          time.Duration
+}
+
+// NewRenegotiator creates a renegotiator updating functions through the given proxy
+func NewRenegotiator(ac AdmissionControl, proxy *types.HTTPClientReverseProxy, baseURLResolver handlers.BaseURLResolver) *Renegotiator {
+	return &Renegotiator{
+		AdmissionControl: ac,
+		Client:           proxy.Client,
+		BaseURLResolver:  baseURLResolver,
+		Timeout:          proxy.Timeout,
+	}
+}
+
+// lookupDeployed returns the spec the gateway deployed for the function.
+// The spec of a function deployed before the gateway started is rebuilt
+// from what the provider reports.
+func (rn *Renegotiator) lookupDeployed(functionName string) (requests.CreateFunctionRequest, bool) {
+	if spec, known := lookupSpec(functionName); known {
+		return spec, true
+	}
+	spec, err := rn.compensator().fetchDeployed(functionName)
+	if err != nil {
+		log.Printf("Unable to rebuild the spec of %s: %s", functionName, err)
+		return spec, false
+	}
+	rememberSpec(spec)
+	return spec, true
+}
+
+// appliedRate returns the rate the sandbox of the function was last sized
+// for. The rate of a function deployed before the gateway started is read
+// from the reservation spec the provider reports.
+func (rn *Renegotiator) appliedRate(functionName string) (float64, bool) {
+	if applied, known := lookupApplied(functionName); known {
+		return applied.Active, true
+	}
+	spec, err := rn.compensator().fetchDeployed(functionName)
+	if err != nil {
+		log.Printf("Unable to read the applied rate of %s: %s", functionName, err)
+		return 0, false
+	}
+	return spec.Active, true
+}
+
+// Renegotiate applies the change to the function and returns the status
+// code and body of the update
+func (rn *Renegotiator) Renegotiate(functionName string, change requests.RealtimeRenegotiation) (int, []byte, error) {
+	spec, known := rn.lookupDeployed(functionName)
+	if !known {
+		err := fmt.Errorf("Deployment spec of %s is unknown to the gateway, update it through PUT /system/functions", functionName)
+		return http.StatusPreconditionFailed, []byte(err.Error()), err
+	}
+	if change.Realtime != nil {
+		spec.Realtime = *change.Realtime
+	}
+	if change.Timeout > 0 {
+		spec.Timeout = change.Timeout
+	}
+	if change.Resources != nil {
+		resources := *change.Resources
+		spec.Resources = &resources
+	}
+
+	log.Printf("Renegotiate %s: realtime %f timeout %d", functionName, spec.Realtime, spec.Timeout)
+	return rn.update(spec)
+}
+
+// Downgrade redeploys the function as best-effort, which releases its
+// reservation
+func (rn *Renegotiator) Downgrade(functionName string) (int, []byte, error) {
+	spec, known := rn.lookupDeployed(functionName)
+	if !known {
+		err := fmt.Errorf("Deployment spec of %s is unknown to the gateway", functionName)
+		return http.StatusPreconditionFailed, []byte(err.Error()), err
+	}
+	spec.Realtime = 0
+	spec.Burst = 0
+	spec.Schedule = nil
+	spec.QoS = requests.QoSBestEffort
+	spec.Lease = ""
+	spec.LeasePolicy = ""
+
+	log.Printf("Downgrade %s to best-effort", functionName)
+	return rn.update(spec)
+}
+
+// Remove deletes the function through the admission control
+func (rn *Renegotiator) Remove(functionName string) (int, []byte, error) {
+	body, err := json.Marshal(requests.DeleteFunctionRequest{FunctionName: functionName})
+	if err != nil {
+		return http.StatusInternalServerError, []byte(err.Error()), err
+	}
+	requestURL := "/system/functions"
+	r, _ := http.NewRequest(http.MethodDelete, requestURL, bytes.NewReader(body))
+	w := &bufferedResponse{header: http.Header{}, statusCode: http.StatusOK}
+
+	log.Printf("Remove %s", functionName)
+	statusCode, err := rn.AdmissionControl.Unregister(w, r, rn.Client, rn.BaseURLResolver.Resolve(r), requestURL, rn.Timeout, false)
+	return statusCode, w.body.Bytes(), err
+}
+
+// compensator reads deployed functions from the provider the updates go to
+func (rn *Renegotiator) compensator() Compensator {
+	r, _ := http.NewRequest(http.MethodGet, "/system/functions", nil)
+	return Compensator{Client: rn.Client, BaseURL: rn.BaseURLResolver.Resolve(r), Timeout: rn.Timeout}
+}
+
+// update puts the spec through the Update of the admission control
+func (rn *Renegotiator) update(spec requests.CreateFunctionRequest) (int, []byte, error) {
+	body, err := json.Marshal(spec)
+	if err != nil {
+		return http.StatusInternalServerError, []byte(err.Error()), err
+	}
+	requestURL := "/system/functions"
+	r, _ := http.NewRequest(http.MethodPut, requestURL, bytes.NewReader(body))
+	w := &bufferedResponse{header: http.Header{}, statusCode: http.StatusOK}
+
+	statusCode, err := rn.AdmissionControl.Update(w, r, rn.Client, rn.BaseURLResolver.Resolve(r), requestURL, rn.Timeout, false)
+	return statusCode, w.body.Bytes(), err
+}
+
+var renegotiatorInstance *Renegotiator
+
+// SetupRenegotiator sets the renegotiator used by the gateway
+func SetupRenegotiator(rn *Renegotiator) {
+	renegotiatorInstance = rn
+}
+
+// GetRenegotiator returns the renegotiator used by the gateway, nil until set up
+func GetRenegotiator() *Renegotiator {
+	return renegotiatorInstance
+}
+
+// MakeRealtimeRenegotiateHandler changes the guaranteed rate, and optionally
+// the timeout and resources, of a deployed function
+func MakeRealtimeRenegotiateHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName := mux.Vars(r)["name"]
+
+		change := requests.RealtimeRenegotiation{}
+		body, err := ioutil.ReadAll(r.Body)
+		if err == nil {
+			err = json.Unmarshal(body, &change)
+		}
+		if err == nil && (change.Realtime == nil || *change.Realtime < 0) {
+			err = fmt.Errorf("realtime must be set to a rate of zero or more")
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		rn := GetRenegotiator()
+		if rn == nil {
+			w.WriteHeader(http.StatusNotImplemented)
+			w.Write([]byte("Rate renegotiation is not enabled"))
+			return
+		}
+
+		statusCode, out, err := rn.Renegotiate(functionName, change)
+		if err != nil || statusCode < 200 || statusCode > 299 {
+			log.Printf("Unable to renegotiate %s: %d %v", functionName, statusCode, err)
+			w.WriteHeader(statusCode)
+			w.Write(out)
+			return
+		}
+
+		status, _ := RealtimeStatus(functionName)
+		out, _ = json.Marshal(status)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		w.Write(out)
+	}
+}
+
+// bufferedResponse keeps the response of an update made on behalf of the gateway
+type bufferedResponse struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) Write(data []byte) (int, error) {
+	return b.body.Write(data)
+}
+
+func (b *bufferedResponse) WriteHeader(statusCode int) {
+	b.statusCode = statusCode
+}
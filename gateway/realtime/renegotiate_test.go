@@ -0,0 +1,195 @@
+package realtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ngduchai/faas/gateway/handlers"
+	"github.com/ngduchai/faas/gateway/requests"
+	"github.com/ngduchai/faas/gateway/scaling"
+)
+
+// ReadyServiceQuery reports a function whose replicas are all available
+type ReadyServiceQuery struct {
+	Response scaling.ServiceQueryResponse
+}
+
+func (sq ReadyServiceQuery) GetReplicas(serviceName string) (scaling.ServiceQueryResponse, error) {
+	return sq.Response, nil
+}
+
+func (sq ReadyServiceQuery) SetReplicas(serviceName string, count uint64) error {
+	return nil
+}
+
+func renegotiateRequest(functionName string, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPatch, "/system/realtime/"+functionName, bytes.NewBufferString(body))
+	return mux.SetURLVars(r, map[string]string{"name": functionName})
+}
+
+func Test_RenegotiateRate(t *testing.T) {
+	functionName := "renegotiate"
+	SetupLedger(1000, 0, PolicyNone)
+	defer SetupLedger(0, 0, PolicyEDF)
+
+	providerStatus := http.StatusAccepted
+	deployed := requests.CreateFunctionRequest{}
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/system/function/"+functionName {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method != http.MethodPut || r.URL.Path != "/system/functions" {
+			t.Errorf("Renegotiate - provider request want: %s %s, got %s %s", http.MethodPut, "/system/functions", r.Method, r.URL.Path)
+		}
+		if providerStatus == http.StatusAccepted {
+			body, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(body, &deployed)
+		}
+		w.WriteHeader(providerStatus)
+	}))
+	defer provider.Close()
+
+	scaling.GetScalerInstance().Config.ServiceQuery = ReadyServiceQuery{
+		Response: scaling.ServiceQueryResponse{Replicas: 1, AvailableReplicas: 1, Realtime: 1, CPU: 100, Memory: 1000, Duration: 100},
+	}
+
+	SetupRenegotiator(&Renegotiator{
+		AdmissionControl: ReserveAdmissionControl{},
+		Client:           http.DefaultClient,
+		BaseURLResolver:  handlers.SingleHostBaseURLResolver{BaseURL: provider.URL},
+		Timeout:          time.Second,
+	})
+	defer SetupRenegotiator(nil)
+
+	handler := MakeRealtimeRenegotiateHandler()
+
+	w := httptest.NewRecorder()
+	handler(w, renegotiateRequest(functionName, `{"realtime": 4}`))
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Renegotiate - unknown spec want: %d, got %d", http.StatusPreconditionFailed, w.Code)
+		t.Fail()
+	}
+
+	spec := requests.CreateFunctionRequest{
+		Service:   functionName,
+		Image:     "functions/renegotiate:latest",
+		EnvVars:   map[string]string{"mode": "fast"},
+		Realtime:  1,
+		Timeout:   100,
+		Resources: &requests.FunctionResources{CPU: "100m", Memory: "1000"},
+	}
+	GetLedger().Reserve(functionName, reservationFor(spec, 100, 1000))
+	rememberSpec(spec)
+	rememberApplied(spec)
+	SetFunctionHandler(spec)
+	defer RemoveFunctionHandler(functionName)
+	defer forgetSpec(functionName)
+	defer forgetApplied(functionName)
+
+	w = httptest.NewRecorder()
+	handler(w, renegotiateRequest(functionName, `{"timeout": 200}`))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Renegotiate - missing realtime want: %d, got %d", http.StatusBadRequest, w.Code)
+		t.Fail()
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, renegotiateRequest(functionName, `{"realtime": 4}`))
+	if w.Code != http.StatusAccepted {
+		t.Errorf("Renegotiate - status want: %d, got %d %s", http.StatusAccepted, w.Code, w.Body.String())
+		t.FailNow()
+	}
+	if deployed.Image != spec.Image || deployed.EnvVars["mode"] != "fast" || deployed.Realtime != 4 {
+		t.Errorf("Renegotiate - provider spec want: image, env and new rate, got %+v", deployed)
+		t.Fail()
+	}
+	if deployed.Requests == nil || deployed.Requests.CPU != "40m" {
+		t.Errorf("Renegotiate - sandbox CPU want: %s, got %+v", "40m", deployed.Requests)
+		t.Fail()
+	}
+	if res, _ := GetLedger().Get(functionName); res.CPU != 40 {
+		t.Errorf("Renegotiate - reserved CPU want: %d, got %d", 40, res.CPU)
+		t.Fail()
+	}
+	entry, _ := functionHandlers.Load(functionName)
+	if realtime := entry.(*InvocationHandler).params().Realtime; realtime != 4 {
+		t.Errorf("Renegotiate - handler rate want: %f, got %f", 4.0, realtime)
+		t.Fail()
+	}
+
+	// Over capacity, the reservation and the handler stay as they were
+	w = httptest.NewRecorder()
+	handler(w, renegotiateRequest(functionName, `{"realtime": 200}`))
+	if w.Code != http.StatusConflict {
+		t.Errorf("Renegotiate - over capacity want: %d, got %d", http.StatusConflict, w.Code)
+		t.Fail()
+	}
+	if res, _ := GetLedger().Get(functionName); res.CPU != 40 {
+		t.Errorf("Renegotiate - reserved CPU after rejection want: %d, got %d", 40, res.CPU)
+		t.Fail()
+	}
+
+	// Failure at the provider restores the reservation
+	providerStatus = http.StatusInternalServerError
+	w = httptest.NewRecorder()
+	handler(w, renegotiateRequest(functionName, `{"realtime": 8}`))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Renegotiate - provider failure want: %d, got %d", http.StatusInternalServerError, w.Code)
+		t.Fail()
+	}
+	if res, _ := GetLedger().Get(functionName); res.CPU != 40 {
+		t.Errorf("Renegotiate - reserved CPU after failure want: %d, got %d", 40, res.CPU)
+		t.Fail()
+	}
+	if realtime := entry.(*InvocationHandler).params().Realtime; realtime != 4 {
+		t.Errorf("Renegotiate - handler rate after failure want: %f, got %f", 4.0, realtime)
+		t.Fail()
+	}
+}
+
+func Test_RenegotiateRebuildsSpecFromProvider(t *testing.T) {
+	functionName := "renegotiate-restarted"
+	SetupLedger(1000, 0, PolicyNone)
+	defer SetupLedger(0, 0, PolicyEDF)
+	provider := newAdmissionProvider()
+	defer provider.server.Close()
+	scaling.GetScalerInstance().Config.ServiceQuery = ReadyServiceQuery{
+		Response: scaling.ServiceQueryResponse{Replicas: 1, AvailableReplicas: 1, Realtime: 2, CPU: 1000, Duration: 100},
+	}
+
+	ac := ReserveAdmissionControl{}
+	SetupRenegotiator(&Renegotiator{
+		AdmissionControl: ac,
+		Client:           http.DefaultClient,
+		BaseURLResolver:  handlers.SingleHostBaseURLResolver{BaseURL: provider.server.URL},
+		Timeout:          time.Second,
+	})
+	defer SetupRenegotiator(nil)
+	defer undeploy(ac, provider, functionName)
+
+	if code := deploy(ac, provider, http.MethodPost, admissionSpec(functionName, 2)); code != http.StatusAccepted {
+		t.Errorf("Register - status want: %d, got %d", http.StatusAccepted, code)
+		t.FailNow()
+	}
+	// The gateway restarted and only the provider knows the spec
+	forgetSpec(functionName)
+
+	realtime := 3.0
+	statusCode, body, err := GetRenegotiator().Renegotiate(functionName, requests.RealtimeRenegotiation{Realtime: &realtime})
+	if err != nil || statusCode != http.StatusAccepted {
+		t.Errorf("Renegotiate - status want: %d, got %d %s", http.StatusAccepted, statusCode, body)
+		t.FailNow()
+	}
+	deployed := provider.lastDeployed()
+	if spec := deployedSpec(deployed); deployed.Image != "functions/"+functionName+":latest" || spec.Realtime != 3 || spec.CPU != "1" {
+		t.Errorf("Renegotiate - provider spec want: image, rate %f and CPU %s, got %+v %+v", 3.0, "1", deployed, spec)
+		t.Fail()
+	}
+}
@@ -8,10 +8,63 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/ngduchai/faas/gateway/realtime/specs"
 	"github.com/ngduchai/faas/gateway/requests"
 )
 
+// ReserveAdmissionControl admits a realtime function only if the resources
+// needed to sustain its guaranteed rate can be reserved
 type ReserveAdmissionControl struct {
+	// Ratio is how far the reservations may exceed the capacity, they are
+	// held within the capacity when zero
+	Ratio float64
+	// Utilization weights the reservations of the other functions by the
+	// share they use, when set
+	Utilization func(functionName string) float64
+}
+
+func (ac ReserveAdmissionControl) ratio() float64 {
+	if ac.Ratio < 1 {
+		return 1
+	}
+	return ac.Ratio
+}
+
+// reserve commits the reservation of the function. A reservation rejected
+// for lack of capacity is tried again as best-effort functions are degraded
+// to make room for it.
+func (ac ReserveAdmissionControl) reserve(functionName string, res Reservation) (Reservation, bool, error) {
+	ledger := GetLedger()
+	prev, existed, err := ledger.reserve(functionName, res, ac.ratio(), ac.Utilization)
+	if full, ok := err.(*CapacityError); !ok || !full.At.IsZero() {
+		return prev, existed, err
+	}
+	if cpu, memory := ledger.Occupied(); cpu == 0 && memory == 0 {
+		return prev, existed, err
+	}
+	GetDegrader().MakeRoom(functionName, func() bool {
+		prev, existed, err = ledger.reserve(functionName, res, ac.ratio(), ac.Utilization)
+		return err == nil
+	})
+	return prev, existed, err
+}
+
+// writeUpstreamFailure passes a failed provider response on to the caller
+func writeUpstreamFailure(w http.ResponseWriter, res *http.Response, err error) (int, error) {
+	if res == nil {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(err.Error()))
+		return http.StatusBadGateway, err
+	}
+	if err == nil {
+		err = fmt.Errorf("provider returned status code %d", res.StatusCode)
+	}
+	copyHeaders(w.Header(), &res.Header)
+	w.WriteHeader(res.StatusCode)
+	if res.Body != nil {
+		io.CopyBuffer(w, res.Body, nil)
+	}
+	return res.StatusCode, err
 }
 
 func (ac ReserveAdmissionControl) Register(
@@ -25,6 +78,8 @@ func (ac ReserveAdmissionControl) Register(
 
 	// Deploy throught the resource manager interface
 	rm := ResourceManager{}
+	ledger := GetLedger()
+	deployed := false
 
 	// Get realtime params
 	// functionName, realtime, cpu, memory, duration, error := rm.RequestRealtimeParams(r)
@@ -36,18 +91,26 @@ func (ac ReserveAdmissionControl) Register(
 		err = errors.New("Function parameters are invalid")
 		return statusCode, err
 	}
-	// numReplicas := uint64(math.Ceil(realtime * size * float64(duration)))
-	// if numReplicas < 1 {
-	// 	numReplicas = 1
-	// }
-	// numReplicas := uint64(1)
+	if err := validateRequest(request); err != nil {
+		return writeInvalidRequest(w, request.Service, err)
+	}
+	if !claimPending(request.Service) {
+		return writeChangeInFlight(w, request.Service)
+	}
+	defer clearPending(request.Service)
 	if request.Resources == nil {
 		request.Resources = &requests.FunctionResources{
 			CPU:    "0",
 			Memory: "0",
 		}
 	}
-	if request.Realtime > 0 {
+	spec := cloneSpec(request)
+	// active holds the rate guaranteed now, the labels keep the base rate
+	// of the schedule
+	active := request.Realtime
+	replicas := uint64(1)
+	warm := false
+	if specs.PeakRealtime(request) > 0 {
 		cpus, memory, err := rm.GetResourceQuantity(*request.Resources)
 		if err != nil {
 			log.Printf("Reading parameters error: %s", err)
@@ -56,61 +119,96 @@ func (ac ReserveAdmissionControl) Register(
 			err = errors.New("Function parameters are invalid")
 			return statusCode, err
 		}
-		//numReplicas := 1
 		log.Printf("per-invocation: cpus: %d, memory: %d\n", cpus, memory)
-		totalCPU := int64(request.Realtime * float64(cpus) * float64(request.Timeout) / 1000)
-		totalMemory := int64(request.Realtime * float64(memory) * float64(request.Timeout) / 1000)
-		log.Printf("CPU: %d Memory %d", totalCPU, totalMemory)
-		rm.SetSandboxResources(&request, totalCPU, totalMemory)
+		rm.RightSize(&request, memory)
+		reservation, _ := scheduledReservation(request, cpus, memory, time.Now())
+		active = reservation.Task.Rate
+		log.Printf("CPU: %d Memory %d", reservation.CPU, reservation.Memory)
+
+		// Make sure the cluster can hold the reservation before deploying
+		prev, existed, err := ac.reserve(request.Service, reservation)
+		if err != nil {
+			log.Printf("Reject function %s: %s", request.Service, err)
+			return ac.writeRejection(w, request, cpus, memory, err), err
+		}
+		defer func() {
+			if !deployed {
+				ledger.Restore(request.Service, prev, existed)
+			}
+		}()
+		// Limits follow the rate in force rather than the base rate
+		current := request
+		current.Realtime = reservation.Task.Rate
+		declared := declaredReservation(current, cpus, memory)
+		replicas = rm.SizeReplicas(&request, reservation, declared)
+		warm = reservation.Warm > 0
 	}
+	request.Active = active
+	stampLease(&request, time.Now(), true)
 	rm.PackageRequest(request, r)
-	res, err := rm.CreateImage(r, proxyClient, baseURL, requestURL, timeout, writeRequestURI)
+	applied, err := rm.ParseRequest(r)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		w.WriteHeader(statusCode)
+		return statusCode, err
+	}
+
+	// A deployment in doubt is removed, so the function ends up either
+	// deployed or absent
+	saga := NewSaga(request.Service, sagaDeploy, Compensator{
+		Client:     proxyClient,
+		BaseURL:    baseURL,
+		RequestURL: requestURL,
+		Timeout:    timeout,
+	})
+	var res *http.Response
+	err = saga.Step("create-image", Compensation{Action: compensateRemove}, func() error {
+		var err error
+		res, err = rm.CreateImage(r, proxyClient, baseURL, requestURL, timeout, writeRequestURI)
+		return providerFailure(res, err)
+	})
 	if err != nil {
-		if res.Body != nil {
-			io.CopyBuffer(w, res.Body, nil)
+		if abortErr := saga.Abort(); abortErr != nil {
+			log.Printf("Unable to roll back deployment of %s: %s", request.Service, abortErr)
 		}
-		copyHeaders(w.Header(), &res.Header)
-		w.WriteHeader(res.StatusCode)
-		return res.StatusCode, err
+		return writeUpstreamFailure(w, res, err)
+	}
+	// The provider starts a single replica, scale out to the replicas the
+	// reservation is split among and wait until they are available
+	if specs.PeakRealtime(request) > 0 {
+		log.Printf("Scale function %s to %d", request.Service, replicas)
+		err = saga.Step("scale", Compensation{Action: compensateScale, Replicas: 1}, func() error {
+			return rm.ScaleAndWait(request.Service, replicas)
+		})
+		if err != nil {
+			log.Printf("Unable to scale %s: %s", request.Service, err)
+			statusCode := http.StatusInternalServerError
+			err = errors.New("Insuffcient resources. Cancel deployment")
+			if abortErr := saga.Abort(); abortErr != nil {
+				err = fmt.Errorf("Insuffcient resources. Cancel deployment: %s", abortErr)
+			}
+			w.WriteHeader(statusCode)
+			w.Write([]byte(err.Error()))
+			return statusCode, err
+		}
+	}
+	saga.Commit()
+	if warm {
+		warmUp(proxyClient, baseURL, request.Service, replicas, timeout)
 	}
 
 	statusCode := http.StatusAccepted
-	// The function is deployed successfully, now we need to scale to enforce the
-	// guaranteed invocation rate
-	// if realtime > 0 {
-	// 	canScale := false
-	// 	// Scale!
-	// 	log.Printf("Scale function %s to %d\n", functionName, numReplicas)
-	// 	error = rm.Scale(functionName, numReplicas)
-	// 	if error == nil {
-	// 		retries := numReplicas * 2
-	// 		if retries < 10 {
-	// 			retries = 10
-	// 		}
-	// 		canScale = rm.WaitForAvailReplicas(functionName, numReplicas, retries, 1000)
-	// 	}
-	// 	// Check if we can scale successfully
-	// 	if !canScale {
-	// 		log.Printf("Cannot scale\n")
-	// 		// If we fail to scale the function, then rollback the deployment
-	// 		_, error := rm.RemoveImage(r, proxyClient, baseURL, requestURL, timeout, writeRequestURI)
-	// 		if error != nil {
-	// 			log.Printf("Unable to rollback function %s deployment", functionName)
-	// 		}
-	// 		statusCode = http.StatusInternalServerError
-	// 		error = errors.New("Insuffcient resources. Cancel deployment")
-	// 	}
-	// }
 	w.WriteHeader(statusCode)
-	if err != nil {
-		w.Write([]byte(err.Error()))
-	} else {
-		// Create handler
-		log.Println("Create function handler")
-		SetFunctionHandler(request)
-	}
+	deployed = true
+	rememberSpec(spec)
+	rememberApplied(applied)
+	grantLease(request, time.Now(), true)
+	// Create handler
+	log.Println("Create function handler")
+	request.Realtime = active
+	SetFunctionHandler(request)
 
-	return statusCode, err
+	return statusCode, nil
 }
 
 func (ac ReserveAdmissionControl) Update(
@@ -124,6 +222,8 @@ func (ac ReserveAdmissionControl) Update(
 
 	// Update through the resource manager interface
 	rm := ResourceManager{}
+	ledger := GetLedger()
+	updated := false
 
 	// First, extract real-time parameters from the request
 	request, err := rm.ParseRequest(r)
@@ -133,12 +233,22 @@ func (ac ReserveAdmissionControl) Update(
 		err = errors.New("Function parameters are invalid")
 		return statusCode, err
 	}
-	// numReplicas := uint64(math.Ceil(realtime * size * float64(duration)))
-	// if numReplicas < 1 {
-	// 	numReplicas = 1
-	// }
+	if err := validateRequest(request); err != nil {
+		return writeInvalidRequest(w, request.Service, err)
+	}
+	if !claimPending(request.Service) {
+		return writeChangeInFlight(w, request.Service)
+	}
+	defer clearPending(request.Service)
 	functionName := request.Service
 	numReplicas := uint64(1)
+	if request.Resources == nil {
+		request.Resources = &requests.FunctionResources{
+			CPU:    "0",
+			Memory: "0",
+		}
+	}
+	spec := cloneSpec(request)
 	cpus, memory, err := rm.GetResourceQuantity(*request.Resources)
 	if err != nil {
 		log.Printf("Reading parameters error: %s", err)
@@ -147,93 +257,120 @@ func (ac ReserveAdmissionControl) Update(
 		err = errors.New("Function parameters are invalid")
 		return statusCode, err
 	}
-	//numReplicas := 1
-	totalCPU := int64(request.Realtime * float64(cpus) * float64(request.Timeout) / 1000)
-	totalMemory := int64(request.Realtime * float64(memory) * float64(request.Timeout) / 1000)
-	log.Printf("CPU: %d Memory %d", totalCPU, totalMemory)
-	if request.Realtime > 0 {
-		rm.SetSandboxResources(&request, totalCPU, totalMemory)
+	rm.RightSize(&request, memory)
+	reservation, _ := scheduledReservation(request, cpus, memory, time.Now())
+	active := reservation.Task.Rate
+	log.Printf("CPU: %d Memory %d", reservation.CPU, reservation.Memory)
+
+	// Swap the reservation held by the function, the previous one is kept
+	// so that it can be put back if the update fails
+	var prevReservation Reservation
+	var reserved bool
+	if specs.PeakRealtime(request) > 0 {
+		prevReservation, reserved, err = ac.reserve(functionName, reservation)
+		if err != nil {
+			log.Printf("Reject update of %s: %s", functionName, err)
+			return ac.writeRejection(w, request, cpus, memory, err), err
+		}
+		// Limits follow the rate in force rather than the base rate
+		current := request
+		current.Realtime = reservation.Task.Rate
+		declared := declaredReservation(current, cpus, memory)
+		numReplicas = rm.SizeReplicas(&request, reservation, declared)
+	} else {
+		prevReservation, reserved = ledger.Release(functionName)
 	}
+	defer func() {
+		if !updated {
+			ledger.Restore(functionName, prevReservation, reserved)
+		}
+	}()
+	request.Active = active
+	stampLease(&request, time.Now(), false)
 	rm.PackageRequest(request, r)
+	applied, err := rm.ParseRequest(r)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		w.WriteHeader(statusCode)
+		return statusCode, err
+	}
 
-	// Capture the current real-time parameter if backoff is needed
+	// Capture the current deployment, the update is rolled back to it
 	prevParams, err := rm.GetDeploymentParams(functionName)
 	if err != nil {
 		statusCode := http.StatusNotFound
 		w.WriteHeader(statusCode)
 		return statusCode, err
 	}
-
-	// Update the image
-	res, error := rm.UpdateImage(r, proxyClient, baseURL, requestURL, timeout, writeRequestURI)
-	if error != nil {
-		if res.Body != nil {
-			io.CopyBuffer(w, res.Body, nil)
-		}
-		copyHeaders(w.Header(), &res.Header)
-		w.WriteHeader(res.StatusCode)
-		return res.StatusCode, error
+	compensator := Compensator{
+		Client:     proxyClient,
+		BaseURL:    baseURL,
+		RequestURL: requestURL,
+		Timeout:    timeout,
 	}
-	statusCode := http.StatusAccepted
-	if prevParams.Realtime > 0 || request.Realtime > 0 {
-		// Scale!
-		canScale := false
-		error = rm.Scale(functionName, numReplicas)
-		if error == nil {
-			if prevParams.Realtime < request.Realtime {
-				// Only wait for scale up
-				retries := numReplicas * 2
-				if retries < 10 {
-					retries = 10
-				}
-				canScale = rm.WaitForAvailReplicas(functionName, numReplicas, retries, 1000)
-			} else {
-				// For scale down, just releasing unused replicas, no need to wait
-				canScale = true
-			}
+	previous, known := lookupApplied(functionName)
+	if !known {
+		if previous, err = compensator.fetchApplied(functionName, prevReservation, reserved); err != nil {
+			log.Printf("Unable to read the deployed spec of %s: %s", functionName, err)
+			statusCode := http.StatusBadGateway
+			err = fmt.Errorf("Unable to read the deployed spec of %s, the update could not be rolled back: %s", functionName, err)
+			w.WriteHeader(statusCode)
+			w.Write([]byte(err.Error()))
+			return statusCode, err
 		}
+	}
 
-		// Check if we can scale successfully
-		if !canScale {
-			// Set back the real time parameter
-			request.Realtime = prevParams.Realtime
-			request.Resources.CPU = fmt.Sprintf("%vm", prevParams.CPU)
-			request.Resources.Memory = fmt.Sprint(prevParams.Memory)
-			request.Timeout = prevParams.Duration
-			totalCPU = int64(request.Realtime * float64(prevParams.CPU) * float64(request.Timeout) / 1000)
-			totalMemory = int64(request.Realtime * float64(prevParams.Memory) * float64(request.Timeout) / 1000)
-			rm.SetSandboxResources(&request, totalCPU, totalMemory)
-			error = rm.PackageRequest(request, r)
-			if error != nil {
-				log.Printf("Unable set back real-time params: %s", error.Error())
-			}
-			// If we fail to scale the function, then rollback the deployment
-			_, error := rm.UpdateImage(r, proxyClient, baseURL, requestURL, timeout, writeRequestURI)
-			if error != nil {
-				log.Printf("Unable to rollback function %s update", functionName)
-			}
-			error = rm.Scale(functionName, prevParams.Replicas)
-			if error != nil {
-				log.Printf("Unable to rollback function %s scale", functionName)
+	// Every step journals how to undo it, so that the function ends up with
+	// either the new spec or the previous one
+	saga := NewSaga(functionName, sagaUpdate, compensator)
+	var res *http.Response
+	err = saga.Step("update-image", Compensation{Action: compensateRestore, Spec: &previous}, func() error {
+		var err error
+		res, err = rm.UpdateImage(r, proxyClient, baseURL, requestURL, timeout, writeRequestURI)
+		return providerFailure(res, err)
+	})
+	if err != nil {
+		if abortErr := saga.Abort(); abortErr != nil {
+			log.Printf("Unable to roll back update of %s: %s", functionName, abortErr)
+		}
+		return writeUpstreamFailure(w, res, err)
+	}
+	if prevParams.Realtime > 0 || specs.PeakRealtime(request) > 0 {
+		err = saga.Step("scale", Compensation{Action: compensateScale, Replicas: prevParams.Replicas}, func() error {
+			// Only wait for scale up, scaling down just releases unused replicas
+			if numReplicas > prevParams.Replicas || prevParams.Realtime < request.Realtime {
+				return rm.ScaleAndWait(functionName, numReplicas)
 			}
-			canScale = rm.WaitForAvailReplicas(functionName, numReplicas, numReplicas*2, 1)
-			if !canScale {
-				log.Printf("Unable to scale back after update %s", functionName)
+			return rm.Scale(functionName, numReplicas)
+		})
+		if err != nil {
+			log.Printf("Unable to scale %s: %s", functionName, err)
+			statusCode := http.StatusInternalServerError
+			err = errors.New("Insuffcient resources. Cancel update the function!\n")
+			if abortErr := saga.Abort(); abortErr != nil {
+				err = fmt.Errorf("Insuffcient resources. Cancel update the function: %s\n", abortErr)
 			}
-			statusCode = http.StatusInternalServerError
-			error = errors.New("Insuffcient resources. Cancel update the function!\n")
+			w.WriteHeader(statusCode)
+			w.Write([]byte(err.Error()))
+			return statusCode, err
 		}
 	}
-	w.WriteHeader(statusCode)
-	if error != nil {
-		w.Write([]byte(error.Error()))
-	} else {
-		// Update function handler
-		log.Println("Update function handler")
-		SetFunctionHandler(request)
+	saga.Commit()
+	if specs.PeakRealtime(request) > 0 && reservation.Warm > 0 {
+		warmUp(proxyClient, baseURL, functionName, numReplicas, timeout)
 	}
-	return statusCode, error
 
+	statusCode := http.StatusAccepted
+	w.WriteHeader(statusCode)
+	updated = true
+	rememberSpec(spec)
+	rememberApplied(applied)
+	grantLease(request, time.Now(), false)
+	// Update function handler
+	log.Println("Update function handler")
+	request.Realtime = active
+	SetFunctionHandler(request)
+	return statusCode, nil
 }
 
 func (ac ReserveAdmissionControl) Unregister(
@@ -248,19 +385,34 @@ func (ac ReserveAdmissionControl) Unregister(
 	// Remove throught the resource manager interface
 	rm := ResourceManager{}
 
+	request, err := rm.ParseDeleteRequest(r)
+	if err != nil {
+		log.Printf("Reading parameters error: %s", err)
+		statusCode := http.StatusBadRequest
+		w.WriteHeader(statusCode)
+		err = errors.New("Function parameters are invalid")
+		return statusCode, err
+	}
+	if !claimPending(request.FunctionName) {
+		return writeChangeInFlight(w, request.FunctionName)
+	}
+	defer clearPending(request.FunctionName)
+
 	// Remove function image
 	res, error := rm.RemoveImage(r, proxyClient, baseURL, requestURL, timeout, writeRequestURI)
-	if error != nil {
-		if res.Body != nil {
-			io.CopyBuffer(w, res.Body, nil)
-		}
-		copyHeaders(w.Header(), &res.Header)
-		w.WriteHeader(res.StatusCode)
+	if error != nil || res.StatusCode < 200 || res.StatusCode > 299 {
+		return writeUpstreamFailure(w, res, error)
 	}
-	request, err := rm.ParseRequest(r)
-	if err == nil {
-		log.Println("Remove function handler")
-		RemoveFunctionHandler(request.Service)
+
+	log.Println("Remove function handler")
+	RemoveFunctionHandler(request.FunctionName)
+	forgetSpec(request.FunctionName)
+	forgetApplied(request.FunctionName)
+	revokeLease(request.FunctionName)
+	GetDemandTracker().Forget(request.FunctionName)
+	if released, existed := GetLedger().Release(request.FunctionName); existed {
+		log.Printf("Release reservation of %s: CPU: %d Memory %d", request.FunctionName, released.CPU, released.Memory)
 	}
+	w.WriteHeader(http.StatusAccepted)
 	return http.StatusAccepted, nil
 }
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
 	"time"
@@ -94,16 +95,24 @@ func (rm ResourceManager) ParseRequest(req *http.Request) (requests.CreateFuncti
 	return request, err
 }
 
+// ParseDeleteRequest returns the DeleteFunctionRequest held by the http request
+func (rm ResourceManager) ParseDeleteRequest(req *http.Request) (requests.DeleteFunctionRequest, error) {
+	request := requests.DeleteFunctionRequest{}
+	body, err := ioutil.ReadAll(req.Body)
+	rdr := ioutil.NopCloser(bytes.NewBuffer(body))
+	if err == nil {
+		err = json.Unmarshal(body, &request)
+	}
+	req.Body = rdr
+	return request, err
+}
+
 // PackageRequest reformats the request into the form understandable by the underlying system and write to the http request
 func (rm ResourceManager) PackageRequest(cfr requests.CreateFunctionRequest, req *http.Request) error {
-	if cfr.Labels == nil {
-		cfr.Labels = &map[string]string{}
+	// Keep the realtime parameters for later retrievals
+	if err := writeSpec(&cfr); err != nil {
+		return err
 	}
-	// Update label for later retrivals
-	(*cfr.Labels)["realtime"] = fmt.Sprint(cfr.Realtime)
-	(*cfr.Labels)["cpu"] = fmt.Sprint(cfr.Resources.CPU)
-	(*cfr.Labels)["memory"] = fmt.Sprint(cfr.Resources.Memory)
-	(*cfr.Labels)["duration"] = fmt.Sprint(cfr.Timeout)
 
 	// Update timeout labels
 	if cfr.Timeout > 0 {
@@ -244,6 +253,70 @@ func (rm ResourceManager) GetResourceQuantity(req requests.FunctionResources) (i
 // 	return nil
 // }
 
+// SetSandboxLimits caps the sandbox at the given resources, unless the
+// caller set limits already
+func (rm ResourceManager) SetSandboxLimits(request *requests.CreateFunctionRequest, cpu int64, memory int64) {
+	if request.Limits == nil {
+		request.Limits = &requests.FunctionResources{}
+		request.Limits.CPU = fmt.Sprintf("%vm", cpu)
+		request.Limits.Memory = fmt.Sprint(memory)
+	}
+}
+
+// ReplicasFor returns how many replicas hold the reservation. By Little's
+// law the invocations in flight average the rate times their duration, and
+// each replica serves up to ContainerConcurrency of them. A single replica
+// serves them all when no concurrency is configured.
+func (rm ResourceManager) ReplicasFor(res Reservation) uint64 {
+	concurrency := scaling.GetScalerInstance().Config.ContainerConcurrency
+	inFlight := res.Task.Rate * float64(res.Task.Budget) / 1000
+	if concurrency == 0 || inFlight <= 0 {
+		return 1
+	}
+	return uint64(math.Max(1, math.Ceil(inFlight/float64(concurrency))))
+}
+
+// SizeReplicas splits the reservation, and the limits sized for the
+// declared reservation, evenly among the replicas of the function, spares
+// of the warm pool included, and returns the number of replicas
+func (rm ResourceManager) SizeReplicas(request *requests.CreateFunctionRequest, reservation Reservation, declared Reservation) uint64 {
+	replicas := rm.ReplicasFor(reservation) + reservation.Warm
+	share := func(total int64) int64 {
+		return (total + int64(replicas) - 1) / int64(replicas)
+	}
+	rm.SetSandboxLimits(request, share(declared.CPU), share(declared.Memory))
+	rm.SetSandboxResources(request, share(reservation.CPU), share(reservation.Memory))
+	request.Replicas = replicas
+	return replicas
+}
+
+// RightSize sets the duration and memory the reservation of the function is
+// sized for from what its invocations were observed to use. Right-sizing
+// keeps them between the floor and the declared size.
+func (rm ResourceManager) RightSize(request *requests.CreateFunctionRequest, memory int64) {
+	tracker := GetDemandTracker()
+	request.Demand = tracker.Demand(request.Service, request.Timeout)
+	request.DemandMemory = 0
+	if tracker.RightSize {
+		recommendation := tracker.Recommend(request.Service)
+		if recommendation.DurationSamples >= minDemandSamples {
+			request.Demand = 0
+			if duration := bounded(int64(recommendation.Timeout), int64(request.Timeout), tracker.Floor); duration < int64(request.Timeout) {
+				request.Demand = uint64(duration)
+			}
+		}
+		if recommendation.MemorySamples >= minDemandSamples && memory > 0 {
+			if size := bounded(recommendation.Memory, memory, tracker.Floor); size < memory {
+				request.DemandMemory = size
+			}
+		}
+	}
+	if request.Demand > 0 || request.DemandMemory > 0 {
+		log.Printf("Size %s for invocations of %dms and %d bytes, declared %dms and %d bytes",
+			request.Service, request.Demand, request.DemandMemory, request.Timeout, memory)
+	}
+}
+
 // // Reserve resource for realtime deployment
 // func (rm ResourceManager) ReserveResource(req *http.Request, cpu int64, memory int64) error {
 // 	body, _ := ioutil.ReadAll(req.Body)
@@ -348,6 +421,26 @@ func (rm ResourceManager) SetSandboxResources(request *requests.CreateFunctionRe
 // 	return nil
 // }
 
+// ScaleAndWait scales the function and waits until all its replicas are
+// available. When they are not, best-effort functions are degraded to make
+// room for them.
+func (rm ResourceManager) ScaleAndWait(functionName string, replicas uint64) error {
+	if err := rm.Scale(functionName, replicas); err != nil {
+		return err
+	}
+	retries := replicas * 2
+	if retries < 10 {
+		retries = 10
+	}
+	ready := func() bool {
+		return rm.WaitForAvailReplicas(functionName, replicas, retries, 1000)
+	}
+	if !ready() && !GetDegrader().MakeRoom(functionName, ready) {
+		return fmt.Errorf("%d replicas of %s are not available", replicas, functionName)
+	}
+	return nil
+}
+
 /* Scale a function by either increasing or decreasing its replicas */
 func (rm ResourceManager) Scale(functionName string, realtimeReplicas uint64) error {
 	f := scaling.GetScalerInstance()
@@ -478,8 +571,11 @@ func forwardRequest(
 
 	res, resErr := proxyClient.Do(upstreamReq.WithContext(ctx))
 
-	if res.Body != nil {
+	if res != nil && res.Body != nil {
+		// Keep the body readable by callers once the connection is closed
 		defer res.Body.Close()
+		resBody, _ := ioutil.ReadAll(res.Body)
+		res.Body = ioutil.NopCloser(bytes.NewBuffer(resBody))
 	}
 
 	return res, resErr
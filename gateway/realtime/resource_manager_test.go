@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"testing"
 
@@ -491,6 +492,35 @@ func Test_Scale(t *testing.T) {
 	}
 }
 
+func Test_SizeReplicas(t *testing.T) {
+	config := &scaling.GetScalerInstance().Config
+	defer func(concurrency uint) { config.ContainerConcurrency = concurrency }(config.ContainerConcurrency)
+	rm := ResourceManager{}
+	reservation := Reservation{CPU: 2500, Memory: 1000, Task: Task{Rate: 50, Budget: 200}}
+
+	for _, c := range []struct {
+		concurrency uint
+		replicas    uint64
+	}{
+		{0, 1},
+		{100, 1},
+		{4, 3},
+		{1, 10},
+	} {
+		config.ContainerConcurrency = c.concurrency
+		request := requests.CreateFunctionRequest{Service: "sized"}
+		if got := rm.SizeReplicas(&request, reservation, reservation); got != c.replicas || request.Replicas != c.replicas {
+			t.Errorf("SizeReplicas - concurrency %d replicas want: %d, got %d", c.concurrency, c.replicas, got)
+			t.Fail()
+		}
+		share := (reservation.CPU + int64(c.replicas) - 1) / int64(c.replicas)
+		if want := fmt.Sprintf("%vm", share); request.Requests.CPU != want || request.Limits.CPU != want {
+			t.Errorf("SizeReplicas - concurrency %d cpu want: %s, got %s/%s", c.concurrency, want, request.Requests.CPU, request.Limits.CPU)
+			t.Fail()
+		}
+	}
+}
+
 /*
 type SuccessHttpClient struct {
 	http.Client
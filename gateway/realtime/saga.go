@@ -0,0 +1,530 @@
+package realtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ngduchai/faas/gateway/handlers"
+	"github.com/ngduchai/faas/gateway/requests"
+	"github.com/ngduchai/faas/gateway/types"
+)
+
+// Operations run as sagas
+const (
+	sagaDeploy = "deploy"
+	sagaUpdate = "update"
+)
+
+// Compensating actions recorded by the steps of a saga
+const (
+	// compensateRemove deletes the function the saga deployed
+	compensateRemove = "remove"
+	// compensateRestore puts back the spec the function had before the saga
+	compensateRestore = "restore"
+	// compensateScale scales the function back to its previous replicas
+	compensateScale = "scale"
+)
+
+const (
+	// compensationAttempts is how many times a compensation is tried before
+	// the saga is left to the recovery
+	compensationAttempts = 3
+	// compensationInterval is the pause between two attempts
+	compensationInterval = 200 * time.Millisecond
+)
+
+// Compensation undoes a step of a saga. It is recorded as data rather than
+// as a closure so that it can still be replayed from the journal once the
+// gateway restarts.
+type Compensation struct {
+	Action   string                          `json:"action"`
+	Spec     *requests.CreateFunctionRequest `json:"spec,omitempty"`
+	Replicas uint64                          `json:"replicas,omitempty"`
+}
+
+// SagaStep is a step of a saga with the compensation undoing it
+type SagaStep struct {
+	Name         string       `json:"name"`
+	Compensation Compensation `json:"compensation"`
+}
+
+// SagaRecord is the journal entry of a saga, Steps holds the steps which
+// may have taken effect, most recent last
+type SagaRecord struct {
+	ID        string     `json:"id"`
+	Function  string     `json:"function"`
+	Operation string     `json:"operation"`
+	Started   time.Time  `json:"started"`
+	Steps     []SagaStep `json:"steps"`
+}
+
+// Journal keeps the sagas which have not completed, so that the ones
+// interrupted by a failure or a crash of the gateway are compensated later
+type Journal interface {
+	Save(record SagaRecord) error
+	Delete(id string) error
+	Pending() ([]SagaRecord, error)
+}
+
+// MemoryJournal keeps sagas for the life of the gateway only
+type MemoryJournal struct {
+	records sync.Map
+}
+
+func (j *MemoryJournal) Save(record SagaRecord) error {
+	record.Steps = append([]SagaStep{}, record.Steps...)
+	j.records.Store(record.ID, record)
+	return nil
+}
+
+func (j *MemoryJournal) Delete(id string) error {
+	j.records.Delete(id)
+	return nil
+}
+
+func (j *MemoryJournal) Pending() ([]SagaRecord, error) {
+	records := []SagaRecord{}
+	j.records.Range(func(key interface{}, value interface{}) bool {
+		records = append(records, value.(SagaRecord))
+		return true
+	})
+	sortRecords(records)
+	return records, nil
+}
+
+// FileJournal keeps every saga in a JSON file of Dir, so that the sagas
+// interrupted by a crash are compensated once the gateway restarts
+type FileJournal struct {
+	Dir string
+}
+
+// NewFileJournal creates a journal in the directory, creating it if needed
+func NewFileJournal(dir string) (*FileJournal, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileJournal{Dir: dir}, nil
+}
+
+func (j *FileJournal) path(id string) string {
+	return filepath.Join(j.Dir, id+".json")
+}
+
+// Save replaces the file of the saga atomically, a crash leaves either the
+// previous record or the new one
+func (j *FileJournal) Save(record SagaRecord) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return replaceFile(j.path(record.ID), body)
+}
+
+// replaceFile writes the file through a temporary one renamed over it, so
+// that a crash leaves either the previous content or the new one
+func replaceFile(path string, body []byte) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(body)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+func (j *FileJournal) Delete(id string) error {
+	if err := os.Remove(j.path(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (j *FileJournal) Pending() ([]SagaRecord, error) {
+	files, err := ioutil.ReadDir(j.Dir)
+	if err != nil {
+		return nil, err
+	}
+	records := []SagaRecord{}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		body, err := ioutil.ReadFile(filepath.Join(j.Dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		record := SagaRecord{}
+		if err := json.Unmarshal(body, &record); err != nil {
+			log.Printf("Skip unreadable saga %s: %s", file.Name(), err)
+			continue
+		}
+		records = append(records, record)
+	}
+	sortRecords(records)
+	return records, nil
+}
+
+func sortRecords(records []SagaRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Started.Before(records[j].Started)
+	})
+}
+
+var journal Journal
+var journalOnce sync.Once
+
+// SetupJournal sets the journal the sagas of the gateway are kept in
+func SetupJournal(j Journal) {
+	journal = j
+}
+
+// GetJournal returns the journal shared by the gateway, sagas are kept in
+// memory until one is set up
+func GetJournal() Journal {
+	journalOnce.Do(func() {
+		if journal == nil {
+			SetupJournal(&MemoryJournal{})
+		}
+	})
+	return journal
+}
+
+// ProviderError is a definite answer of the provider refusing a step. The
+// step did not take effect, so it needs no compensation.
+type ProviderError struct {
+	StatusCode int
+}
+
+func (e *ProviderError) Error() string {
+	return fmt.Sprintf("provider returned status code %d", e.StatusCode)
+}
+
+// providerFailure tells apart the responses refused by the provider from
+// the requests whose outcome is unknown
+func providerFailure(res *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return &ProviderError{StatusCode: res.StatusCode}
+	}
+	return nil
+}
+
+// Compensator applies compensations through the provider. Every request is
+// built afresh from the journaled spec.
+type Compensator struct {
+	Client     *http.Client
+	BaseURL    string
+	RequestURL string
+	Timeout    time.Duration
+}
+
+func (c Compensator) send(method string, requestURL string, body []byte) (*http.Response, error) {
+	r, _ := http.NewRequest(method, requestURL, bytes.NewReader(body))
+	return processRequest(r, c.Client, c.BaseURL, requestURL, c.Timeout, false)
+}
+
+func (c Compensator) functionsURL() string {
+	if len(c.RequestURL) > 0 {
+		return c.RequestURL
+	}
+	return "/system/functions"
+}
+
+// apply undoes a step, it may be applied again without harm
+func (c Compensator) apply(functionName string, compensation Compensation) error {
+	switch compensation.Action {
+	case compensateRemove:
+		body, err := json.Marshal(requests.DeleteFunctionRequest{FunctionName: functionName})
+		if err != nil {
+			return err
+		}
+		res, err := c.send(http.MethodDelete, c.functionsURL(), body)
+		if err == nil && res.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return providerFailure(res, err)
+	case compensateRestore:
+		if compensation.Spec == nil {
+			return fmt.Errorf("no spec to restore %s to", functionName)
+		}
+		body, err := json.Marshal(compensation.Spec)
+		if err != nil {
+			return err
+		}
+		return providerFailure(c.send(http.MethodPut, c.functionsURL(), body))
+	case compensateScale:
+		return ResourceManager{}.Scale(functionName, compensation.Replicas)
+	}
+	return fmt.Errorf("unknown compensation %s", compensation.Action)
+}
+
+// reportedFunction is what the provider reports of a function, providers
+// which know the environment, secrets and constraints report them too
+type reportedFunction struct {
+	requests.Function
+	EnvVars                map[string]string `json:"envVars"`
+	Constraints            []string          `json:"constraints"`
+	Secrets                []string          `json:"secrets"`
+	ReadOnlyRootFilesystem bool              `json:"readOnlyRootFilesystem"`
+}
+
+// fetchDeployed rebuilds the spec of a function the gateway did not deploy
+// itself, as the caller sent it, from what the provider reports and the
+// reservation spec in its annotations
+func (c Compensator) fetchDeployed(functionName string) (requests.CreateFunctionRequest, error) {
+	res, err := c.send(http.MethodGet, "/system/function/"+functionName, nil)
+	if err = providerFailure(res, err); err != nil {
+		return requests.CreateFunctionRequest{}, err
+	}
+	function := reportedFunction{}
+	body, err := ioutil.ReadAll(res.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &function)
+	}
+	if err != nil {
+		return requests.CreateFunctionRequest{}, err
+	}
+	if function.Name != functionName || len(function.Image) == 0 {
+		return requests.CreateFunctionRequest{}, fmt.Errorf("provider did not report the spec of %s", functionName)
+	}
+
+	spec, err := requestFromFunction(function.Function)
+	if err != nil {
+		return spec, err
+	}
+	return deployedFrom(function, spec), nil
+}
+
+// deployedFrom completes the realtime parameters read from a function with
+// the rest of its spec as the provider reported it
+func deployedFrom(function reportedFunction, spec requests.CreateFunctionRequest) requests.CreateFunctionRequest {
+	spec.Image = function.Image
+	spec.EnvProcess = function.EnvProcess
+	spec.EnvVars = function.EnvVars
+	spec.Constraints = function.Constraints
+	spec.Secrets = function.Secrets
+	spec.ReadOnlyRootFilesystem = function.ReadOnlyRootFilesystem
+	spec.Labels = function.Labels
+	spec.Annotations = function.Annotations
+	return cloneSpec(spec)
+}
+
+// fetchApplied rebuilds the spec of a function the gateway did not deploy
+// itself, with the sandbox sized for the reservation the function held
+func (c Compensator) fetchApplied(functionName string, held Reservation, reserved bool) (requests.CreateFunctionRequest, error) {
+	spec, err := c.fetchDeployed(functionName)
+	if err != nil {
+		return spec, err
+	}
+	if reserved {
+		ResourceManager{}.SizeReplicas(&spec, held, held)
+	}
+	return spec, nil
+}
+
+// appliedSpecs holds the last spec the provider accepted for every function,
+// as packaged by the gateway, which is what a failed update is rolled back to
+var appliedSpecs sync.Map
+
+func rememberApplied(spec requests.CreateFunctionRequest) {
+	appliedSpecs.Store(spec.Service, cloneSpec(spec))
+}
+
+func forgetApplied(functionName string) {
+	appliedSpecs.Delete(functionName)
+}
+
+func lookupApplied(functionName string) (requests.CreateFunctionRequest, bool) {
+	entry, ok := appliedSpecs.Load(functionName)
+	if !ok {
+		return requests.CreateFunctionRequest{}, false
+	}
+	return cloneSpec(entry.(requests.CreateFunctionRequest)), true
+}
+
+// CompensationError reports the steps of a saga left in the journal after
+// their compensation failed
+type CompensationError struct {
+	Function string
+	Step     string
+	Err      error
+}
+
+func (e *CompensationError) Error() string {
+	return fmt.Sprintf("unable to undo %s of %s, it is retried from the journal: %s", e.Step, e.Function, e.Err)
+}
+
+// Saga runs the steps of a change to a function. Each step journals its
+// compensation before it runs, so that when a later step fails, or the
+// gateway crashes, the steps taken are undone in reverse and the function
+// is left with its previous spec.
+type Saga struct {
+	Journal     Journal
+	Compensator Compensator
+	record      SagaRecord
+}
+
+// NewSaga starts a saga on the function, kept in the journal of the gateway
+func NewSaga(functionName string, operation string, compensator Compensator) *Saga {
+	now := time.Now()
+	return &Saga{
+		Journal:     GetJournal(),
+		Compensator: compensator,
+		record: SagaRecord{
+			ID:        fmt.Sprintf("%s-%d", functionName, now.UnixNano()),
+			Function:  functionName,
+			Operation: operation,
+			Started:   now,
+		},
+	}
+}
+
+// Step journals the compensation and then runs the action. A step refused
+// by the provider did not take effect, so its compensation is dropped. Any
+// other failure leaves the step in doubt, and it is compensated on Abort.
+func (s *Saga) Step(name string, compensation Compensation, action func() error) error {
+	s.record.Steps = append(s.record.Steps, SagaStep{Name: name, Compensation: compensation})
+	if err := s.Journal.Save(s.record); err != nil {
+		s.record.Steps = s.record.Steps[:len(s.record.Steps)-1]
+		return fmt.Errorf("unable to journal %s of %s: %s", name, s.record.Function, err)
+	}
+
+	err := action()
+	if _, refused := err.(*ProviderError); refused {
+		s.record.Steps = s.record.Steps[:len(s.record.Steps)-1]
+		if err := s.Journal.Save(s.record); err != nil {
+			log.Printf("Unable to journal %s of %s: %s", name, s.record.Function, err)
+		}
+	}
+	return err
+}
+
+// Commit ends the saga, its steps are no longer compensated
+func (s *Saga) Commit() {
+	if err := s.Journal.Delete(s.record.ID); err != nil {
+		log.Printf("Unable to close saga %s: %s", s.record.ID, err)
+	}
+}
+
+// Abort undoes the steps taken, most recent first
+func (s *Saga) Abort() error {
+	log.Printf("Roll back %s of %s", s.record.Operation, s.record.Function)
+	return compensate(s.Journal, s.Compensator, &s.record)
+}
+
+// compensate undoes the steps of the record in reverse. A compensation is
+// tried a few times, the steps left when it still fails stay journaled.
+func compensate(journal Journal, compensator Compensator, record *SagaRecord) error {
+	for i := len(record.Steps) - 1; i >= 0; i-- {
+		step := record.Steps[i]
+		err := backoff(func(attempt int) error {
+			return compensator.apply(record.Function, step.Compensation)
+		}, compensationAttempts, compensationInterval)
+		if err != nil {
+			log.Printf("Unable to undo %s of %s: %s", step.Name, record.Function, err)
+			return &CompensationError{Function: record.Function, Step: step.Name, Err: err}
+		}
+		record.Steps = record.Steps[:i]
+		if err := journal.Save(*record); err != nil {
+			log.Printf("Unable to journal saga %s: %s", record.ID, err)
+		}
+	}
+	return journal.Delete(record.ID)
+}
+
+// SagaRecovery compensates the sagas left in the journal by a crash of the
+// gateway or by a compensation which failed
+type SagaRecovery struct {
+	Journal     Journal
+	Compensator Compensator
+}
+
+// NewSagaRecovery creates a recovery compensating sagas through the proxy
+func NewSagaRecovery(j Journal, proxy *types.HTTPClientReverseProxy, baseURLResolver handlers.BaseURLResolver) *SagaRecovery {
+	requestURL := "/system/functions"
+	r, _ := http.NewRequest(http.MethodPut, requestURL, nil)
+	return &SagaRecovery{
+		Journal: j,
+		Compensator: Compensator{
+			Client:     proxy.Client,
+			BaseURL:    baseURLResolver.Resolve(r),
+			RequestURL: requestURL,
+			Timeout:    proxy.Timeout,
+		},
+	}
+}
+
+// Start recovers immediately and then at every interval
+func (sr *SagaRecovery) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			sr.Recover()
+			<-ticker.C
+		}
+	}()
+}
+
+// journaled tells whether the saga is still in the journal
+func (sr *SagaRecovery) journaled(id string) bool {
+	records, err := sr.Journal.Pending()
+	if err != nil {
+		return false
+	}
+	for _, record := range records {
+		if record.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Recover compensates the sagas of the journal, leaving alone those of
+// functions with a change in flight
+func (sr *SagaRecovery) Recover() {
+	records, err := sr.Journal.Pending()
+	if err != nil {
+		log.Printf("Unable to read the saga journal: %s", err)
+		return
+	}
+	for _, record := range records {
+		if !claimPending(record.Function) {
+			continue
+		}
+		// The change may have completed, and its record gone, before the
+		// claim was taken
+		if !sr.journaled(record.ID) {
+			clearPending(record.Function)
+			continue
+		}
+		log.Printf("Roll back interrupted %s of %s", record.Operation, record.Function)
+		if err := compensate(sr.Journal, sr.Compensator, &record); err != nil {
+			log.Printf("Unable to roll back %s of %s: %s", record.Operation, record.Function, err)
+		}
+		clearPending(record.Function)
+	}
+}
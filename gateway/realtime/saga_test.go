@@ -0,0 +1,268 @@
+package realtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ngduchai/faas/gateway/requests"
+	"github.com/ngduchai/faas/gateway/scaling"
+)
+
+// sagaProvider records the requests it receives, answering PUT requests
+// for the images in failing with the given status
+type sagaProvider struct {
+	server   *httptest.Server
+	received []string
+	failing  map[string]int
+	sync     sync.Mutex
+}
+
+func newSagaProvider(failing map[string]int) *sagaProvider {
+	provider := &sagaProvider{failing: failing}
+	provider.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		spec := requests.CreateFunctionRequest{}
+		json.Unmarshal(body, &spec)
+
+		provider.sync.Lock()
+		provider.received = append(provider.received, r.Method+" "+spec.Image)
+		provider.sync.Unlock()
+
+		if statusCode, ok := provider.failing[spec.Image]; ok && r.Method == http.MethodPut {
+			if statusCode == 0 {
+				// Answer too late for the caller to know the outcome
+				time.Sleep(200 * time.Millisecond)
+				statusCode = http.StatusAccepted
+			}
+			w.WriteHeader(statusCode)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	return provider
+}
+
+func (p *sagaProvider) requests() []string {
+	p.sync.Lock()
+	defer p.sync.Unlock()
+	return append([]string{}, p.received...)
+}
+
+func (p *sagaProvider) compensator(timeout time.Duration) Compensator {
+	return Compensator{Client: http.DefaultClient, BaseURL: p.server.URL, Timeout: timeout}
+}
+
+func Test_SagaAbortUndoesStepsInReverse(t *testing.T) {
+	provider := newSagaProvider(nil)
+	defer provider.server.Close()
+	journal := &MemoryJournal{}
+
+	saga := NewSaga("saga-abort", sagaUpdate, provider.compensator(time.Second))
+	saga.Journal = journal
+	previous := requests.CreateFunctionRequest{Service: "saga-abort", Image: "functions/saga:v1"}
+	saga.Step("update-image", Compensation{Action: compensateRestore, Spec: &previous}, func() error {
+		return nil
+	})
+	if records, _ := journal.Pending(); len(records) != 1 || len(records[0].Steps) != 1 {
+		t.Errorf("Step - journaled steps want: %d, got %+v", 1, records)
+		t.Fail()
+	}
+	err := saga.Step("create-image", Compensation{Action: compensateRemove}, func() error {
+		return errors.New("connection reset")
+	})
+	if err == nil {
+		t.Errorf("Step - error want: %s, got nil", "connection reset")
+		t.Fail()
+	}
+
+	if err := saga.Abort(); err != nil {
+		t.Errorf("Abort - error want: nil, got %s", err)
+		t.Fail()
+	}
+	want := []string{"DELETE ", "PUT functions/saga:v1"}
+	if got := provider.requests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Abort - compensations want: %v, got %v", want, got)
+		t.Fail()
+	}
+	if records, _ := journal.Pending(); len(records) != 0 {
+		t.Errorf("Abort - journaled sagas want: %d, got %d", 0, len(records))
+		t.Fail()
+	}
+}
+
+func Test_SagaRefusedStepIsNotCompensated(t *testing.T) {
+	provider := newSagaProvider(nil)
+	defer provider.server.Close()
+
+	saga := NewSaga("saga-refused", sagaDeploy, provider.compensator(time.Second))
+	saga.Journal = &MemoryJournal{}
+	err := saga.Step("create-image", Compensation{Action: compensateRemove}, func() error {
+		return &ProviderError{StatusCode: http.StatusConflict}
+	})
+	if _, refused := err.(*ProviderError); !refused {
+		t.Errorf("Step - error want: %T, got %v", &ProviderError{}, err)
+		t.Fail()
+	}
+	saga.Abort()
+	if got := provider.requests(); len(got) != 0 {
+		t.Errorf("Abort - compensations want: none, got %v", got)
+		t.Fail()
+	}
+}
+
+func Test_SagaFailedCompensationStaysJournaled(t *testing.T) {
+	provider := newSagaProvider(map[string]int{"functions/saga:v1": http.StatusInternalServerError})
+	defer provider.server.Close()
+	journal := &MemoryJournal{}
+
+	saga := NewSaga("saga-stuck", sagaUpdate, provider.compensator(time.Second))
+	saga.Journal = journal
+	previous := requests.CreateFunctionRequest{Service: "saga-stuck", Image: "functions/saga:v1"}
+	saga.Step("update-image", Compensation{Action: compensateRestore, Spec: &previous}, func() error {
+		return errors.New("timeout")
+	})
+
+	err := saga.Abort()
+	if _, ok := err.(*CompensationError); !ok {
+		t.Errorf("Abort - error want: %T, got %v", &CompensationError{}, err)
+		t.Fail()
+	}
+	if got := len(provider.requests()); got != compensationAttempts {
+		t.Errorf("Abort - attempts want: %d, got %d", compensationAttempts, got)
+		t.Fail()
+	}
+	if records, _ := journal.Pending(); len(records) != 1 || len(records[0].Steps) != 1 {
+		t.Errorf("Abort - journaled steps want: %d, got %+v", 1, records)
+		t.Fail()
+	}
+}
+
+func Test_SagaRecoveryAfterRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "saga-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	provider := newSagaProvider(nil)
+	defer provider.server.Close()
+
+	journal, err := NewFileJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saga := NewSaga("saga-crash", sagaUpdate, provider.compensator(time.Second))
+	saga.Journal = journal
+	previous := requests.CreateFunctionRequest{Service: "saga-crash", Image: "functions/saga:v1"}
+	saga.Step("update-image", Compensation{Action: compensateRestore, Spec: &previous}, func() error {
+		return nil
+	})
+	// The gateway crashes before the saga is committed
+
+	restarted, _ := NewFileJournal(dir)
+	records, err := restarted.Pending()
+	if err != nil || len(records) != 1 || records[0].Function != "saga-crash" {
+		t.Errorf("Pending - sagas want: %s, got %+v %v", "saga-crash", records, err)
+		t.FailNow()
+	}
+
+	recovery := &SagaRecovery{Journal: restarted, Compensator: provider.compensator(time.Second)}
+	recovery.Recover()
+	want := []string{"PUT functions/saga:v1"}
+	if got := provider.requests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Recover - compensations want: %v, got %v", want, got)
+		t.Fail()
+	}
+	if records, _ := restarted.Pending(); len(records) != 0 {
+		t.Errorf("Recover - journaled sagas want: %d, got %d", 0, len(records))
+		t.Fail()
+	}
+}
+
+func Test_SagaRecoveryLeavesChangesInFlight(t *testing.T) {
+	provider := newSagaProvider(nil)
+	defer provider.server.Close()
+	journal := &MemoryJournal{}
+
+	saga := NewSaga("saga-in-flight", sagaUpdate, provider.compensator(time.Second))
+	saga.Journal = journal
+	previous := requests.CreateFunctionRequest{Service: "saga-in-flight", Image: "functions/saga:v1"}
+	saga.Step("update-image", Compensation{Action: compensateRestore, Spec: &previous}, func() error {
+		return nil
+	})
+
+	// The update holding the function is still running
+	claimPending("saga-in-flight")
+	recovery := &SagaRecovery{Journal: journal, Compensator: provider.compensator(time.Second)}
+	recovery.Recover()
+	if got := provider.requests(); len(got) != 0 {
+		t.Errorf("Recover - compensations want: none, got %v", got)
+		t.Fail()
+	}
+	if !isPending("saga-in-flight") {
+		t.Errorf("Recover - want: claim of the update kept, got cleared")
+		t.Fail()
+	}
+
+	// It completes, its record is gone once the claim is free
+	saga.Commit()
+	clearPending("saga-in-flight")
+	recovery.Recover()
+	if got := provider.requests(); len(got) != 0 {
+		t.Errorf("Recover after commit - compensations want: none, got %v", got)
+		t.Fail()
+	}
+}
+
+func Test_UpdateRolledBackWhenInDoubt(t *testing.T) {
+	functionName := "saga-update"
+	SetupLedger(1000, 0, PolicyNone)
+	defer SetupLedger(0, 0, PolicyEDF)
+	scaling.GetScalerInstance().Config.ServiceQuery = ReadyServiceQuery{
+		Response: scaling.ServiceQueryResponse{Replicas: 1, AvailableReplicas: 1, Realtime: 2, CPU: 1000, Duration: 100},
+	}
+	ac := ReserveAdmissionControl{}
+	admission := newAdmissionProvider()
+	defer admission.server.Close()
+	if code := deploy(ac, admission, http.MethodPost, admissionSpec(functionName, 2)); code != http.StatusAccepted {
+		t.Errorf("Register - status want: %d, got %d", http.StatusAccepted, code)
+		t.FailNow()
+	}
+	defer undeploy(ac, admission, functionName)
+	before, _ := GetLedger().Get(functionName)
+
+	provider := newSagaProvider(map[string]int{"functions/" + functionName + ":v2": 0})
+	defer provider.server.Close()
+	spec := admissionSpec(functionName, 4)
+	spec.Image = "functions/" + functionName + ":v2"
+	body, _ := json.Marshal(spec)
+	r := httptest.NewRequest(http.MethodPut, "/system/functions", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	ac.Update(w, r, http.DefaultClient, provider.server.URL, "/system/functions", 100*time.Millisecond, false)
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("Update - status want: %d, got %d", http.StatusBadGateway, w.Code)
+		t.Fail()
+	}
+	want := []string{"PUT functions/" + functionName + ":v2", "PUT functions/" + functionName + ":latest"}
+	if got := provider.requests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Update - provider requests want: %v, got %v", want, got)
+		t.Fail()
+	}
+	if after, _ := GetLedger().Get(functionName); !after.same(before) {
+		t.Errorf("Update - reservation want: %+v, got %+v", before, after)
+		t.Fail()
+	}
+	if applied, _ := lookupApplied(functionName); applied.Image != "functions/"+functionName+":latest" {
+		t.Errorf("Update - applied image want: %s, got %s", "functions/"+functionName+":latest", applied.Image)
+		t.Fail()
+	}
+}
@@ -0,0 +1,122 @@
+package realtime
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// PolicyEDF admits a task set as long as its utilization does not exceed
+	// the processors of the pool (earliest deadline first)
+	PolicyEDF = "edf"
+
+	// PolicyRM applies a utilization bound of rate monotonic scheduling: the
+	// Liu & Layland bound on a single processor, and the global bound of
+	// Bertogna, Cirinei & Lipari on several
+	PolicyRM = "rm"
+
+	// PolicyNone disables the schedulability test
+	PolicyNone = "none"
+
+	// DefaultPool is the reservation pool of functions which do not name one
+	DefaultPool = "default"
+)
+
+// Task describes the timing requirements of a realtime function
+type Task struct {
+	// Rate is the arrival rate in invocations per second
+	Rate float64
+	// Budget is the execution budget of an invocation in milliseconds
+	Budget uint64
+	// CPU used by an invocation in millicores
+	CPU int64
+	// Memory used by an invocation in bytes
+	Memory int64
+}
+
+// Utilization returns the number of processors the task keeps busy
+func (t Task) Utilization() float64 {
+	return t.Rate * float64(t.Budget) / 1000 * float64(t.CPU) / 1000
+}
+
+// SchedulabilityError reports a task set that violates the utilization bound of a pool
+type SchedulabilityError struct {
+	Function    string
+	Pool        string
+	Policy      string
+	Utilization float64
+	Bound       float64
+	// At is the time a window of a schedule runs short, zero if it does now
+	At time.Time
+}
+
+func (e *SchedulabilityError) Error() string {
+	message := fmt.Sprintf("Function %s makes pool %s unschedulable: utilization %.3f exceeds the %s bound %.3f",
+		e.Function, e.Pool, e.Utilization, boundName(e.Policy), e.Bound)
+	if !e.At.IsZero() {
+		message += " at " + e.At.Format(time.RFC3339)
+	}
+	return message
+}
+
+func boundName(policy string) string {
+	switch policy {
+	case PolicyRM:
+		return "rate-monotonic"
+	default:
+		return "EDF"
+	}
+}
+
+// ValidPolicy tells whether the schedulability policy is known
+func ValidPolicy(policy string) bool {
+	return policy == PolicyEDF || policy == PolicyRM || policy == PolicyNone
+}
+
+// UtilizationBound returns the maximum utilization of n tasks, none using
+// more than maxUtilization of a processor, that the policy can schedule on
+// the given number of processors.
+//
+// Rate monotonic uses the Liu & Layland bound n(2^(1/n)-1) on up to one
+// processor, scaled down to its share of a processor. On more processors it
+// uses the bound of global rate monotonic scheduling by Bertogna, Cirinei &
+// Lipari: m/2(1-u_max)+u_max.
+func UtilizationBound(policy string, n int, maxUtilization float64, processors float64) float64 {
+	switch policy {
+	case PolicyRM:
+		if n <= 0 {
+			return processors
+		}
+		if processors <= 1 {
+			return processors * float64(n) * (math.Pow(2, 1/float64(n)) - 1)
+		}
+		return processors/2*(1-maxUtilization) + maxUtilization
+	case PolicyEDF:
+		return processors
+	default:
+		return math.Inf(1)
+	}
+}
+
+// CheckSchedulability returns the total utilization of the tasks, the bound of
+// the policy and whether the tasks are schedulable. A task keeping more than
+// one processor busy is served by several replicas sharing its rate, it is
+// accounted as that many tasks.
+func CheckSchedulability(policy string, tasks []Task, processors float64) (float64, float64, bool) {
+	utilization := 0.0
+	maxUtilization := 0.0
+	n := 0
+	for _, task := range tasks {
+		if task.Rate <= 0 {
+			continue
+		}
+		u := task.Utilization()
+		replicas := math.Max(1, math.Ceil(u))
+		utilization += u
+		maxUtilization = math.Max(maxUtilization, u/replicas)
+		n += int(replicas)
+	}
+	bound := UtilizationBound(policy, n, maxUtilization, processors)
+	return utilization, bound, utilization <= bound+1e-9
+}
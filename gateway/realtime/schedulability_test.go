@@ -0,0 +1,121 @@
+package realtime
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func Test_TaskUtilization(t *testing.T) {
+	task := Task{Rate: 10, Budget: 200, CPU: 500}
+
+	if task.Utilization() != 1.0 {
+		t.Errorf("Utilization - want: %f, got %f", 1.0, task.Utilization())
+		t.Fail()
+	}
+}
+
+func Test_UtilizationBound(t *testing.T) {
+	if bound := UtilizationBound(PolicyEDF, 3, 0.5, 2); bound != 2 {
+		t.Errorf("UtilizationBound - edf want: %f, got %f", 2.0, bound)
+		t.Fail()
+	}
+
+	want := 3 * (math.Pow(2, 1.0/3) - 1)
+	if bound := UtilizationBound(PolicyRM, 3, 0.5, 1); math.Abs(bound-want) > 1e-9 {
+		t.Errorf("UtilizationBound - rm want: %f, got %f", want, bound)
+		t.Fail()
+	}
+
+	// Global rate monotonic on four processors: 4/2(1-0.5)+0.5
+	if bound := UtilizationBound(PolicyRM, 3, 0.5, 4); math.Abs(bound-1.5) > 1e-9 {
+		t.Errorf("UtilizationBound - global rm want: %f, got %f", 1.5, bound)
+		t.Fail()
+	}
+
+	if bound := UtilizationBound(PolicyNone, 3, 0.5, 1); !math.IsInf(bound, 1) {
+		t.Errorf("UtilizationBound - none want: %s, got %f", "+Inf", bound)
+		t.Fail()
+	}
+}
+
+func Test_CheckSchedulability(t *testing.T) {
+	tasks := []Task{
+		{Rate: 10, Budget: 100, CPU: 400},
+		{Rate: 5, Budget: 100, CPU: 800},
+	}
+
+	utilization, _, schedulable := CheckSchedulability(PolicyEDF, tasks, 1)
+	if !schedulable || math.Abs(utilization-0.8) > 1e-9 {
+		t.Errorf("CheckSchedulability - edf want: %v %f, got %v %f", true, 0.8, schedulable, utilization)
+		t.Fail()
+	}
+
+	// Two tasks under rate monotonic are bounded by 2(sqrt(2)-1) ~ 0.828
+	_, _, schedulable = CheckSchedulability(PolicyRM, tasks, 1)
+	if !schedulable {
+		t.Errorf("CheckSchedulability - rm want: %v, got %v", true, schedulable)
+		t.Fail()
+	}
+
+	tasks = append(tasks, Task{Rate: 1, Budget: 100, CPU: 500})
+	_, _, schedulable = CheckSchedulability(PolicyRM, tasks, 1)
+	if schedulable {
+		t.Errorf("CheckSchedulability - rm overloaded want: %v, got %v", false, schedulable)
+		t.Fail()
+	}
+}
+
+func Test_CheckSchedulabilityGlobalRateMonotonic(t *testing.T) {
+	// Four processors busy at 2.4 in total would pass a bound of four times
+	// the Liu & Layland bound of two tasks, but not the global one:
+	// 4/2(1-0.8)+0.8 = 1.2
+	tasks := []Task{
+		{Rate: 8, Budget: 100, CPU: 1000},
+		{Rate: 8, Budget: 100, CPU: 2000},
+	}
+	utilization, bound, schedulable := CheckSchedulability(PolicyRM, tasks, 4)
+	if schedulable || math.Abs(utilization-2.4) > 1e-9 || math.Abs(bound-1.2) > 1e-9 {
+		t.Errorf("CheckSchedulability - global rm want: %v %f %f, got %v %f %f", false, 2.4, 1.2, schedulable, utilization, bound)
+		t.Fail()
+	}
+
+	// A task busy with 1.2 processors counts as two replicas busy with 0.6:
+	// 4/2(1-0.6)+0.6 = 1.4
+	tasks = []Task{{Rate: 24, Budget: 100, CPU: 500}}
+	_, bound, schedulable = CheckSchedulability(PolicyRM, tasks, 4)
+	if !schedulable || math.Abs(bound-1.4) > 1e-9 {
+		t.Errorf("CheckSchedulability - split task want: %v %f, got %v %f", true, 1.4, schedulable, bound)
+		t.Fail()
+	}
+}
+
+func Test_LedgerRejectsUnschedulablePool(t *testing.T) {
+	ledger := NewCapacityLedger(1000, 0)
+	ledger.Policy = PolicyRM
+
+	first := Reservation{CPU: 500, Task: Task{Rate: 10, Budget: 100, CPU: 500}}
+	if _, _, err := ledger.Reserve("f1", first); err != nil {
+		t.Errorf("Reserve - f1 want: %s, got %s", "nil", err.Error())
+		t.Fail()
+	}
+
+	second := Reservation{CPU: 400, Task: Task{Rate: 10, Budget: 100, CPU: 400}}
+	_, _, err := ledger.Reserve("f2", second)
+	schedErr, ok := err.(*SchedulabilityError)
+	if !ok {
+		t.Errorf("Reserve - f2 want: %s, got %v", "SchedulabilityError", err)
+		t.FailNow()
+	}
+	if schedErr.Pool != DefaultPool || !strings.Contains(err.Error(), "rate-monotonic") {
+		t.Errorf("Reserve - f2 error should name the pool and bound, got %s", err.Error())
+		t.Fail()
+	}
+
+	// Functions in another pool are not accounted together
+	second.Pool = "batch"
+	if _, _, err := ledger.Reserve("f2", second); err != nil {
+		t.Errorf("Reserve - f2 in other pool want: %s, got %s", "nil", err.Error())
+		t.Fail()
+	}
+}
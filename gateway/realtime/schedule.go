@@ -0,0 +1,363 @@
+package realtime
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ngduchai/faas/gateway/requests"
+)
+
+const (
+	// scheduleHorizon is how far ahead overlapping windows are checked
+	// against the capacity
+	scheduleHorizon = 7 * 24 * time.Hour
+	// scheduleRecheck bounds the time the switcher sleeps, so that functions
+	// deployed meanwhile are picked up
+	scheduleRecheck = time.Minute
+)
+
+// Window is a parsed time window of a schedule
+type Window struct {
+	requests.RealtimeWindow
+	cron     *cronSchedule
+	duration time.Duration
+	start    time.Time
+	end      time.Time
+}
+
+func parseWindow(w requests.RealtimeWindow) (Window, error) {
+	window := Window{RealtimeWindow: w}
+	if w.Realtime < 0 {
+		return window, errors.New("the rate of a window cannot be negative")
+	}
+	if len(w.Cron) > 0 {
+		if len(w.Start) > 0 || len(w.End) > 0 {
+			return window, errors.New("a window has either a cron expression or a start and an end")
+		}
+		location := time.UTC
+		if len(w.TimeZone) > 0 {
+			var err error
+			if location, err = time.LoadLocation(w.TimeZone); err != nil {
+				return window, err
+			}
+		}
+		var err error
+		if window.cron, err = parseCron(w.Cron, location); err != nil {
+			return window, err
+		}
+		if window.duration, err = time.ParseDuration(w.Duration); err != nil || window.duration <= 0 {
+			return window, fmt.Errorf("window %q needs a positive duration", w.Cron)
+		}
+		return window, nil
+	}
+
+	var err error
+	if window.start, err = time.Parse(time.RFC3339, w.Start); err != nil {
+		return window, fmt.Errorf("invalid window start: %s", err)
+	}
+	if window.end, err = time.Parse(time.RFC3339, w.End); err != nil {
+		return window, fmt.Errorf("invalid window end: %s", err)
+	}
+	if !window.end.After(window.start) {
+		return window, fmt.Errorf("window ending at %s ends before it starts", w.End)
+	}
+	return window, nil
+}
+
+// activeAt tells whether the window is open at the given time
+func (w Window) activeAt(t time.Time) bool {
+	if w.cron == nil {
+		return !t.Before(w.start) && t.Before(w.end)
+	}
+	start := w.cron.next(t.Add(-w.duration))
+	return !start.IsZero() && !start.After(t)
+}
+
+// starts returns the times the window opens from the given time to the
+// other, explicit windows are returned even when they start after it
+func (w Window) starts(from time.Time, to time.Time) []time.Time {
+	if w.cron == nil {
+		if w.start.Before(from) {
+			return nil
+		}
+		return []time.Time{w.start}
+	}
+	starts := []time.Time{}
+	for start := w.cron.next(from.Add(-time.Nanosecond)); !start.IsZero() && !start.After(to); start = w.cron.next(start) {
+		starts = append(starts, start)
+	}
+	return starts
+}
+
+// nextBoundary returns the first time after the given one the window opens
+// or closes, zero if it never does
+func (w Window) nextBoundary(t time.Time) time.Time {
+	if w.cron == nil {
+		if w.start.After(t) {
+			return w.start
+		}
+		if w.end.After(t) {
+			return w.end
+		}
+		return time.Time{}
+	}
+	next := w.cron.next(t)
+	if start := w.cron.next(t.Add(-w.duration)); !start.IsZero() {
+		if end := start.Add(w.duration); end.After(t) && (next.IsZero() || end.Before(next)) {
+			next = end
+		}
+	}
+	return next
+}
+
+// validateSchedule checks every window of the function parses
+func validateSchedule(request requests.CreateFunctionRequest) error {
+	invalid := &SpecError{}
+	for i, w := range request.Schedule {
+		if _, err := parseWindow(w); err != nil {
+			invalid.add(fmt.Sprintf("schedule[%d]", i), err)
+		}
+	}
+	return invalid.orNil()
+}
+
+// ReservationSchedule holds the reservation of a function outside of its
+// windows and the one of each window
+type ReservationSchedule struct {
+	Base    Reservation
+	Windows []ScheduledReservation
+}
+
+// ScheduledReservation is the reservation of a function during a window
+type ScheduledReservation struct {
+	Window      Window
+	Reservation Reservation
+}
+
+// At returns the reservation in force at the given time, the one of the
+// open window with the highest rate
+func (s *ReservationSchedule) At(t time.Time) Reservation {
+	res := s.Base
+	open := false
+	for _, w := range s.Windows {
+		if w.Window.activeAt(t) && (!open || w.Reservation.Task.Rate > res.Task.Rate) {
+			res = w.Reservation
+			open = true
+		}
+	}
+	res.Schedule = s
+	return res
+}
+
+// At returns the reservation in force at the given time, the reservation
+// itself when it has no schedule or the time is zero
+func (r Reservation) At(t time.Time) Reservation {
+	if r.Schedule == nil || t.IsZero() {
+		return r
+	}
+	return r.Schedule.At(t)
+}
+
+// same tells whether both reservations hold the same resources on the same
+// schedule
+func (r Reservation) same(other Reservation) bool {
+	if r.CPU != other.CPU || r.Memory != other.Memory || r.Pool != other.Pool || r.Task != other.Task {
+		return false
+	}
+	if r.Schedule == nil || other.Schedule == nil {
+		return r.Schedule == other.Schedule
+	}
+	if len(r.Schedule.Windows) != len(other.Schedule.Windows) {
+		return false
+	}
+	for i, w := range r.Schedule.Windows {
+		if w.Window.RealtimeWindow != other.Schedule.Windows[i].Window.RealtimeWindow {
+			return false
+		}
+	}
+	return true
+}
+
+// scheduledReservation computes the reservation of a function in force at
+// the given time, along with its schedule when it has one
+func scheduledReservation(request requests.CreateFunctionRequest, cpus int64, memory int64, now time.Time) (Reservation, error) {
+	base := reservationFor(request, cpus, memory)
+	if len(request.Schedule) == 0 {
+		return base, nil
+	}
+	schedule := &ReservationSchedule{Base: base}
+	for _, w := range request.Schedule {
+		window, err := parseWindow(w)
+		if err != nil {
+			return base, err
+		}
+		windowed := request
+		windowed.Realtime = w.Realtime
+		schedule.Windows = append(schedule.Windows, ScheduledReservation{
+			Window:      window,
+			Reservation: reservationFor(windowed, cpus, memory),
+		})
+	}
+	return schedule.At(now), nil
+}
+
+// scheduledRate returns the rate the function is guaranteed at the given time
+func scheduledRate(request requests.CreateFunctionRequest, t time.Time) float64 {
+	rate := request.Realtime
+	open := false
+	for _, w := range request.Schedule {
+		window, err := parseWindow(w)
+		if err == nil && window.activeAt(t) && (!open || w.Realtime > rate) {
+			rate = w.Realtime
+			open = true
+		}
+	}
+	return rate
+}
+
+// scheduleInstants returns the times within the horizon the reservation
+// changes at
+func scheduleInstants(res Reservation, now time.Time) []time.Time {
+	if res.Schedule == nil {
+		return nil
+	}
+	instants := []time.Time{}
+	for _, w := range res.Schedule.Windows {
+		instants = append(instants, w.Window.starts(now, now.Add(scheduleHorizon))...)
+	}
+	return instants
+}
+
+// checkSchedule checks the reservation, along with the others, fits the
+// capacity, or the budget of its pool, at every time a window opens
+func (l *CapacityLedger) checkSchedule(functionName string, res Reservation, ratio float64) error {
+	now := time.Now()
+	instants := scheduleInstants(res, now)
+	for name, other := range l.reservations {
+		if name != functionName {
+			instants = append(instants, scheduleInstants(other, now)...)
+		}
+	}
+	budget, declared := l.pools[res.Pool]
+	limitCPU := int64(float64(l.TotalCPU) * ratio)
+	limitMemory := int64(float64(l.TotalMemory) * ratio)
+
+	for _, t := range instants {
+		at := res.At(t)
+		if declared {
+			if err := l.fitPool(functionName, at, budget, t); err != nil {
+				return err
+			}
+		} else {
+			usedCPU, usedMemory := l.committedAt(t)
+			if prev, existed := l.reservations[functionName]; existed && !l.pooled(prev) {
+				prev = prev.At(t)
+				usedCPU -= prev.CPU
+				usedMemory -= prev.Memory
+			}
+			if l.TotalCPU > 0 && usedCPU+at.CPU > limitCPU {
+				return &CapacityError{Function: functionName, Resource: "cpu", At: t,
+					Requested: at.CPU, Available: limitCPU - usedCPU}
+			}
+			if l.TotalMemory > 0 && usedMemory+at.Memory > limitMemory {
+				return &CapacityError{Function: functionName, Resource: "memory", At: t,
+					Requested: at.Memory, Available: limitMemory - usedMemory}
+			}
+		}
+		if err := l.checkSchedulability(functionName, at, nil, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scheduleOf returns the windows of the reservation
+func scheduleOf(res Reservation) []requests.RealtimeWindow {
+	if res.Schedule == nil {
+		return nil
+	}
+	windows := []requests.RealtimeWindow{}
+	for _, w := range res.Schedule.Windows {
+		windows = append(windows, w.Window.RealtimeWindow)
+	}
+	return windows
+}
+
+// Switcher raises and lowers the reservations of scheduled functions as
+// their windows open and close
+type Switcher struct {
+}
+
+// NewSwitcher creates a switcher
+func NewSwitcher() *Switcher {
+	return &Switcher{}
+}
+
+// Start switches at every window boundary
+func (sw *Switcher) Start() {
+	go func() {
+		for {
+			now := time.Now()
+			sw.Switch(now)
+			wait := scheduleRecheck
+			if next := sw.nextBoundary(now); !next.IsZero() && next.Sub(now) < wait {
+				wait = next.Sub(now)
+			}
+			time.Sleep(wait)
+		}
+	}()
+}
+
+// nextBoundary returns the first time after the given one a window of a
+// reserved function opens or closes
+func (sw *Switcher) nextBoundary(now time.Time) time.Time {
+	ledger := GetLedger()
+	next := time.Time{}
+	for _, functionName := range ledger.Functions() {
+		reservation, reserved := ledger.Get(functionName)
+		if !reserved || reservation.Schedule == nil {
+			continue
+		}
+		for _, w := range reservation.Schedule.Windows {
+			if boundary := w.Window.nextBoundary(now); !boundary.IsZero() && (next.IsZero() || boundary.Before(next)) {
+				next = boundary
+			}
+		}
+	}
+	return next
+}
+
+// Switch renegotiates the scheduled functions whose sandbox is not sized for
+// the rate their schedule sets at the given time
+func (sw *Switcher) Switch(now time.Time) {
+	renegotiator := GetRenegotiator()
+	if renegotiator == nil {
+		return
+	}
+
+	// Functions deployed before the gateway started are only known to the
+	// ledger, their spec is rebuilt from the provider
+	for _, functionName := range GetLedger().Functions() {
+		if isPending(functionName) {
+			continue
+		}
+		spec, known := renegotiator.lookupDeployed(functionName)
+		if !known || len(spec.Schedule) == 0 {
+			continue
+		}
+		rate := scheduledRate(spec, now)
+		current, known := renegotiator.appliedRate(functionName)
+		if !known || rate == current {
+			continue
+		}
+		log.Printf("Switch reservation of %s: realtime %f -> %f", functionName, current, rate)
+
+		realtime := spec.Realtime
+		statusCode, body, err := renegotiator.Renegotiate(functionName, requests.RealtimeRenegotiation{Realtime: &realtime})
+		if err != nil {
+			log.Printf("Unable to switch %s: %d %s", functionName, statusCode, body)
+		}
+	}
+}
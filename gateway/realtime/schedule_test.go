@@ -0,0 +1,289 @@
+package realtime
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ngduchai/faas/gateway/handlers"
+	"github.com/ngduchai/faas/gateway/requests"
+	"github.com/ngduchai/faas/gateway/scaling"
+)
+
+func Test_ParseCron(t *testing.T) {
+	cron, err := parseCron("*/15 9-17 * * 1-5", time.UTC)
+	if err != nil {
+		t.Errorf("parseCron - error want: %s, got %s", "nil", err.Error())
+		t.FailNow()
+	}
+
+	// 2026-10-19 is a Monday
+	cases := []struct {
+		after time.Time
+		want  time.Time
+	}{
+		{time.Date(2026, 10, 19, 8, 50, 0, 0, time.UTC), time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC), time.Date(2026, 10, 19, 9, 15, 0, 0, time.UTC)},
+		{time.Date(2026, 10, 19, 17, 50, 0, 0, time.UTC), time.Date(2026, 10, 20, 9, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 10, 23, 18, 0, 0, 0, time.UTC), time.Date(2026, 10, 26, 9, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		if next := cron.next(c.after); !next.Equal(c.want) {
+			t.Errorf("next %s - want: %s, got %s", c.after, c.want, next)
+			t.Fail()
+		}
+	}
+
+	for _, expression := range []string{"60 * * * *", "* * *", "*/0 * * * *", "5-1 * * * *"} {
+		if _, err := parseCron(expression, time.UTC); err == nil {
+			t.Errorf("parseCron %q - error want: invalid expression, got nil", expression)
+			t.Fail()
+		}
+	}
+}
+
+func Test_CronMatchesEitherRestrictedDay(t *testing.T) {
+	// The first of the month or any Monday
+	cron, _ := parseCron("0 0 1 * 1", time.UTC)
+	next := cron.next(time.Date(2026, 10, 27, 0, 0, 0, 0, time.UTC))
+	if want := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("next - want: %s, got %s", want, next)
+		t.Fail()
+	}
+	next = cron.next(next)
+	if want := time.Date(2026, 11, 2, 0, 0, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("next - want: %s, got %s", want, next)
+		t.Fail()
+	}
+}
+
+func Test_WindowActiveAndBoundaries(t *testing.T) {
+	window, err := parseWindow(requests.RealtimeWindow{Cron: "0 9 * * 1-5", Duration: "8h", Realtime: 10})
+	if err != nil {
+		t.Errorf("parseWindow - error want: %s, got %s", "nil", err.Error())
+		t.FailNow()
+	}
+	monday := func(hour int) time.Time { return time.Date(2026, 10, 19, hour, 0, 0, 0, time.UTC) }
+
+	if !window.activeAt(monday(9)) || !window.activeAt(monday(16)) {
+		t.Errorf("activeAt - want: open from 9 to 17, got closed")
+		t.Fail()
+	}
+	if window.activeAt(monday(8)) || window.activeAt(monday(17)) {
+		t.Errorf("activeAt - want: closed before 9 and from 17, got open")
+		t.Fail()
+	}
+	if next := window.nextBoundary(monday(10)); !next.Equal(monday(17)) {
+		t.Errorf("nextBoundary - want: %s, got %s", monday(17), next)
+		t.Fail()
+	}
+	if next, want := window.nextBoundary(monday(18)), monday(9).AddDate(0, 0, 1); !next.Equal(want) {
+		t.Errorf("nextBoundary - want: %s, got %s", want, next)
+		t.Fail()
+	}
+	if starts := window.starts(monday(0), monday(0).AddDate(0, 0, 7)); len(starts) != 5 {
+		t.Errorf("starts - want: %d, got %d", 5, len(starts))
+		t.Fail()
+	}
+
+	invalid := []requests.RealtimeWindow{
+		{Cron: "0 9 * * *", Realtime: 10},
+		{Cron: "0 9 * * *", Duration: "1h", Start: "2026-10-19T09:00:00Z", Realtime: 10},
+		{Start: "2026-10-19T09:00:00Z", End: "2026-10-19T08:00:00Z", Realtime: 10},
+		{Start: "2026-10-19T09:00:00Z", End: "2026-10-19T10:00:00Z", Realtime: -1},
+	}
+	for _, w := range invalid {
+		if _, err := parseWindow(w); err == nil {
+			t.Errorf("parseWindow %+v - error want: invalid window, got nil", w)
+			t.Fail()
+		}
+	}
+}
+
+// windowedRequest is guaranteed the base rate but the given one from start to end
+func windowedRequest(functionName string, base float64, rate float64, start time.Time, end time.Time) requests.CreateFunctionRequest {
+	return requests.CreateFunctionRequest{
+		Service:  functionName,
+		Realtime: base,
+		Timeout:  1000,
+		Schedule: []requests.RealtimeWindow{{
+			Start:    start.Format(time.RFC3339),
+			End:      end.Format(time.RFC3339),
+			Realtime: rate,
+		}},
+	}
+}
+
+func Test_LedgerRejectsOverlappingWindows(t *testing.T) {
+	ledger := NewCapacityLedger(1000, 0)
+	ledger.Policy = PolicyNone
+	now := time.Now().Truncate(time.Second)
+
+	f1, _ := scheduledReservation(windowedRequest("f1", 1, 6, now.Add(time.Hour), now.Add(3*time.Hour)), 100, 0, now)
+	if _, _, err := ledger.Reserve("f1", f1); err != nil {
+		t.Errorf("Reserve f1 - error want: %s, got %s", "nil", err.Error())
+		t.FailNow()
+	}
+
+	// Both fit now but not while their windows overlap
+	f2, _ := scheduledReservation(windowedRequest("f2", 1, 5, now.Add(2*time.Hour), now.Add(4*time.Hour)), 100, 0, now)
+	_, _, err := ledger.Reserve("f2", f2)
+	capacityErr, ok := err.(*CapacityError)
+	if !ok {
+		t.Errorf("Reserve f2 - error want: %s, got %v", "CapacityError", err)
+		t.FailNow()
+	}
+	if want := now.Add(2 * time.Hour); !capacityErr.At.Equal(want) || capacityErr.Available != 400 {
+		t.Errorf("Reserve f2 - error want: %s %d, got %s %d", want, 400, capacityErr.At, capacityErr.Available)
+		t.Fail()
+	}
+
+	// Windows are open up to their end
+	f3, _ := scheduledReservation(windowedRequest("f3", 1, 5, now.Add(3*time.Hour), now.Add(4*time.Hour)), 100, 0, now)
+	if _, _, err := ledger.Reserve("f3", f3); err != nil {
+		t.Errorf("Reserve f3 - error want: %s, got %s", "nil", err.Error())
+		t.Fail()
+	}
+	if cpu, _ := ledger.Committed(); cpu != 200 {
+		t.Errorf("Committed - cpu want: %d, got %d", 200, cpu)
+		t.Fail()
+	}
+}
+
+func Test_SwitcherLowersRateAfterWindow(t *testing.T) {
+	functionName := "scheduled"
+	SetupLedger(1000, 0, PolicyNone)
+	defer SetupLedger(0, 0, PolicyEDF)
+	provider := newAdmissionProvider()
+	defer provider.server.Close()
+	scaling.GetScalerInstance().Config.ServiceQuery = ReadyServiceQuery{
+		Response: scaling.ServiceQueryResponse{Replicas: 1, AvailableReplicas: 1, Realtime: 2, CPU: 1000, Duration: 100},
+	}
+	ac := ReserveAdmissionControl{}
+	SetupRenegotiator(&Renegotiator{
+		AdmissionControl: ac,
+		Client:           http.DefaultClient,
+		BaseURLResolver:  handlers.SingleHostBaseURLResolver{BaseURL: provider.server.URL},
+		Timeout:          time.Second,
+	})
+	defer SetupRenegotiator(nil)
+	defer RemoveFunctionHandler(functionName)
+	defer forgetSpec(functionName)
+
+	now := time.Now()
+	spec := admissionSpec(functionName, 2)
+	spec.Schedule = []requests.RealtimeWindow{{
+		Start:    now.Add(-time.Hour).Format(time.RFC3339),
+		End:      now.Add(time.Second).Format(time.RFC3339),
+		Realtime: 8,
+	}}
+	if code := deploy(ac, provider, http.MethodPost, spec); code != http.StatusAccepted {
+		t.Errorf("Register - status want: %d, got %d", http.StatusAccepted, code)
+		t.FailNow()
+	}
+	deployed := provider.lastDeployed()
+	if spec := deployedSpec(deployed); spec.Realtime != 2 || len(spec.Schedule) != 1 {
+		t.Errorf("Register - spec want: base rate and schedule, got %+v", spec)
+		t.Fail()
+	}
+	entry, _ := functionHandlers.Load(functionName)
+	if realtime := entry.(*InvocationHandler).params().Realtime; realtime != 8 {
+		t.Errorf("Register - handler rate want: %f, got %f", 8.0, realtime)
+		t.Fail()
+	}
+	if cpu, _ := GetLedger().Committed(); cpu != 800 {
+		t.Errorf("Register - cpu want: %d, got %d", 800, cpu)
+		t.Fail()
+	}
+
+	end := now.Add(time.Second).Truncate(time.Second)
+	if next := NewSwitcher().nextBoundary(now); !next.Equal(end) {
+		t.Errorf("nextBoundary - want: %s, got %s", end, next)
+		t.Fail()
+	}
+	time.Sleep(time.Until(end))
+	NewSwitcher().Switch(time.Now())
+
+	entry, _ = functionHandlers.Load(functionName)
+	if realtime := entry.(*InvocationHandler).params().Realtime; realtime != 2 {
+		t.Errorf("Switch - handler rate want: %f, got %f", 2.0, realtime)
+		t.Fail()
+	}
+	if cpu, _ := GetLedger().Committed(); cpu != 200 {
+		t.Errorf("Switch - cpu want: %d, got %d", 200, cpu)
+		t.Fail()
+	}
+	if deployed := provider.lastDeployed(); deployed.Requests == nil || deployed.Requests.CPU != "200m" {
+		t.Errorf("Switch - sandbox CPU want: %s, got %+v", "200m", deployed.Requests)
+		t.Fail()
+	}
+}
+
+func Test_SwitcherResizesSandboxAfterRestart(t *testing.T) {
+	functionName := "scheduled-restart"
+	SetupLedger(1000, 0, PolicyNone)
+	defer SetupLedger(0, 0, PolicyEDF)
+	provider := newAdmissionProvider()
+	defer provider.server.Close()
+	scaling.GetScalerInstance().Config.ServiceQuery = ReadyServiceQuery{
+		Response: scaling.ServiceQueryResponse{Replicas: 1, AvailableReplicas: 1, Realtime: 2, CPU: 1000, Duration: 100},
+	}
+	ac := ReserveAdmissionControl{}
+	SetupRenegotiator(&Renegotiator{
+		AdmissionControl: ac,
+		Client:           http.DefaultClient,
+		BaseURLResolver:  handlers.SingleHostBaseURLResolver{BaseURL: provider.server.URL},
+		Timeout:          time.Second,
+	})
+	defer SetupRenegotiator(nil)
+	defer RemoveFunctionHandler(functionName)
+	defer forgetSpec(functionName)
+	defer forgetApplied(functionName)
+
+	now := time.Now()
+	spec := admissionSpec(functionName, 2)
+	spec.Schedule = []requests.RealtimeWindow{{
+		Start:    now.Add(-time.Hour).Format(time.RFC3339),
+		End:      now.Add(time.Second).Format(time.RFC3339),
+		Realtime: 8,
+	}}
+	if code := deploy(ac, provider, http.MethodPost, spec); code != http.StatusAccepted {
+		t.Errorf("Register - status want: %d, got %d", http.StatusAccepted, code)
+		t.FailNow()
+	}
+	if active := deployedSpec(provider.lastDeployed()).Active; active != 8 {
+		t.Errorf("Register - applied rate want: %f, got %f", 8.0, active)
+		t.Fail()
+	}
+
+	// The gateway restarts once the window closed, the ledger is restored
+	// at the rate of the schedule while the sandbox is still sized for the
+	// window
+	time.Sleep(time.Until(now.Add(time.Second).Truncate(time.Second)))
+	forgetSpec(functionName)
+	forgetApplied(functionName)
+	RemoveFunctionHandler(functionName)
+	GetLedger().Release(functionName)
+	deployed := provider.lastDeployed()
+	reconcileFunctions([]reportedFunction{{Function: requests.Function{
+		Name:        deployed.Service,
+		Image:       deployed.Image,
+		Labels:      deployed.Labels,
+		Annotations: deployed.Annotations,
+	}}})
+	if reservation, _ := GetLedger().Get(functionName); reservation.Task.Rate != 2 {
+		t.Errorf("Reconcile - ledger rate want: %f, got %f", 2.0, reservation.Task.Rate)
+		t.FailNow()
+	}
+
+	NewSwitcher().Switch(time.Now())
+	deployed = provider.lastDeployed()
+	if deployed.Requests == nil || deployed.Requests.CPU != "200m" {
+		t.Errorf("Switch - sandbox CPU want: %s, got %+v", "200m", deployed.Requests)
+		t.Fail()
+	}
+	if active := deployedSpec(deployed).Active; active != 2 {
+		t.Errorf("Switch - applied rate want: %f, got %f", 2.0, active)
+		t.Fail()
+	}
+}
@@ -0,0 +1,184 @@
+package realtime
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Scheduler dispatches the queued invocations of every realtime function
+// from a single goroutine. Functions with pending work are kept in a
+// min-heap ordered by the time their token bucket admits the next
+// invocation, so the cost of a dispatch grows with log(functions) and a
+// single timer is armed for the earliest one.
+type Scheduler struct {
+	entries scheduleHeap
+	wake    chan bool
+	stop    chan bool
+	sync    sync.Mutex
+	// backfill is set when best-effort functions borrow idle slots
+	backfill int32
+}
+
+// scheduleEntry is the position of a function in the scheduler heap
+type scheduleEntry struct {
+	handler *InvocationHandler
+	due     time.Time
+	index   int
+}
+
+// NewScheduler creates a scheduler, Start must be called to dispatch invocations
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		entries: scheduleHeap{},
+		wake:    make(chan bool, 1),
+		stop:    make(chan bool),
+	}
+}
+
+// Start runs the dispatch loop in the background
+func (s *Scheduler) Start() {
+	go s.run()
+}
+
+// Stop terminates the dispatch loop
+func (s *Scheduler) Stop() {
+	close(s.stop)
+}
+
+// Schedule (re)computes when the next invocation of the handler is due and
+// wakes up the dispatch loop if that is earlier than anything else
+func (s *Scheduler) Schedule(handler *InvocationHandler) {
+	now := time.Now()
+	due := now.Add(handler.slotWait(now))
+
+	s.sync.Lock()
+	entry := handler.entry
+	if entry == nil {
+		entry = &scheduleEntry{handler: handler, due: due}
+		handler.entry = entry
+		heap.Push(&s.entries, entry)
+	} else {
+		entry.due = due
+		heap.Fix(&s.entries, entry.index)
+	}
+	earliest := s.entries[0] == entry
+	s.sync.Unlock()
+
+	if earliest {
+		s.notify()
+	}
+}
+
+// Remove stops scheduling the handler
+func (s *Scheduler) Remove(handler *InvocationHandler) {
+	s.sync.Lock()
+	defer s.sync.Unlock()
+
+	if handler.entry != nil {
+		heap.Remove(&s.entries, handler.entry.index)
+		handler.entry = nil
+	}
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- true:
+	default:
+	}
+}
+
+func (s *Scheduler) run() {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	for {
+		wait := s.dispatchDue(time.Now())
+
+		if wait > 0 {
+			timer.Reset(wait)
+		}
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	}
+}
+
+// dispatchDue releases one invocation of every function whose token is
+// due and returns how long to wait for the next one, zero when idle
+func (s *Scheduler) dispatchDue(now time.Time) time.Duration {
+	s.sync.Lock()
+	defer s.sync.Unlock()
+
+	for len(s.entries) > 0 {
+		entry := s.entries[0]
+		if entry.due.After(now) {
+			return entry.due.Sub(now)
+		}
+
+		handler := entry.handler
+		if invocation, ok := handler.next(now); ok {
+			go invocation.execute()
+		}
+
+		if handler.pending() > 0 {
+			entry.due = now.Add(handler.slotWait(now))
+			heap.Fix(&s.entries, 0)
+		} else {
+			heap.Pop(&s.entries)
+			handler.entry = nil
+		}
+	}
+	return 0
+}
+
+var schedulerInstance *Scheduler
+var schedulerOnce sync.Once
+
+// GetScheduler returns the scheduler shared by all realtime functions
+func GetScheduler() *Scheduler {
+	schedulerOnce.Do(func() {
+		schedulerInstance = NewScheduler()
+		schedulerInstance.Start()
+	})
+	return schedulerInstance
+}
+
+// scheduleHeap implements heap.Interface over the schedule entries
+type scheduleHeap []*scheduleEntry
+
+func (h scheduleHeap) Len() int { return len(h) }
+
+func (h scheduleHeap) Less(i, j int) bool { return h[i].due.Before(h[j].due) }
+
+func (h scheduleHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *scheduleHeap) Push(x interface{}) {
+	entry := x.(*scheduleEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *scheduleHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	entry.index = -1
+	*h = old[:n-1]
+	return entry
+}
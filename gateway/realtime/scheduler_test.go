@@ -0,0 +1,144 @@
+package realtime
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ngduchai/faas/gateway/requests"
+)
+
+func Test_SchedulerDispatchesAtRate(t *testing.T) {
+	scheduler := NewScheduler()
+	scheduler.Start()
+	defer scheduler.Stop()
+
+	handler := newInvocationHandler(requests.CreateFunctionRequest{Service: "paced", Realtime: 20}, scheduler)
+	handler.Bucket.Take(time.Now())
+
+	dispatched := make(chan time.Time, 3)
+	for i := 0; i < 3; i++ {
+		handler.admit(Invocation{
+			next: func(w http.ResponseWriter, r *http.Request) { dispatched <- time.Now() },
+			done: make(chan bool, 1),
+		}, false)
+	}
+	start := time.Now()
+	scheduler.Schedule(handler)
+
+	for i := 1; i <= 3; i++ {
+		select {
+		case at := <-dispatched:
+			elapsed := at.Sub(start)
+			want := time.Duration(i) * 50 * time.Millisecond
+			if elapsed < want-10*time.Millisecond || elapsed > want+40*time.Millisecond {
+				t.Errorf("Schedule - dispatch %d want: ~%s, got %s", i, want, elapsed)
+				t.Fail()
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Schedule - dispatch %d did not happen", i)
+			t.FailNow()
+		}
+	}
+}
+
+func Test_SchedulerRemoveReleasesPending(t *testing.T) {
+	functionName := "removed"
+	SetFunctionHandler(requests.CreateFunctionRequest{Service: functionName, Realtime: 0.1})
+	entry, _ := functionHandlers.Load(functionName)
+	entry.(*InvocationHandler).Bucket.Take(time.Now())
+
+	finished := make(chan bool)
+	go func() {
+		r := httptest.NewRequest(http.MethodGet, "/function/"+functionName, nil)
+		Invoke(func(w http.ResponseWriter, r *http.Request) {}, httptest.NewRecorder(), r)
+		finished <- true
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	RemoveFunctionHandler(functionName)
+
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Errorf("RemoveFunctionHandler - pending invocation was not released")
+		t.Fail()
+	}
+}
+
+// benchmarkDispatchJitter keeps the queues of the given number of functions
+// full and measures how far apart consecutive dispatches of a function are
+// from the interval of its guaranteed rate
+func benchmarkDispatchJitter(b *testing.B, functions int, rate float64) {
+	scheduler := NewScheduler()
+	scheduler.Start()
+	defer scheduler.Stop()
+
+	perFunction := b.N/functions + 2
+	interval := time.Duration(float64(time.Second) / rate)
+	dispatches := make([][]time.Time, functions)
+	handlers := make([]*InvocationHandler, functions)
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < functions; i++ {
+		handler := newInvocationHandler(requests.CreateFunctionRequest{
+			Service:  fmt.Sprintf("bench-%d", i),
+			Realtime: rate,
+		}, scheduler)
+		handler.BufferSize = perFunction
+		handler.Bucket.Take(time.Now())
+		dispatches[i] = make([]time.Time, perFunction)
+		for j := 0; j < perFunction; j++ {
+			i, j := i, j
+			wg.Add(1)
+			handler.admit(Invocation{
+				next: func(w http.ResponseWriter, r *http.Request) {
+					dispatches[i][j] = time.Now()
+					wg.Done()
+				},
+				done: make(chan bool, 1),
+			}, false)
+		}
+		handlers[i] = handler
+	}
+
+	b.ResetTimer()
+	for _, handler := range handlers {
+		scheduler.Schedule(handler)
+	}
+	wg.Wait()
+	b.StopTimer()
+
+	jitter := []time.Duration{}
+	for _, times := range dispatches {
+		sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+		for j := 1; j < len(times); j++ {
+			deviation := times[j].Sub(times[j-1]) - interval
+			if deviation < 0 {
+				deviation = -deviation
+			}
+			jitter = append(jitter, deviation)
+		}
+	}
+	sort.Slice(jitter, func(i, j int) bool { return jitter[i] < jitter[j] })
+	if len(jitter) > 0 {
+		b.Logf("p50 jitter: %d us, p99 jitter: %d us",
+			jitter[len(jitter)/2]/time.Microsecond, jitter[len(jitter)*99/100]/time.Microsecond)
+	}
+}
+
+func BenchmarkSchedulerJitter100Functions(b *testing.B) {
+	benchmarkDispatchJitter(b, 100, 10)
+}
+
+func BenchmarkSchedulerJitter1000Functions(b *testing.B) {
+	benchmarkDispatchJitter(b, 1000, 10)
+}
+
+func BenchmarkSchedulerJitter5000Functions(b *testing.B) {
+	benchmarkDispatchJitter(b, 5000, 10)
+}
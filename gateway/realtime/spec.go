@@ -0,0 +1,176 @@
+package realtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ngduchai/faas/gateway/realtime/specs"
+	"github.com/ngduchai/faas/gateway/requests"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// maxTimeout is the longest invocation (in ms) a reservation is made for
+const maxTimeout = uint64(time.Hour / time.Millisecond)
+
+// legacyLabels held the realtime parameters of a function before they were
+// kept in its reservation spec
+var legacyLabels = []string{"realtime", "cpu", "memory", "duration"}
+
+// SpecError lists the fields of a deployment found invalid
+type SpecError struct {
+	Fields []requests.FieldError
+}
+
+func (e *SpecError) Error() string {
+	fields := []string{}
+	for _, f := range e.Fields {
+		fields = append(fields, f.Field+": "+f.Message)
+	}
+	return "invalid realtime parameters: " + strings.Join(fields, "; ")
+}
+
+// add records the error against the field, the fields of a nested
+// SpecError are kept as they are
+func (e *SpecError) add(field string, err error) {
+	if nested, ok := err.(*SpecError); ok {
+		e.Fields = append(e.Fields, nested.Fields...)
+		return
+	}
+	e.Fields = append(e.Fields, requests.FieldError{Field: field, Message: err.Error()})
+}
+
+// orNil returns the error if any field was found invalid
+func (e *SpecError) orNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// validateSpec checks the rates, timeout and quantities of the function
+func validateSpec(request requests.CreateFunctionRequest) error {
+	invalid := &SpecError{}
+	rate := func(field string, value float64) {
+		if math.IsNaN(value) || math.IsInf(value, 0) || value < 0 {
+			invalid.add(field, fmt.Errorf("%v is not a rate of zero or more", value))
+		}
+	}
+	rate("realtime", request.Realtime)
+	rate("burst", request.Burst)
+	rate("poolWeight", request.PoolWeight)
+	for i, w := range request.Schedule {
+		rate(fmt.Sprintf("schedule[%d].realtime", i), w.Realtime)
+	}
+
+	realtime := specs.PeakRealtime(request) > 0
+	if realtime && request.Timeout == 0 {
+		invalid.add("timeout", fmt.Errorf("must be set for functions with a guaranteed rate"))
+	}
+	if request.Timeout > maxTimeout {
+		invalid.add("timeout", fmt.Errorf("%dms exceeds the longest invocation of %dms", request.Timeout, maxTimeout))
+	}
+
+	quantity := func(field string, value string, required bool) {
+		if len(value) == 0 {
+			if required {
+				invalid.add(field, fmt.Errorf("must be set for functions with a guaranteed rate"))
+			}
+			return
+		}
+		parsed, err := resource.ParseQuantity(value)
+		if err != nil {
+			invalid.add(field, fmt.Errorf("%q is not a quantity", value))
+		} else if parsed.Sign() < 0 {
+			invalid.add(field, fmt.Errorf("%q is negative", value))
+		} else if required && field == "resources.cpu" && parsed.Sign() == 0 {
+			// The schedulability test weighs the rate and timeout by the CPU
+			// of an invocation, none would admit any rate
+			invalid.add(field, fmt.Errorf("must be positive for functions with a guaranteed rate"))
+		}
+	}
+	for _, sized := range []struct {
+		field     string
+		resources *requests.FunctionResources
+	}{
+		{"resources", request.Resources},
+		{"limits", request.Limits},
+		{"requests", request.Requests},
+	} {
+		if sized.resources == nil {
+			continue
+		}
+		required := realtime && sized.field == "resources"
+		quantity(sized.field+".cpu", sized.resources.CPU, required)
+		quantity(sized.field+".memory", sized.resources.Memory, required)
+	}
+	return invalid.orNil()
+}
+
+// validateRequest checks the realtime parameters of the function before
+// anything is reserved, every invalid field is reported
+func validateRequest(request requests.CreateFunctionRequest) error {
+	invalid := &SpecError{}
+	for _, check := range []struct {
+		field    string
+		validate func(requests.CreateFunctionRequest) error
+	}{
+		{"", validateSpec},
+		{"qos", validateQoS},
+		{"schedule", validateSchedule},
+		{"lease", validateLease},
+	} {
+		if err := check.validate(request); err != nil {
+			invalid.add(check.field, err)
+		}
+	}
+	return invalid.orNil()
+}
+
+// writeInvalidRequest turns away a deployment whose realtime parameters
+// are invalid, listing the invalid fields
+func writeInvalidRequest(w http.ResponseWriter, functionName string, err error) (int, error) {
+	log.Printf("Reject function %s: %s", functionName, err)
+	out := requests.InvalidDeployment{Function: functionName}
+	if invalid, ok := err.(*SpecError); ok {
+		out.Errors = invalid.Fields
+	} else {
+		out.Errors = []requests.FieldError{{Message: err.Error()}}
+	}
+	body, _ := json.Marshal(out)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write(body)
+	return http.StatusBadRequest, err
+}
+
+// writeSpec stores the realtime parameters of the function in its
+// reservation spec, dropping the labels they used to be kept in
+func writeSpec(cfr *requests.CreateFunctionRequest) error {
+	if cfr.Labels != nil {
+		for _, label := range legacyLabels {
+			delete(*cfr.Labels, label)
+		}
+	}
+	return specs.Write(cfr)
+}
+
+// requestFromFunction rebuilds the realtime parameters of a deployed
+// function from its reservation spec, or from its labels if it was
+// deployed before the gateway kept a spec
+func requestFromFunction(function requests.Function) (requests.CreateFunctionRequest, error) {
+	spec, err := specs.Read(function.Annotations)
+	if err != nil {
+		return requests.CreateFunctionRequest{Service: function.Name}, err
+	}
+	if spec == nil {
+		return requestFromLabels(function)
+	}
+	request := requests.CreateFunctionRequest{Service: function.Name}
+	specs.Apply(*spec, &request)
+	return request, nil
+}
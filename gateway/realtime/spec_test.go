@@ -0,0 +1,137 @@
+package realtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/ngduchai/faas/gateway/realtime/specs"
+	"github.com/ngduchai/faas/gateway/requests"
+)
+
+func Test_ValidateRequestReportsFieldPaths(t *testing.T) {
+	request := requests.CreateFunctionRequest{
+		Service:   "spec-invalid",
+		Realtime:  -1,
+		Burst:     math.NaN(),
+		Timeout:   maxTimeout + 1,
+		Resources: &requests.FunctionResources{CPU: "lots", Memory: "-1Mi"},
+		Limits:    &requests.FunctionResources{CPU: "1", Memory: "1Gi"},
+		Schedule:  []requests.RealtimeWindow{{Cron: "0 9 * * *", Duration: "1h", Realtime: 5}, {Cron: "bad", Realtime: 2}},
+		Lease:     "forever",
+	}
+
+	err := validateRequest(request)
+	invalid, ok := err.(*SpecError)
+	if !ok {
+		t.Errorf("validateRequest - error want: %T, got %v", &SpecError{}, err)
+		t.FailNow()
+	}
+	fields := []string{}
+	for _, f := range invalid.Fields {
+		fields = append(fields, f.Field)
+	}
+	want := []string{"realtime", "burst", "timeout", "resources.cpu", "resources.memory", "schedule[1]", "lease"}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("validateRequest - fields want: %v, got %v", want, fields)
+		t.Fail()
+	}
+
+	valid := admissionSpec("spec-valid", 5)
+	if err := validateRequest(valid); err != nil {
+		t.Errorf("validateRequest - error want: nil, got %s", err)
+		t.Fail()
+	}
+	valid.Timeout = 0
+	if err := validateRequest(valid); err == nil {
+		t.Errorf("validateRequest - realtime without timeout want: error, got nil")
+		t.Fail()
+	}
+
+	// Without CPU the function would pass any schedulability test
+	idle := admissionSpec("spec-idle", 5)
+	idle.Resources.CPU = "0"
+	if err := validateRequest(idle); err == nil {
+		t.Errorf("validateRequest - realtime without cpu want: error, got nil")
+		t.Fail()
+	}
+	idle.Realtime = 0
+	if err := validateRequest(idle); err != nil {
+		t.Errorf("validateRequest - best-effort without cpu want: nil, got %s", err)
+		t.Fail()
+	}
+}
+
+func Test_RegisterRejectsInvalidSpec(t *testing.T) {
+	SetupLedger(1000, 0, PolicyNone)
+	defer SetupLedger(0, 0, PolicyEDF)
+	provider := newAdmissionProvider()
+	defer provider.server.Close()
+
+	spec := admissionSpec("spec-rejected", 2)
+	spec.Resources.Memory = "a lot"
+	body, _ := json.Marshal(spec)
+	r := httptest.NewRequest(http.MethodPost, "/system/functions", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	ReserveAdmissionControl{}.Register(w, r, http.DefaultClient, provider.server.URL, "/system/functions", 0, false)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Register - status want: %d, got %d", http.StatusBadRequest, w.Code)
+		t.FailNow()
+	}
+	out := requests.InvalidDeployment{}
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil || len(out.Errors) != 1 || out.Errors[0].Field != "resources.memory" {
+		t.Errorf("Register - errors want: %s, got %s", "resources.memory", w.Body.String())
+		t.Fail()
+	}
+	if _, reserved := GetLedger().Get("spec-rejected"); reserved {
+		t.Errorf("Register - want: no reservation, got reserved")
+		t.Fail()
+	}
+}
+
+func Test_PackageRequestWritesSpec(t *testing.T) {
+	request := admissionSpec("spec-packaged", 2.5)
+	request.Pool = "batch"
+	request.Lease = "1h"
+	request.Demand = 40
+	request.Schedule = []requests.RealtimeWindow{{Cron: "0 9 * * 1-5", Duration: "8h", Realtime: 10}}
+	request.Labels = &map[string]string{"team": "search", "realtime": "1"}
+
+	r := httptest.NewRequest(http.MethodPost, "/system/functions", nil)
+	rm := ResourceManager{}
+	if err := rm.PackageRequest(request, r); err != nil {
+		t.Errorf("PackageRequest - error want: nil, got %s", err)
+		t.FailNow()
+	}
+	packaged, _ := rm.ParseRequest(r)
+	if want := map[string]string{"team": "search"}; !reflect.DeepEqual(*packaged.Labels, want) {
+		t.Errorf("PackageRequest - labels want: %v, got %v", want, *packaged.Labels)
+		t.Fail()
+	}
+
+	deployed, err := requestFromFunction(requests.Function{
+		Name:        packaged.Service,
+		Labels:      packaged.Labels,
+		Annotations: packaged.Annotations,
+	})
+	if err != nil {
+		t.Errorf("requestFromFunction - error want: nil, got %s", err)
+		t.FailNow()
+	}
+	want := specs.Of(request)
+	if got := specs.Of(deployed); !reflect.DeepEqual(got, want) {
+		t.Errorf("requestFromFunction - spec want: %+v, got %+v", want, got)
+		t.Fail()
+	}
+
+	(*packaged.Annotations)[specs.Annotation] = `{"version":2,"realtime":1}`
+	if _, err := requestFromFunction(requests.Function{Name: packaged.Service, Annotations: packaged.Annotations}); err == nil {
+		t.Errorf("requestFromFunction - unknown version want: error, got nil")
+		t.Fail()
+	}
+}
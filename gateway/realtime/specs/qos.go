@@ -0,0 +1,26 @@
+package specs
+
+import "github.com/ngduchai/faas/gateway/requests"
+
+// PeakRealtime returns the highest rate the function is guaranteed at any time
+func PeakRealtime(r requests.CreateFunctionRequest) float64 {
+	peak := r.Realtime
+	for _, window := range r.Schedule {
+		if window.Realtime > peak {
+			peak = window.Realtime
+		}
+	}
+	return peak
+}
+
+// QoSClass returns the class of service of a function, derived from its
+// guaranteed rate when none is declared
+func QoSClass(qos string, realtime float64) string {
+	if len(qos) > 0 {
+		return qos
+	}
+	if realtime > 0 {
+		return requests.QoSGuaranteed
+	}
+	return requests.QoSBestEffort
+}
@@ -0,0 +1,104 @@
+// Package specs encodes the realtime parameters of a function in the
+// reservation spec annotation, decodes them back and derives its class of
+// service. It is shared by the gateway handlers, the provider plugin and
+// the realtime admission control.
+package specs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ngduchai/faas/gateway/requests"
+)
+
+// Annotation is the annotation holding the reservation spec of a function,
+// in JSON
+const Annotation = "realtime_spec"
+
+// Version is the version of the reservation spec written by the gateway
+const Version = 1
+
+// Of returns the reservation spec of the request
+func Of(r requests.CreateFunctionRequest) requests.ReservationSpec {
+	spec := requests.ReservationSpec{
+		Version:      Version,
+		Realtime:     r.Realtime,
+		Burst:        r.Burst,
+		Timeout:      r.Timeout,
+		CPU:          "0",
+		Memory:       "0",
+		QoS:          QoSClass(r.QoS, PeakRealtime(r)),
+		Pool:         r.Pool,
+		PoolWeight:   r.PoolWeight,
+		MaxQueueWait: r.MaxQueueWait,
+		Schedule:     r.Schedule,
+		Lease:        r.Lease,
+		LeasePolicy:  r.LeasePolicy,
+		LeaseExpires: r.LeaseExpires,
+		Active:       r.Active,
+		Demand:       r.Demand,
+		DemandMemory: r.DemandMemory,
+		Replicas:     r.Replicas,
+	}
+	if r.Resources != nil {
+		spec.CPU = r.Resources.CPU
+		spec.Memory = r.Resources.Memory
+	}
+	return spec
+}
+
+// Apply sets the realtime parameters of the request to those of the spec
+func Apply(s requests.ReservationSpec, r *requests.CreateFunctionRequest) {
+	r.Realtime = s.Realtime
+	r.Burst = s.Burst
+	r.Timeout = s.Timeout
+	r.Resources = &requests.FunctionResources{CPU: s.CPU, Memory: s.Memory}
+	r.QoS = s.QoS
+	r.Pool = s.Pool
+	r.PoolWeight = s.PoolWeight
+	r.MaxQueueWait = s.MaxQueueWait
+	r.Schedule = s.Schedule
+	r.Lease = s.Lease
+	r.LeasePolicy = s.LeasePolicy
+	r.LeaseExpires = s.LeaseExpires
+	r.Active = s.Active
+	r.Demand = s.Demand
+	r.DemandMemory = s.DemandMemory
+	r.Replicas = s.Replicas
+}
+
+// Read decodes the reservation spec held in the annotations, nil when there
+// is none. Unknown fields and versions are errors rather than ignored.
+func Read(annotations *map[string]string) (*requests.ReservationSpec, error) {
+	if annotations == nil {
+		return nil, nil
+	}
+	value, ok := (*annotations)[Annotation]
+	if !ok || len(value) == 0 {
+		return nil, nil
+	}
+	spec := requests.ReservationSpec{}
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %s", Annotation, err)
+	}
+	if spec.Version != Version {
+		return nil, fmt.Errorf("unsupported %s version %d", Annotation, spec.Version)
+	}
+	return &spec, nil
+}
+
+// Write stores the reservation spec of the request in its annotations
+func Write(r *requests.CreateFunctionRequest) error {
+	spec, err := json.Marshal(Of(*r))
+	if err != nil {
+		return err
+	}
+	if r.Annotations == nil {
+		r.Annotations = &map[string]string{}
+	}
+	(*r.Annotations)[Annotation] = string(spec)
+	return nil
+}
@@ -0,0 +1,119 @@
+package realtime
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ngduchai/faas/gateway/requests"
+	"github.com/ngduchai/faas/gateway/scaling"
+)
+
+// MakeRealtimeStatusHandler lists the realtime functions with what they
+// reserved and the state of their queues, or a single one when the route
+// has a name
+func MakeRealtimeStatusHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body interface{}
+		if name, ok := mux.Vars(r)["name"]; ok {
+			status, found := RealtimeStatus(name)
+			if !found {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte("Realtime function " + name + " not found"))
+				return
+			}
+			body = status
+		} else {
+			body = RealtimeStatuses()
+		}
+
+		out, err := json.Marshal(body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(out)
+	}
+}
+
+// RealtimeStatuses returns the status of every function holding a
+// reservation or with a realtime handler, sorted by name
+func RealtimeStatuses() []requests.RealtimeFunction {
+	names := map[string]bool{}
+	functionHandlers.Range(func(key interface{}, value interface{}) bool {
+		names[key.(string)] = true
+		return true
+	})
+	for _, name := range GetLedger().Functions() {
+		names[name] = true
+	}
+
+	statuses := []requests.RealtimeFunction{}
+	for name := range names {
+		if status, found := RealtimeStatus(name); found {
+			statuses = append(statuses, status)
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// RealtimeStatus returns the status of a realtime function, found is false
+// if it has neither a reservation nor a realtime handler
+func RealtimeStatus(functionName string) (requests.RealtimeFunction, bool) {
+	status := requests.RealtimeFunction{Name: functionName}
+
+	reservation, reserved := GetLedger().Get(functionName)
+	if reserved {
+		status.Realtime = reservation.Task.Rate
+		status.Timeout = reservation.Task.Budget
+		status.Pool = reservation.Pool
+		status.Invocation = requests.RealtimeResources{CPU: reservation.Task.CPU, Memory: reservation.Task.Memory}
+		status.Sandbox = requests.RealtimeResources{CPU: reservation.CPU, Memory: reservation.Memory}
+		status.WarmReplicas = reservation.Warm
+		status.Schedule = scheduleOf(reservation)
+	}
+	if l, held := leaseOf(functionName); held {
+		status.Lease = leaseStatus(l)
+	}
+
+	entry, handled := functionHandlers.Load(functionName)
+	if handled {
+		handler := entry.(*InvocationHandler)
+		params := handler.params()
+		if !reserved && params.Realtime <= 0 {
+			return status, false
+		}
+		status.Realtime = params.Realtime
+		status.Burst = params.Burst
+		status.QoS = params.QoS
+		if len(params.Pool) > 0 {
+			status.PoolWeight = params.Weight
+		}
+		status.Timeout = uint64(params.Timeout / time.Millisecond)
+		status.BufferSize = handler.BufferSize
+		status.MaxQueueWait = uint64(params.MaxQueueWait / time.Millisecond)
+
+		handler.sync.Lock()
+		status.SyncQueue = len(handler.syncInvs)
+		status.AsyncQueue = len(handler.asyncInvs)
+		handler.sync.Unlock()
+		handler.AsyncWait.Range(func(key interface{}, value interface{}) bool {
+			status.AsyncSlots++
+			return true
+		})
+	} else if !reserved {
+		return status, false
+	}
+
+	if replicas, hit := scaling.GetScalerInstance().Cache.Get(functionName); hit {
+		status.Replicas = replicas.Replicas
+		status.AvailableReplicas = replicas.AvailableReplicas
+	}
+	return status, true
+}
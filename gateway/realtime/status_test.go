@@ -0,0 +1,86 @@
+package realtime
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/ngduchai/faas/gateway/requests"
+)
+
+func Test_RealtimeStatusHandler(t *testing.T) {
+	SetupLedger(0, 0, PolicyEDF)
+	defer SetupLedger(0, 0, PolicyEDF)
+
+	functionName := "status-rt"
+	request := requests.CreateFunctionRequest{
+		Service:      functionName,
+		Realtime:     4,
+		Burst:        2,
+		Timeout:      500,
+		Pool:         "batch",
+		PoolWeight:   1,
+		MaxQueueWait: 100,
+	}
+	GetLedger().Reserve(functionName, reservationFor(request, 250, 1000))
+	SetFunctionHandler(request)
+	defer RemoveFunctionHandler(functionName)
+	SetFunctionHandler(requests.CreateFunctionRequest{Service: "status-be"})
+	defer RemoveFunctionHandler("status-be")
+
+	entry, _ := functionHandlers.Load(functionName)
+	entry.(*InvocationHandler).AsyncWait.Store("call-1", true)
+
+	handler := MakeRealtimeStatusHandler()
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/system/realtime", nil))
+	statuses := []requests.RealtimeFunction{}
+	if err := json.Unmarshal(w.Body.Bytes(), &statuses); err != nil {
+		t.Errorf("RealtimeStatus - list error want: %s, got %s", "nil", err.Error())
+		t.FailNow()
+	}
+	listed := map[string]bool{}
+	for _, status := range statuses {
+		listed[status.Name] = true
+	}
+	if !listed[functionName] || listed["status-be"] {
+		t.Errorf("RealtimeStatus - list want: %s and not %s, got %+v", functionName, "status-be", statuses)
+		t.FailNow()
+	}
+
+	w = httptest.NewRecorder()
+	r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/system/realtime/"+functionName, nil), map[string]string{"name": functionName})
+	handler(w, r)
+	status := requests.RealtimeFunction{}
+	json.Unmarshal(w.Body.Bytes(), &status)
+	want := requests.RealtimeFunction{
+		Name:         functionName,
+		Realtime:     4,
+		Burst:        2,
+		QoS:          requests.QoSGuaranteed,
+		Pool:         "batch",
+		PoolWeight:   1,
+		Timeout:      500,
+		Invocation:   requests.RealtimeResources{CPU: 250, Memory: 1000},
+		Sandbox:      requests.RealtimeResources{CPU: 500, Memory: 2000},
+		BufferSize:   200,
+		MaxQueueWait: 100,
+		AsyncSlots:   1,
+	}
+	if w.Code != http.StatusOK || !reflect.DeepEqual(status, want) {
+		t.Errorf("RealtimeStatus - want: %+v, got %d %+v", want, w.Code, status)
+		t.Fail()
+	}
+
+	w = httptest.NewRecorder()
+	r = mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/system/realtime/status-be", nil), map[string]string{"name": "status-be"})
+	handler(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("RealtimeStatus - best-effort status want: %d, got %d", http.StatusNotFound, w.Code)
+		t.Fail()
+	}
+}
@@ -0,0 +1,75 @@
+package realtime
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"time"
+)
+
+// warmHeadroom is the share of the replicas of a realtime function kept
+// ready as spares, zero disables the warm pool
+var warmHeadroom float64
+
+// warmPath is invoked on the replicas of a function once they are up so
+// that the first invocations do not pay for a cold start
+var warmPath = "/_/health"
+
+// SetWarmPool keeps headroom times the replicas of every realtime function
+// as spares, warmed by invoking path
+func SetWarmPool(headroom float64, path string) {
+	warmHeadroom = headroom
+	if len(path) > 0 {
+		warmPath = path
+	}
+}
+
+// warmReplicasFor returns how many spare replicas are kept ready for the
+// reservation, on top of the replicas serving its rate
+func warmReplicasFor(res Reservation) uint64 {
+	if warmHeadroom <= 0 || res.Task.Rate <= 0 {
+		return 0
+	}
+	return uint64(math.Ceil(float64(ResourceManager{}.ReplicasFor(res)) * warmHeadroom))
+}
+
+// withWarmPool charges the spare replicas of the warm pool to the
+// reservation, each sized like a replica serving the rate
+func withWarmPool(res Reservation) Reservation {
+	warm := warmReplicasFor(res)
+	if warm == 0 {
+		return res
+	}
+	replicas := int64(ResourceManager{}.ReplicasFor(res))
+	res.Warm = warm
+	res.CPU = res.CPU * (replicas + int64(warm)) / replicas
+	res.Memory = res.Memory * (replicas + int64(warm)) / replicas
+	return res
+}
+
+// warmUp invokes the warm path of the function once per replica, in the
+// background, so that the replicas, spares included, are likely hot before
+// invocations arrive. Warming is best-effort: the invocations go through the
+// provider, which may send several to the same replica and none to another,
+// each gives up after timeout and errors are only logged, a cold replica
+// still serves its invocations.
+func warmUp(client *http.Client, baseURL string, functionName string, replicas uint64, timeout time.Duration) {
+	url := baseURL + "/function/" + functionName + warmPath
+	for i := uint64(0); i < replicas; i++ {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			req, _ := http.NewRequest(http.MethodGet, url, nil)
+			res, err := client.Do(req.WithContext(ctx))
+			if err != nil {
+				log.Printf("Unable to warm up %s: %s", functionName, err)
+				return
+			}
+			res.Body.Close()
+			if res.StatusCode < 200 || res.StatusCode > 299 {
+				log.Printf("Unable to warm up %s: status %d", functionName, res.StatusCode)
+			}
+		}()
+	}
+}
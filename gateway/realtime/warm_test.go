@@ -0,0 +1,82 @@
+package realtime
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ngduchai/faas/gateway/scaling"
+)
+
+func Test_WarmPoolChargedAgainstCapacity(t *testing.T) {
+	functionName := "warm-pool"
+	config := &scaling.GetScalerInstance().Config
+	defer func(concurrency uint) { config.ContainerConcurrency = concurrency }(config.ContainerConcurrency)
+	config.ContainerConcurrency = 1
+	SetWarmPool(0.5, "/_/ready")
+	defer SetWarmPool(0, "/_/health")
+
+	// 40 invocations per second of 100ms need 4 replicas of 1000m, and the
+	// warm pool 2 more
+	request := admissionSpec(functionName, 40)
+	reservation := reservationFor(request, 1000, 0)
+	if reservation.Warm != 2 || reservation.CPU != 6000 {
+		t.Errorf("reservationFor - want: %d warm, %dm, got %d warm, %dm", 2, 6000, reservation.Warm, reservation.CPU)
+		t.Fail()
+	}
+
+	SetupLedger(5000, 0, PolicyNone)
+	defer SetupLedger(0, 0, PolicyEDF)
+	provider := newAdmissionProvider()
+	defer provider.server.Close()
+	serviceQuery := newScaleRecordingServiceQuery(scaling.ServiceQueryResponse{
+		Replicas: 6, AvailableReplicas: 6, Realtime: 40, CPU: 1000, Duration: 100,
+	})
+	config.ServiceQuery = serviceQuery
+	ac := ReserveAdmissionControl{}
+	if code := deploy(ac, provider, http.MethodPost, request); code != http.StatusConflict {
+		t.Errorf("Register - status want: %d, got %d", http.StatusConflict, code)
+		t.FailNow()
+	}
+
+	SetupLedger(6000, 0, PolicyNone)
+	if code := deploy(ac, provider, http.MethodPost, request); code != http.StatusAccepted {
+		t.Errorf("Register - status want: %d, got %d", http.StatusAccepted, code)
+		t.FailNow()
+	}
+	defer undeploy(ac, provider, functionName)
+	if serviceQuery.scaleRequests() != 1 || (*serviceQuery.scaled)[0] != 6 {
+		t.Errorf("Register - scaled want: %v, got %v", []uint64{6}, *serviceQuery.scaled)
+		t.Fail()
+	}
+	if deployed := provider.lastDeployed(); deployed.Requests.CPU != "1000m" {
+		t.Errorf("Register - replica cpu want: %s, got %s", "1000m", deployed.Requests.CPU)
+		t.Fail()
+	}
+	// Replicas are warmed in the background
+	warmed := 0
+	for deadline := time.Now().Add(time.Second); warmed < 6 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		warmed = 0
+		for _, path := range provider.invokedPaths() {
+			if path == "/function/"+functionName+"/_/ready" {
+				warmed++
+			}
+		}
+	}
+	if warmed != 6 {
+		t.Errorf("Register - replicas warmed want: %d, got %d", 6, warmed)
+		t.Fail()
+	}
+	if status, _ := RealtimeStatus(functionName); status.WarmReplicas != 2 {
+		t.Errorf("RealtimeStatus - warm replicas want: %d, got %d", 2, status.WarmReplicas)
+		t.Fail()
+	}
+}
+
+func Test_WarmPoolDisabled(t *testing.T) {
+	request := admissionSpec("warm-none", 40)
+	if reservation := reservationFor(request, 1000, 0); reservation.Warm != 0 || reservation.CPU != 4000 {
+		t.Errorf("reservationFor - want: no warm pool, %dm, got %d warm, %dm", 4000, reservation.Warm, reservation.CPU)
+		t.Fail()
+	}
+}
@@ -61,6 +61,340 @@ type CreateFunctionRequest struct {
 
 	// Function duration in second
 	Timeout uint64 `json:"timeout"`
+
+	// Burst is the number of invocations admitted at once on top of the
+	// guaranteed rate
+	Burst float64 `json:"burst,omitempty"`
+
+	// QoS is the class of service of the function: guaranteed, burstable
+	// or best-effort. Functions with a guaranteed rate are guaranteed and
+	// the others best-effort when it is not set.
+	QoS string `json:"qos,omitempty"`
+
+	// Pool names the reservation pool the function shares with other
+	// realtime functions
+	Pool string `json:"pool,omitempty"`
+
+	// PoolWeight is the share of the pool rate left unreserved that the
+	// function gets relative to the other functions of a declared pool,
+	// 1 when not set
+	PoolWeight float64 `json:"poolWeight,omitempty"`
+
+	// MaxQueueWait bounds the time (in ms) an invocation waits for its
+	// turn before the caller is turned away
+	MaxQueueWait uint64 `json:"maxQueueWait,omitempty"`
+
+	// Schedule raises or lowers the guaranteed rate during time windows,
+	// Realtime is guaranteed outside of them
+	Schedule []RealtimeWindow `json:"schedule,omitempty"`
+
+	// Lease is how long (e.g. 24h) the reservation is held unless renewed,
+	// it is held until the function is removed when not set
+	Lease string `json:"lease,omitempty"`
+
+	// LeasePolicy is what happens to the function when its lease expires:
+	// downgrade (the default) or delete
+	LeasePolicy string `json:"leasePolicy,omitempty"`
+
+	// LeaseExpires is the time, in RFC 3339, the lease expires unless
+	// renewed, set by the gateway
+	LeaseExpires string `json:"-"`
+
+	// Active is the rate guaranteed when the sandbox was sized, which
+	// differs from Realtime while a window of the schedule is open, set by
+	// the gateway
+	Active float64 `json:"-"`
+
+	// Demand is the measured duration (in ms) the reservation is sized for
+	// instead of the timeout, set by the gateway
+	Demand uint64 `json:"-"`
+
+	// DemandMemory is the observed memory (in bytes) of an invocation the
+	// reservation is sized for instead of the declared memory, set by the
+	// gateway
+	DemandMemory int64 `json:"-"`
+
+	// Replicas is the number of replicas the reservation is split among,
+	// set by the gateway
+	Replicas uint64 `json:"-"`
+}
+
+// RealtimeWindow is a time window during which a function is guaranteed
+// another rate. It recurs at the times matched by a cron expression
+// (minute hour day-of-month month day-of-week) for the given duration, or
+// spans from an explicit start to an end in RFC 3339.
+type RealtimeWindow struct {
+	Cron     string `json:"cron,omitempty"`
+	Duration string `json:"duration,omitempty"`
+
+	// TimeZone the cron expression is evaluated in, UTC when not set
+	TimeZone string `json:"timeZone,omitempty"`
+
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+
+	// Realtime is the rate guaranteed during the window
+	Realtime float64 `json:"realtime"`
+}
+
+// Classes of service of a function
+const (
+	// QoSGuaranteed functions are dispatched at their guaranteed rate and
+	// no faster
+	QoSGuaranteed = "guaranteed"
+	// QoSBurstable functions are guaranteed a base rate, invocations above
+	// it run on whatever capacity is left
+	QoSBurstable = "burstable"
+	// QoSBestEffort functions have no guarantee
+	QoSBestEffort = "best-effort"
+)
+
+// Policies applied when the lease of a function expires
+const (
+	// LeaseDowngrade redeploys the function as best-effort
+	LeaseDowngrade = "downgrade"
+	// LeaseDelete removes the function
+	LeaseDelete = "delete"
+)
+
+// RealtimeLease is the lease a realtime function holds its reservation under
+type RealtimeLease struct {
+	Duration string `json:"duration"`
+	Policy   string `json:"policy"`
+
+	// Expires is the time, in RFC 3339, the lease expires unless renewed
+	Expires string `json:"expires"`
+}
+
+// ReservationSpec holds the realtime parameters of a deployed function. It
+// is written by the gateway in the realtime_spec annotation and read back
+// as is, so a function keeps exactly the parameters it was deployed with.
+type ReservationSpec struct {
+	Version  int     `json:"version"`
+	Realtime float64 `json:"realtime"`
+	Burst    float64 `json:"burst,omitempty"`
+
+	// Timeout is the duration (in ms) of an invocation
+	Timeout uint64 `json:"timeout"`
+
+	// CPU and Memory of an invocation, as Kubernetes quantities
+	CPU    string `json:"cpu"`
+	Memory string `json:"memory"`
+
+	// QoS is the class of service, resolved from the rate if not declared
+	QoS          string           `json:"qos"`
+	Pool         string           `json:"pool,omitempty"`
+	PoolWeight   float64          `json:"poolWeight,omitempty"`
+	MaxQueueWait uint64           `json:"maxQueueWait,omitempty"`
+	Schedule     []RealtimeWindow `json:"schedule,omitempty"`
+	Lease        string           `json:"lease,omitempty"`
+	LeasePolicy  string           `json:"leasePolicy,omitempty"`
+
+	// LeaseExpires is the time, in RFC 3339, the lease expires unless
+	// renewed, set by the gateway so that it survives a restart
+	LeaseExpires string `json:"leaseExpires,omitempty"`
+
+	// Active is the rate the sandbox was sized for, set by the gateway so
+	// that a schedule is switched after a restart
+	Active float64 `json:"active,omitempty"`
+
+	// Demand (in ms) and DemandMemory (in bytes) the reservation is sized
+	// for, set by the gateway
+	Demand       uint64 `json:"demand,omitempty"`
+	DemandMemory int64  `json:"demandMemory,omitempty"`
+
+	// Replicas the reservation is split among, set by the gateway
+	Replicas uint64 `json:"replicas,omitempty"`
+}
+
+// FieldError is an invalid field of a deployment, Field is its path in the
+// request such as resources.cpu or schedule[1].realtime
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// InvalidDeployment is returned when the realtime parameters of a
+// deployment are invalid
+type InvalidDeployment struct {
+	Function string       `json:"function"`
+	Errors   []FieldError `json:"errors"`
+}
+
+// RealtimeFunction exported for system/realtime endpoint
+type RealtimeFunction struct {
+	Name string `json:"name"`
+
+	// Realtime is the guaranteed invocation rate
+	Realtime float64 `json:"realtime"`
+	Burst    float64 `json:"burst"`
+	QoS      string  `json:"qos"`
+	Pool     string  `json:"pool,omitempty"`
+
+	// PoolWeight is the weight of the function in its pool
+	PoolWeight float64 `json:"poolWeight,omitempty"`
+
+	// Schedule of the guaranteed rate, Realtime is the rate guaranteed now
+	Schedule []RealtimeWindow `json:"schedule,omitempty"`
+
+	Lease *RealtimeLease `json:"lease,omitempty"`
+
+	// Timeout is the declared duration (in ms) of an invocation
+	Timeout uint64 `json:"timeout"`
+
+	// Invocation holds the CPU (millicores) and memory (bytes) of a single
+	// invocation and Sandbox the total reserved to sustain the rate
+	Invocation RealtimeResources `json:"invocation"`
+	Sandbox    RealtimeResources `json:"sandbox"`
+
+	// WarmReplicas is the number of spare replicas kept ready, charged in
+	// Sandbox
+	WarmReplicas uint64 `json:"warmReplicas,omitempty"`
+
+	BufferSize   int    `json:"bufferSize"`
+	MaxQueueWait uint64 `json:"maxQueueWait"`
+	SyncQueue    int    `json:"syncQueue"`
+	AsyncQueue   int    `json:"asyncQueue"`
+
+	// AsyncSlots counts asynchronous invocations accepted but not yet
+	// dequeued from the async queue
+	AsyncSlots int `json:"asyncSlots"`
+
+	Replicas          uint64 `json:"replicas"`
+	AvailableReplicas uint64 `json:"availableReplicas"`
+}
+
+// Actions recorded in the realtime event log
+const (
+	// EventDegrade scales a best-effort function down to make room for a
+	// realtime function
+	EventDegrade = "degrade"
+	// EventRestore gives a degraded function its replicas back
+	EventRestore = "restore"
+	// EventDegradeFailed records a degradation or restoration that could
+	// not be applied
+	EventDegradeFailed = "degrade_failed"
+)
+
+// RealtimeEvent is an action the gateway took on a function on its own
+type RealtimeEvent struct {
+	// Time is when the action was taken, in RFC 3339
+	Time     string `json:"time"`
+	Action   string `json:"action"`
+	Function string `json:"function"`
+
+	// PreviousReplicas and Replicas are the replicas of the function
+	// before and after the action
+	PreviousReplicas uint64 `json:"previousReplicas"`
+	Replicas         uint64 `json:"replicas"`
+
+	Reason string `json:"reason,omitempty"`
+}
+
+// RealtimePool is a reservation pool whose budget is shared by the realtime
+// functions attached to it
+type RealtimePool struct {
+	Name string `json:"name"`
+
+	// Realtime is the invocation rate and Resources the CPU and memory
+	// shared by the functions of the pool
+	Realtime  float64           `json:"realtime"`
+	Resources FunctionResources `json:"resources"`
+
+	// Reserved is the rate and Committed the resources the functions of the
+	// pool hold, set by the gateway
+	Reserved  float64              `json:"reserved"`
+	Committed RealtimeResources    `json:"committed"`
+	Members   []RealtimePoolMember `json:"members"`
+}
+
+// RealtimePoolMember is a function attached to a reservation pool
+type RealtimePoolMember struct {
+	Name     string  `json:"name"`
+	Realtime float64 `json:"realtime"`
+	Weight   float64 `json:"weight"`
+
+	// Share is the rate left unreserved by the pool the function
+	// dispatches at on top of its own
+	Share float64 `json:"share"`
+}
+
+// RealtimeRenegotiation changes the guaranteed rate of a deployed function
+// and optionally its timeout and resources
+type RealtimeRenegotiation struct {
+	Realtime  *float64           `json:"realtime"`
+	Timeout   uint64             `json:"timeout,omitempty"`
+	Resources *FunctionResources `json:"resources,omitempty"`
+}
+
+// ReservationRejection is returned when a realtime function cannot be
+// admitted, along with the closest alternatives that would be
+type ReservationRejection struct {
+	Message  string `json:"message"`
+	Function string `json:"function"`
+
+	// Reason is the constraint which rejected the reservation: capacity,
+	// pool or schedulability
+	Reason    string        `json:"reason"`
+	Requested RealtimeOffer `json:"requested"`
+
+	// At is the time, in RFC 3339, a window of the schedule does not fit,
+	// empty if the reservation does not fit now
+	At string `json:"at,omitempty"`
+
+	// AtRequestedSize holds the highest admissible rate for invocations of
+	// the requested size and AtRequestedRate the largest admissible
+	// invocation size at the requested rate
+	AtRequestedSize RealtimeOffer `json:"atRequestedSize"`
+	AtRequestedRate RealtimeOffer `json:"atRequestedRate"`
+}
+
+// RealtimeOffer is a rate and invocation size, in the form accepted by a deployment
+type RealtimeOffer struct {
+	Realtime  float64           `json:"realtime"`
+	Resources FunctionResources `json:"resources"`
+}
+
+// RealtimeRecommendation compares the declared size of a realtime function
+// with what its invocations were observed to use
+type RealtimeRecommendation struct {
+	Function string `json:"function"`
+
+	// DurationSamples and MemorySamples count the invocations observed
+	DurationSamples int `json:"durationSamples"`
+	MemorySamples   int `json:"memorySamples"`
+
+	Declared RealtimeSize        `json:"declared"`
+	Observed RealtimeObservation `json:"observed"`
+
+	// Recommended is the observed p99 with some headroom, CPU is not
+	// observed and kept as declared
+	Recommended RealtimeSize `json:"recommended"`
+
+	// RightSized tells whether the reservation follows the recommendation
+	RightSized bool `json:"rightSized"`
+}
+
+// RealtimeSize is the timeout (in ms), CPU (millicores) and memory (bytes) of an invocation
+type RealtimeSize struct {
+	Timeout uint64 `json:"timeout"`
+	CPU     int64  `json:"cpu"`
+	Memory  int64  `json:"memory"`
+}
+
+// RealtimeObservation holds percentiles of the duration (in ms) and peak
+// memory (in bytes) of the observed invocations
+type RealtimeObservation struct {
+	DurationP50 float64 `json:"durationP50"`
+	DurationP99 float64 `json:"durationP99"`
+	MemoryP50   int64   `json:"memoryP50"`
+	MemoryP99   int64   `json:"memoryP99"`
+}
+
+// RealtimeResources CPU in millicores and memory in bytes
+type RealtimeResources struct {
+	CPU    int64 `json:"cpu"`
+	Memory int64 `json:"memory"`
 }
 
 // FunctionResources Memory and CPU
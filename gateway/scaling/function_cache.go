@@ -4,7 +4,6 @@
 package scaling
 
 import (
-	"log"
 	"sync"
 	"time"
 )
@@ -14,23 +13,9 @@ import (
 type FunctionMeta struct {
 	LastRefresh          time.Time
 	ServiceQueryResponse ServiceQueryResponse
-	/*
-		Because invocation requests are requested by remote clients, fast rate requests
-		may experience jitter thus, we need the smoothness parameters to overcome the
-		problem. The solution is follows: The smoothness could be varied between
-			    -SmoothnessBound <= Smoothness <= +SmoothnessBound
-		Everytime a request appear, we calculate the interval between its arrival and
-		the most recent request, if
-		    - the interval >= invocation threshold:
-			accept the request
-			then add the exceeded quantity to the smoothless
-		    - the interval < invocation threshold:
-			subtract the gap of [invocation threshold - inteval] from the
-			smoothness. If the smoothness goes below the lowerbound, skip the
-			request. Otherwise, accept
-	*/
-
-	Smoothness float64
+	// Bucket paces invocations of realtime functions to their guaranteed
+	// rate, absorbing jitter of remote clients up to the configured burst
+	Bucket *TokenBucket
 }
 
 // Expired find out whether the cache item has expired with
@@ -52,10 +37,7 @@ func (fc *FunctionCache) Set(functionName string, serviceQueryResponse ServiceQu
 	defer fc.Sync.Unlock()
 
 	if _, exists := fc.Cache[functionName]; !exists {
-		fc.Cache[functionName] = &FunctionMeta{Smoothness: 0.0}
-	} else {
-		//serviceQueryResponse.PastAllocations = fc.Cache[functionName].ServiceQueryResponse.PastAllocations
-		serviceQueryResponse.PastAllocation = fc.Cache[functionName].ServiceQueryResponse.PastAllocation
+		fc.Cache[functionName] = &FunctionMeta{}
 	}
 
 	fc.Cache[functionName].LastRefresh = time.Now()
@@ -82,60 +64,26 @@ func (fc *FunctionCache) Get(functionName string) (ServiceQueryResponse, bool) {
 	return replicas, hit
 }
 
-// Update Allcation list
+// UpdateInvocation admits an invocation if it fits the token bucket of the
+// function, the returned duration is the wait until the next token
 func (fc *FunctionCache) UpdateInvocation(functionName string, invokeTime time.Time) (uint64, time.Duration, bool) {
 	totalInvocation := uint64(0)
-	gapLength := time.Since(invokeTime)
-	//hit := false
+	gapLength := time.Duration(0)
 	added := false
-	//fc.Sync.RLock()
-	//defer fc.Sync.RUnlock()
 	fc.Sync.Lock()
 	defer fc.Sync.Unlock()
 
-	//log.Printf("Check function realtime for %s", functionName)
-
-	/* Because request can experience jitter so we need to add */
-
 	if val, exists := fc.Cache[functionName]; exists {
-		//hit = !val.Expired(fc.Expiry)
-		/*
-			allocations := &val.ServiceQueryResponse.PastAllocations
-			for allocations.Len() > 0 {
-				pastInvocationTime := allocations.Front()
-				diff := invokeTime.Sub(pastInvocationTime.Value.(time.Time))
-				log.Printf("%f %f", diff.Seconds(), 1.0/val.ServiceQueryResponse.Realtime)
-				//if diff.Seconds() > 1.0 {
-				if diff.Seconds() > (1.0 / val.ServiceQueryResponse.Realtime) {
-					allocations.Remove(pastInvocationTime)
-					if allocations.Len() == 0 {
-					}
-				} else {
-					break
-				}
-			}
-			totalInvocation = uint64(allocations.Len())
-			//if val.ServiceQueryResponse.Realtime > float64(totalInvocation) {
-			if 1.0 > float64(totalInvocation) {
-				allocations.PushBack(invokeTime)
-				added = true
-			}
-			gapLength = invokeTime.Sub(allocations.Front().Value.(time.Time))
-		*/
-		intervalBound := 1.0 / val.ServiceQueryResponse.Realtime
-		gapLength := invokeTime.Sub(val.ServiceQueryResponse.PastAllocation)
-		diff := gapLength.Seconds() - intervalBound
-		if diff >= 0 || val.Smoothness+diff > -1.5*intervalBound {
-			added = true
-			val.ServiceQueryResponse.PastAllocation = invokeTime
-			val.Smoothness += diff
-			if val.Smoothness > 1.5*intervalBound {
-				val.Smoothness = 1.5 * intervalBound
-			}
+		rate := val.ServiceQueryResponse.Realtime
+		burst := val.ServiceQueryResponse.Burst
+		if val.Bucket == nil {
+			val.Bucket = NewTokenBucket(rate, burst)
+		} else if val.Bucket.Rate != rate || val.Bucket.Burst != burst {
+			val.Bucket.SetRate(rate, burst)
 		}
-		log.Printf("%f %f %f", diff, intervalBound, val.Smoothness)
+		added = val.Bucket.Take(invokeTime)
+		gapLength = val.Bucket.Wait(invokeTime)
 	}
 
-	//return totalInvocation, gapLength, hit, added
 	return totalInvocation, gapLength, added
 }
@@ -3,10 +3,7 @@
 
 package scaling
 
-import (
-	//"container/list"
-	"time"
-)
+import "github.com/ngduchai/faas/gateway/requests"
 
 // ServiceQuery provides interface for replica querying/setting
 type ServiceQuery interface {
@@ -25,6 +22,10 @@ type ServiceQueryResponse struct {
 	CPU               int64
 	Memory            int64
 	Duration          uint64
-	//PastAllocations   list.List
-	PastAllocation time.Time
+	Burst             float64
+	QoS               string
+
+	// Spec is the reservation spec the function was deployed with, nil if
+	// it was deployed before the gateway kept one
+	Spec *requests.ReservationSpec
 }
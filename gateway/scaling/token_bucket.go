@@ -0,0 +1,135 @@
+package scaling
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// TokenBucket enforces a (sigma, rho) arrival envelope: tokens are added at
+// Rate per second up to Burst, and every admitted invocation takes one.
+// Invocations within the envelope can therefore be admitted immediately
+// while a sustained excess is spread out at Rate.
+//
+// Tokens added to a full bucket are not lost but kept apart, up to Burst, as
+// spare capacity that others may borrow until the bucket is used again.
+type TokenBucket struct {
+	Rate   float64
+	Burst  float64
+	tokens float64
+	spare  float64
+	last   time.Time
+	sync   sync.Mutex
+}
+
+// NewTokenBucket creates a full bucket. A burst below one is raised to one
+// so that the bucket can always admit a single invocation.
+func NewTokenBucket(rate float64, burst float64) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{
+		Rate:   rate,
+		Burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// SetRate changes the envelope, keeping the tokens already accumulated
+func (tb *TokenBucket) SetRate(rate float64, burst float64) {
+	tb.sync.Lock()
+	defer tb.sync.Unlock()
+
+	tb.refill(time.Now())
+	if burst < 1 {
+		burst = 1
+	}
+	tb.Rate = rate
+	tb.Burst = burst
+	if tb.tokens > burst {
+		tb.tokens = burst
+	}
+	if tb.spare > burst {
+		tb.spare = burst
+	}
+}
+
+// Take removes a token if one is available at the given time
+func (tb *TokenBucket) Take(now time.Time) bool {
+	tb.sync.Lock()
+	defer tb.sync.Unlock()
+
+	tb.refill(now)
+	if tb.tokens >= 1 {
+		tb.tokens--
+		// The owner is active again, what it left unused is no longer spare
+		tb.spare = 0
+		return true
+	}
+	return false
+}
+
+// TakeSpare removes a spare token if one is available at the given time
+func (tb *TokenBucket) TakeSpare(now time.Time) bool {
+	tb.sync.Lock()
+	defer tb.sync.Unlock()
+
+	tb.refill(now)
+	if tb.spare >= 1 {
+		tb.spare--
+		return true
+	}
+	return false
+}
+
+// SpareWait returns how long it takes from the given time until a spare
+// token is available, provided the bucket is not used meanwhile
+func (tb *TokenBucket) SpareWait(now time.Time) time.Duration {
+	tb.sync.Lock()
+	defer tb.sync.Unlock()
+
+	tb.refill(now)
+	if tb.spare >= 1 {
+		return 0
+	}
+	if tb.Rate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration((tb.Burst - tb.tokens + 1 - tb.spare) / tb.Rate * float64(time.Second))
+}
+
+// Wait returns how long it takes from the given time until a token is available
+func (tb *TokenBucket) Wait(now time.Time) time.Duration {
+	tb.sync.Lock()
+	defer tb.sync.Unlock()
+
+	tb.refill(now)
+	if tb.tokens >= 1 {
+		return 0
+	}
+	if tb.Rate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration((1 - tb.tokens) / tb.Rate * float64(time.Second))
+}
+
+// Tokens returns the tokens available at the given time
+func (tb *TokenBucket) Tokens(now time.Time) float64 {
+	tb.sync.Lock()
+	defer tb.sync.Unlock()
+
+	tb.refill(now)
+	return tb.tokens
+}
+
+func (tb *TokenBucket) refill(now time.Time) {
+	if now.After(tb.last) {
+		tb.tokens += now.Sub(tb.last).Seconds() * tb.Rate
+		if tb.tokens > tb.Burst {
+			tb.spare = math.Min(tb.spare+tb.tokens-tb.Burst, tb.Burst)
+			tb.tokens = tb.Burst
+		}
+		tb.last = now
+	}
+}
@@ -0,0 +1,133 @@
+package scaling
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_TokenBucketAdmitsBurst(t *testing.T) {
+	bucket := NewTokenBucket(1, 3)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if !bucket.Take(now) {
+			t.Errorf("Take - invocation %d within burst, want: %v, got %v", i, true, false)
+			t.Fail()
+		}
+	}
+	if bucket.Take(now) {
+		t.Errorf("Take - invocation beyond burst, want: %v, got %v", false, true)
+		t.Fail()
+	}
+}
+
+func Test_TokenBucketRefillsAtRate(t *testing.T) {
+	bucket := NewTokenBucket(10, 1)
+	now := time.Now()
+	bucket.Take(now)
+
+	wait := bucket.Wait(now)
+	if wait != 100*time.Millisecond {
+		t.Errorf("Wait - want: %s, got %s", 100*time.Millisecond, wait)
+		t.Fail()
+	}
+	if bucket.Take(now.Add(50 * time.Millisecond)) {
+		t.Errorf("Take - before refill, want: %v, got %v", false, true)
+		t.Fail()
+	}
+	if !bucket.Take(now.Add(100 * time.Millisecond)) {
+		t.Errorf("Take - after refill, want: %v, got %v", true, false)
+		t.Fail()
+	}
+
+	// Idle time never accumulates more than the burst
+	if tokens := bucket.Tokens(now.Add(time.Hour)); tokens != 1 {
+		t.Errorf("Tokens - want: %f, got %f", 1.0, tokens)
+		t.Fail()
+	}
+}
+
+func Test_TokenBucketSetRate(t *testing.T) {
+	bucket := NewTokenBucket(1, 5)
+	bucket.SetRate(2, 2)
+
+	if tokens := bucket.Tokens(time.Now()); tokens != 2 {
+		t.Errorf("SetRate - tokens capped to burst, want: %f, got %f", 2.0, tokens)
+		t.Fail()
+	}
+
+	bucket.SetRate(2, 0)
+	if bucket.Burst != 1 {
+		t.Errorf("SetRate - minimal burst, want: %f, got %f", 1.0, bucket.Burst)
+		t.Fail()
+	}
+}
+
+func Test_TokenBucketKeepsSpareCapacity(t *testing.T) {
+	bucket := NewTokenBucket(10, 2)
+	now := time.Now()
+
+	if wait := bucket.SpareWait(now); wait < 90*time.Millisecond || wait > 100*time.Millisecond {
+		t.Errorf("SpareWait - full bucket want: ~%s, got %s", 100*time.Millisecond, wait)
+		t.Fail()
+	}
+	if bucket.TakeSpare(now.Add(50 * time.Millisecond)) {
+		t.Errorf("TakeSpare - before overflow, want: %v, got %v", false, true)
+		t.Fail()
+	}
+
+	// Spare tokens are capped at the burst
+	later := now.Add(time.Second)
+	for i := 0; i < 2; i++ {
+		if !bucket.TakeSpare(later) {
+			t.Errorf("TakeSpare - spare token %d, want: %v, got %v", i, true, false)
+			t.Fail()
+		}
+	}
+	if bucket.TakeSpare(later) {
+		t.Errorf("TakeSpare - beyond burst, want: %v, got %v", false, true)
+		t.Fail()
+	}
+	// Borrowing leaves the tokens of the owner alone
+	if tokens := bucket.Tokens(later); tokens != 2 {
+		t.Errorf("Tokens - want: %v, got %v", 2, tokens)
+		t.Fail()
+	}
+
+	// Using the bucket gives up the spare capacity
+	later = later.Add(time.Second)
+	bucket.Take(later)
+	if bucket.TakeSpare(later) {
+		t.Errorf("TakeSpare - after Take, want: %v, got %v", false, true)
+		t.Fail()
+	}
+	if wait := bucket.SpareWait(later); wait != 200*time.Millisecond {
+		t.Errorf("SpareWait - want: %s, got %s", 200*time.Millisecond, wait)
+		t.Fail()
+	}
+}
+
+func Test_UpdateInvocationUsesTokenBucket(t *testing.T) {
+	fnName := "echo"
+
+	cache := FunctionCache{
+		Cache:  make(map[string]*FunctionMeta),
+		Expiry: time.Millisecond * 500,
+	}
+
+	cache.Set(fnName, ServiceQueryResponse{Realtime: 1, Burst: 2})
+	now := time.Now()
+
+	_, _, first := cache.UpdateInvocation(fnName, now)
+	_, _, second := cache.UpdateInvocation(fnName, now)
+	_, wait, third := cache.UpdateInvocation(fnName, now)
+
+	if !first || !second || third {
+		t.Errorf("UpdateInvocation - want: %v %v %v, got %v %v %v", true, true, false, first, second, third)
+		t.Fail()
+	}
+	if wait != time.Second {
+		t.Errorf("UpdateInvocation - wait want: %s, got %s", time.Second, wait)
+		t.Fail()
+	}
+}
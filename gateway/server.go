@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"path/filepath"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -73,7 +74,7 @@ func main() {
 		ServiceMetrics: metricsOptions.ServiceMetrics,
 	}
 
-	functionNotifiers := []handlers.HTTPNotifier{loggingNotifier, prometheusNotifier}
+	functionNotifiers := []handlers.HTTPNotifier{loggingNotifier, prometheusNotifier, realtime.DemandNotifier{}}
 	forwardingNotifiers := []handlers.HTTPNotifier{loggingNotifier, prometheusServiceNotifier}
 
 	urlResolver := handlers.SingleHostBaseURLResolver{BaseURL: config.FunctionsProviderURL.String()}
@@ -98,11 +99,58 @@ func main() {
 		FunctionPollInterval: time.Millisecond * 50,
 		CacheExpiry:          time.Second * 5,
 		ServiceQuery:         alertHandler,
-		ContainerConcurrency: 100,
+		ContainerConcurrency: config.RealtimeContainerConcurrency,
 	}
 	scaling.SetupRealtime(realtimeHandleConfig)
 
-	ac := realtime.ReserveAdmissionControl{}
+	capacityQuery, _ := alertHandler.(realtime.CapacityQuery)
+	totalCPU, totalMemory, capacityErr := realtime.ResolveCapacity(config.RealtimeCPUCapacity, config.RealtimeMemoryCapacity, capacityQuery)
+	if capacityErr != nil {
+		log.Fatalln("Invalid realtime capacity.", capacityErr)
+	}
+	log.Printf("Realtime capacity: CPU: %dm Memory: %d", totalCPU, totalMemory)
+	if !realtime.ValidPolicy(config.RealtimeSchedulability) {
+		log.Fatalf("Unknown realtime schedulability policy: %s", config.RealtimeSchedulability)
+	}
+	realtime.SetupLedger(totalCPU, totalMemory, config.RealtimeSchedulability)
+	// An invocation released after the write timeout could not be answered anyway
+	realtime.SetDefaultMaxQueueWait(config.WriteTimeout)
+	realtime.GetScheduler().SetBackfill(config.RealtimeBackfill)
+	realtime.SetMetrics(metricsOptions)
+	exporter.SetRealtimeStats(realtime.Stats)
+	exporter.SetConcurrencyStats(realtime.ConcurrencyStats)
+	realtime.SetConcurrencyQueue(config.RealtimeConcurrencyQueue)
+	realtime.SetWarmPool(config.RealtimeWarmHeadroom, config.RealtimeWarmPath)
+
+	// Pools are declared again before the reconciler restores their
+	// functions, which also hold the leases renewed before the restart
+	if len(config.RealtimeJournalDir) > 0 {
+		pools, poolsErr := realtime.NewFilePoolStore(filepath.Join(config.RealtimeJournalDir, "pools"))
+		if poolsErr != nil {
+			log.Fatalln("Invalid realtime pool store.", poolsErr)
+		}
+		realtime.SetupPoolStore(pools)
+		leases, leasesErr := realtime.NewFileLeaseStore(filepath.Join(config.RealtimeJournalDir, "leases"))
+		if leasesErr != nil {
+			log.Fatalln("Invalid realtime lease store.", leasesErr)
+		}
+		realtime.SetupLeaseStore(leases)
+	}
+	if poolsErr := realtime.RestorePools(); poolsErr != nil {
+		log.Printf("Unable to restore realtime pools: %s", poolsErr)
+	}
+
+	// Rebuild realtime handlers of functions deployed before this gateway started
+	reconciler := realtime.NewReconciler(*config.FunctionsProviderURL, credentials)
+	reconciler.Start(config.RealtimeReconcileInterval)
+
+	ac, admissionErr := realtime.NewAdmissionControl(config.RealtimeAdmission, realtime.AdmissionOptions{
+		OverbookingRatio: config.RealtimeOverbookingRatio,
+	})
+	if admissionErr != nil {
+		log.Fatalln("Invalid realtime admission control.", admissionErr)
+	}
+	log.Printf("Realtime admission control: %s", config.RealtimeAdmission)
 
 	faasHandlers.RoutelessProxy = handlers.MakeForwardingProxyHandler(reverseProxy, forwardingNotifiers, urlResolver, nilURLTransformer)
 	faasHandlers.ListFunctions = handlers.MakeForwardingProxyHandler(reverseProxy, forwardingNotifiers, urlResolver, nilURLTransformer)
@@ -115,10 +163,48 @@ func main() {
 	faasHandlers.QueryFunction = handlers.MakeForwardingProxyHandler(reverseProxy, forwardingNotifiers, urlResolver, nilURLTransformer)
 	faasHandlers.InfoHandler = handlers.MakeInfoHandler(handlers.MakeForwardingProxyHandler(reverseProxy, forwardingNotifiers, urlResolver, nilURLTransformer))
 	faasHandlers.SecretHandler = handlers.MakeForwardingProxyHandler(reverseProxy, forwardingNotifiers, urlResolver, nilURLTransformer)
+	faasHandlers.RealtimeStatus = realtime.MakeRealtimeStatusHandler()
+	// Roll back deployments and updates interrupted by a previous run
+	if len(config.RealtimeJournalDir) > 0 {
+		journal, journalErr := realtime.NewFileJournal(config.RealtimeJournalDir)
+		if journalErr != nil {
+			log.Fatalln("Invalid realtime journal.", journalErr)
+		}
+		realtime.SetupJournal(journal)
+	} else if config.RealtimeAdmission != realtime.AdmissionPassthrough {
+		log.Printf("WARNING: realtime_journal_dir is not set, deployments and updates interrupted by a crash of the gateway will not be rolled back, and pools, lease renewals and degraded functions are not kept across restarts")
+	}
+	realtime.NewSagaRecovery(realtime.GetJournal(), reverseProxy, urlResolver).Start(config.RealtimeReconcileInterval)
+	realtime.SetupRenegotiator(realtime.NewRenegotiator(ac, reverseProxy, urlResolver))
+	faasHandlers.RealtimeRenegotiate = realtime.MakeRealtimeRenegotiateHandler()
+	realtime.NewSwitcher().Start()
+	faasHandlers.RealtimeLease = realtime.MakeRealtimeLeaseHandler()
+	realtime.NewLeaseExpirer().Start(config.RealtimeLeaseInterval)
+
+	faasHandlers.RealtimeRecommendation = realtime.MakeRealtimeRecommendationHandler()
+	faasHandlers.RealtimePools = realtime.MakeRealtimePoolsHandler()
+	faasHandlers.RealtimeEvents = realtime.MakeRealtimeEventsHandler()
+
+	realtime.SetupDegrader(config.RealtimeDegradeOrder)
+	if len(config.RealtimeJournalDir) > 0 {
+		if degradedErr := realtime.GetDegrader().Keep(filepath.Join(config.RealtimeJournalDir, "degraded")); degradedErr != nil {
+			log.Fatalln("Invalid realtime degradation store.", degradedErr)
+		}
+	}
+	if realtime.GetDegrader().Enabled() {
+		realtime.GetDegrader().Start(config.RealtimeReconcileInterval)
+	}
+
+	realtime.SetupDemandTracker(config.RealtimeDemandPercentile, config.RealtimeDemandSizing)
+	realtime.GetDemandTracker().RightSize = config.RealtimeRightSize
+	realtime.GetDemandTracker().Floor = config.RealtimeRightSizeFloor
+	if config.RealtimeDemandSizing || config.RealtimeRightSize {
+		realtime.NewResizer(realtime.GetDemandTracker()).Start(config.RealtimeResizeInterval)
+	}
 
 	//alertHandler := plugin.NewExternalServiceQuery(*config.FunctionsProviderURL, credentials)
 	faasHandlers.Alert = handlers.MakeNotifierWrapper(
-		handlers.MakeAlertHandler(alertHandler),
+		handlers.MakeAlertHandler(realtime.DegradeAwareServiceQuery{ServiceQuery: alertHandler}),
 		forwardingNotifiers,
 	)
 
@@ -176,6 +262,18 @@ func main() {
 			auth.DecorateWithBasicAuth(faasHandlers.AsyncReport, credentials)
 		faasHandlers.SecretHandler =
 			auth.DecorateWithBasicAuth(faasHandlers.SecretHandler, credentials)
+		faasHandlers.RealtimeStatus =
+			auth.DecorateWithBasicAuth(faasHandlers.RealtimeStatus, credentials)
+		faasHandlers.RealtimeRenegotiate =
+			auth.DecorateWithBasicAuth(faasHandlers.RealtimeRenegotiate, credentials)
+		faasHandlers.RealtimeRecommendation =
+			auth.DecorateWithBasicAuth(faasHandlers.RealtimeRecommendation, credentials)
+		faasHandlers.RealtimePools =
+			auth.DecorateWithBasicAuth(faasHandlers.RealtimePools, credentials)
+		faasHandlers.RealtimeLease =
+			auth.DecorateWithBasicAuth(faasHandlers.RealtimeLease, credentials)
+		faasHandlers.RealtimeEvents =
+			auth.DecorateWithBasicAuth(faasHandlers.RealtimeEvents, credentials)
 	}
 
 	r := mux.NewRouter()
@@ -195,7 +293,7 @@ func main() {
 		functionProxy = handlers.MakeScalingHandler(faasHandlers.Proxy, scalingConfig)
 	}
 
-	functionProxy = realtime.MakeRealtimeInvokeHandler(faasHandlers.Proxy)
+	functionProxy = realtime.MakeRealtimeInvokeHandler(realtime.MakeConcurrencyLimitHandler(faasHandlers.Proxy))
 
 	// r.StrictSlash(false)	// This didn't work, so register routes twice.
 	r.HandleFunc("/function/{name:[-a-zA-Z_0-9]+}", functionProxy)
@@ -212,6 +310,15 @@ func main() {
 	r.HandleFunc("/system/functions", faasHandlers.UpdateFunction).Methods(http.MethodPut)
 	r.HandleFunc("/system/scale-function/{name:[-a-zA-Z_0-9]+}", faasHandlers.ScaleFunction).Methods(http.MethodPost)
 
+	r.HandleFunc("/system/realtime", faasHandlers.RealtimeStatus).Methods(http.MethodGet)
+	r.HandleFunc("/system/realtime-pools", faasHandlers.RealtimePools).Methods(http.MethodGet)
+	r.HandleFunc("/system/realtime-events", faasHandlers.RealtimeEvents).Methods(http.MethodGet)
+	r.HandleFunc("/system/realtime-pools/{name:[-a-zA-Z_0-9]+}", faasHandlers.RealtimePools).Methods(http.MethodGet, http.MethodPut, http.MethodDelete)
+	r.HandleFunc("/system/realtime/{name:[-a-zA-Z_0-9]+}", faasHandlers.RealtimeStatus).Methods(http.MethodGet)
+	r.HandleFunc("/system/realtime/{name:[-a-zA-Z_0-9]+}", faasHandlers.RealtimeRenegotiate).Methods(http.MethodPatch)
+	r.HandleFunc("/system/realtime/{name:[-a-zA-Z_0-9]+}/recommendation", faasHandlers.RealtimeRecommendation).Methods(http.MethodGet)
+	r.HandleFunc("/system/realtime/{name:[-a-zA-Z_0-9]+}/lease", faasHandlers.RealtimeLease).Methods(http.MethodPost)
+
 	r.HandleFunc("/system/secrets", faasHandlers.SecretHandler).Methods(http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete)
 
 	if faasHandlers.QueuedProxy != nil {
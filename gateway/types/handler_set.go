@@ -29,4 +29,22 @@ type HandlerSet struct {
 
 	// SecretHandler allows secrets to be managed
 	SecretHandler http.HandlerFunc
+
+	// RealtimeStatus reports the reservations and queues of realtime functions
+	RealtimeStatus http.HandlerFunc
+
+	// RealtimeRenegotiate changes the guaranteed rate of a deployed function
+	RealtimeRenegotiate http.HandlerFunc
+
+	// RealtimeRecommendation compares the declared and observed size of a function
+	RealtimeRecommendation http.HandlerFunc
+
+	// RealtimePools lists, declares and removes reservation pools
+	RealtimePools http.HandlerFunc
+
+	// RealtimeLease renews the lease of a realtime function
+	RealtimeLease http.HandlerFunc
+
+	// RealtimeEvents lists the best-effort functions degraded and restored
+	RealtimeEvents http.HandlerFunc
 }
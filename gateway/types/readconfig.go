@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -137,6 +138,95 @@ func (ReadConfig) Read(hasEnv HasEnv) GatewayConfig {
 		}
 	}
 
+	cfg.RealtimeCPUCapacity = hasEnv.Getenv("realtime_cpu_capacity")
+	cfg.RealtimeMemoryCapacity = hasEnv.Getenv("realtime_memory_capacity")
+
+	cfg.RealtimeReconcileInterval = parseIntOrDurationValue(hasEnv.Getenv("realtime_reconcile_interval"), time.Second*30)
+
+	cfg.RealtimeSchedulability = "edf"
+	if schedulability := hasEnv.Getenv("realtime_schedulability"); len(schedulability) > 0 {
+		cfg.RealtimeSchedulability = schedulability
+	}
+
+	cfg.RealtimeAdmission = "reserve"
+	if admission := hasEnv.Getenv("realtime_admission"); len(admission) > 0 {
+		cfg.RealtimeAdmission = admission
+	}
+
+	cfg.RealtimeOverbookingRatio = 1.5
+	if ratio := hasEnv.Getenv("realtime_overbooking_ratio"); len(ratio) > 0 {
+		val, err := strconv.ParseFloat(ratio, 64)
+		if err != nil || val < 1 {
+			log.Println("Invalid value for realtime_overbooking_ratio")
+		} else {
+			cfg.RealtimeOverbookingRatio = val
+		}
+	}
+
+	cfg.RealtimeDemandPercentile = 0.99
+	if percentile := hasEnv.Getenv("realtime_demand_percentile"); len(percentile) > 0 {
+		val, err := strconv.ParseFloat(percentile, 64)
+		if err != nil || val <= 0 || val > 1 {
+			log.Println("Invalid value for realtime_demand_percentile")
+		} else {
+			cfg.RealtimeDemandPercentile = val
+		}
+	}
+	cfg.RealtimeDemandSizing = parseBoolValue(hasEnv.Getenv("realtime_demand_sizing"))
+	cfg.RealtimeBackfill = parseBoolValue(hasEnv.Getenv("realtime_backfill"))
+	cfg.RealtimeRightSize = parseBoolValue(hasEnv.Getenv("realtime_rightsize"))
+	cfg.RealtimeRightSizeFloor = 0.25
+	if floor := hasEnv.Getenv("realtime_rightsize_floor"); len(floor) > 0 {
+		val, err := strconv.ParseFloat(floor, 64)
+		if err != nil || val <= 0 || val > 1 {
+			log.Println("Invalid value for realtime_rightsize_floor")
+		} else {
+			cfg.RealtimeRightSizeFloor = val
+		}
+	}
+	cfg.RealtimeResizeInterval = parseIntOrDurationValue(hasEnv.Getenv("realtime_resize_interval"), time.Minute)
+	cfg.RealtimeLeaseInterval = parseIntOrDurationValue(hasEnv.Getenv("realtime_lease_interval"), time.Second*10)
+	cfg.RealtimeJournalDir = hasEnv.Getenv("realtime_journal_dir")
+
+	for _, name := range strings.Split(hasEnv.Getenv("realtime_degrade_order"), ",") {
+		if name = strings.TrimSpace(name); len(name) > 0 {
+			cfg.RealtimeDegradeOrder = append(cfg.RealtimeDegradeOrder, name)
+		}
+	}
+
+	if headroom := hasEnv.Getenv("realtime_warm_headroom"); len(headroom) > 0 {
+		val, err := strconv.ParseFloat(headroom, 64)
+		if err != nil || !(val >= 0 && val <= 1) {
+			log.Println("Invalid value for realtime_warm_headroom")
+		} else {
+			cfg.RealtimeWarmHeadroom = val
+		}
+	}
+	cfg.RealtimeWarmPath = "/_/health"
+	if path := hasEnv.Getenv("realtime_warm_path"); len(path) > 0 {
+		cfg.RealtimeWarmPath = path
+	}
+
+	cfg.RealtimeConcurrencyQueue = 100
+	if queue := hasEnv.Getenv("realtime_concurrency_queue"); len(queue) > 0 {
+		val, err := strconv.Atoi(queue)
+		if err != nil || val < 0 {
+			log.Println("Invalid value for realtime_concurrency_queue")
+		} else {
+			cfg.RealtimeConcurrencyQueue = val
+		}
+	}
+
+	cfg.RealtimeContainerConcurrency = 100
+	if concurrency := hasEnv.Getenv("realtime_container_concurrency"); len(concurrency) > 0 {
+		val, err := strconv.ParseUint(concurrency, 10, 32)
+		if err != nil {
+			log.Println("Invalid value for realtime_container_concurrency")
+		} else {
+			cfg.RealtimeContainerConcurrency = uint(val)
+		}
+	}
+
 	return cfg
 }
 
@@ -185,6 +275,86 @@ type GatewayConfig struct {
 	MaxIdleConns int
 
 	MaxIdleConnsPerHost int
+
+	// Total CPU allocatable to realtime reservations, i.e. "32" or "32000m".
+	// When empty the capacity is requested from the provider.
+	RealtimeCPUCapacity string
+
+	// Total memory allocatable to realtime reservations, i.e. "64Gi".
+	// When empty the capacity is requested from the provider.
+	RealtimeMemoryCapacity string
+
+	// RealtimeSchedulability selects the utilization bound checked when
+	// admitting realtime functions: "edf", "rm" or "none"
+	RealtimeSchedulability string
+
+	// RealtimeReconcileInterval is how often realtime handlers are rebuilt
+	// from the functions deployed on the provider
+	RealtimeReconcileInterval time.Duration
+
+	// RealtimeAdmission names the strategy admitting realtime functions:
+	// "reserve", "passthrough" or "overcommit"
+	RealtimeAdmission string
+
+	// RealtimeOverbookingRatio is how far the "overcommit" strategy lets
+	// reservations exceed the capacity
+	RealtimeOverbookingRatio float64
+
+	// RealtimeDemandPercentile is the percentile of the measured execution
+	// durations taken as the demand of a function
+	RealtimeDemandPercentile float64
+
+	// RealtimeDemandSizing sizes reservations and sandbox requests from the
+	// measured demand, limits stay at the declared timeout
+	RealtimeDemandSizing bool
+
+	// RealtimeBackfill lets best-effort functions of a reservation pool run
+	// on the dispatch slots left idle by the realtime functions of the pool
+	RealtimeBackfill bool
+
+	// RealtimeRightSize sizes reservations from the observed p99 duration
+	// and peak memory, bounded by the declared size
+	RealtimeRightSize bool
+
+	// RealtimeRightSizeFloor is the smallest share of the declared size a
+	// reservation is right-sized to
+	RealtimeRightSizeFloor float64
+
+	// RealtimeResizeInterval is how often reservations are resized for the
+	// measured demand
+	RealtimeResizeInterval time.Duration
+
+	// RealtimeLeaseInterval is how often expired leases are looked for
+	RealtimeLeaseInterval time.Duration
+
+	// RealtimeJournalDir is where deployments and updates in flight are
+	// journaled, and declared pools, lease renewals and degraded functions
+	// kept, they are only kept in memory when empty
+	RealtimeJournalDir string
+
+	// RealtimeContainerConcurrency is how many invocations a replica of a
+	// realtime function serves at once, the reservation is split among as
+	// many replicas as needed. Zero keeps every function on one replica.
+	RealtimeContainerConcurrency uint
+
+	// RealtimeConcurrencyQueue is how many invocations of a function may
+	// wait for a free slot once its replicas serve as many as they can,
+	// the gateway answers 429 beyond it
+	RealtimeConcurrencyQueue int
+
+	// RealtimeWarmHeadroom is the share of the replicas of every realtime
+	// function kept ready as spares and charged against the capacity, zero
+	// disables the warm pool
+	RealtimeWarmHeadroom float64
+
+	// RealtimeWarmPath is invoked on every replica of a realtime function
+	// once deployed so that its first invocations are served hot
+	RealtimeWarmPath string
+
+	// RealtimeDegradeOrder lists the best-effort functions scaled down to
+	// their minimum replicas, first to last, when the replicas of a realtime
+	// function cannot be made available. Empty disables degradation.
+	RealtimeDegradeOrder []string
 }
 
 // UseNATS Use NATSor not
@@ -299,3 +299,305 @@ func TestRead_MaxIdleConns_Override(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestRead_RealtimeCapacityDefaults(t *testing.T) {
+	defaults := NewEnvBucket()
+
+	readConfig := ReadConfig{}
+
+	config := readConfig.Read(defaults)
+
+	if len(config.RealtimeCPUCapacity) > 0 {
+		t.Logf("config.RealtimeCPUCapacity, want: %s, got: %s\n", "", config.RealtimeCPUCapacity)
+		t.Fail()
+	}
+
+	if len(config.RealtimeMemoryCapacity) > 0 {
+		t.Logf("config.RealtimeMemoryCapacity, want: %s, got: %s\n", "", config.RealtimeMemoryCapacity)
+		t.Fail()
+	}
+
+	if config.RealtimeSchedulability != "edf" {
+		t.Logf("config.RealtimeSchedulability, want: %s, got: %s\n", "edf", config.RealtimeSchedulability)
+		t.Fail()
+	}
+}
+
+func TestRead_RealtimeCapacity_Override(t *testing.T) {
+	defaults := NewEnvBucket()
+
+	readConfig := ReadConfig{}
+	defaults.Setenv("realtime_cpu_capacity", "16")
+	defaults.Setenv("realtime_memory_capacity", "32Gi")
+	defaults.Setenv("realtime_schedulability", "rm")
+
+	config := readConfig.Read(defaults)
+
+	if config.RealtimeCPUCapacity != "16" {
+		t.Logf("config.RealtimeCPUCapacity, want: %s, got: %s\n", "16", config.RealtimeCPUCapacity)
+		t.Fail()
+	}
+
+	if config.RealtimeMemoryCapacity != "32Gi" {
+		t.Logf("config.RealtimeMemoryCapacity, want: %s, got: %s\n", "32Gi", config.RealtimeMemoryCapacity)
+		t.Fail()
+	}
+
+	if config.RealtimeSchedulability != "rm" {
+		t.Logf("config.RealtimeSchedulability, want: %s, got: %s\n", "rm", config.RealtimeSchedulability)
+		t.Fail()
+	}
+}
+
+func TestRead_RealtimeReconcileInterval(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := ReadConfig{}
+
+	config := readConfig.Read(defaults)
+	if config.RealtimeReconcileInterval != time.Second*30 {
+		t.Logf("RealtimeReconcileInterval want: %s, got %s", time.Second*30, config.RealtimeReconcileInterval)
+		t.Fail()
+	}
+
+	defaults.Setenv("realtime_reconcile_interval", "1m")
+	config = readConfig.Read(defaults)
+	if config.RealtimeReconcileInterval != time.Minute {
+		t.Logf("RealtimeReconcileInterval want: %s, got %s", time.Minute, config.RealtimeReconcileInterval)
+		t.Fail()
+	}
+}
+
+func TestRead_RealtimeAdmission(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := ReadConfig{}
+
+	config := readConfig.Read(defaults)
+	if config.RealtimeAdmission != "reserve" {
+		t.Logf("RealtimeAdmission want: %s, got %s", "reserve", config.RealtimeAdmission)
+		t.Fail()
+	}
+	if config.RealtimeOverbookingRatio != 1.5 {
+		t.Logf("RealtimeOverbookingRatio want: %v, got %v", 1.5, config.RealtimeOverbookingRatio)
+		t.Fail()
+	}
+
+	defaults.Setenv("realtime_admission", "overcommit")
+	defaults.Setenv("realtime_overbooking_ratio", "2")
+	config = readConfig.Read(defaults)
+	if config.RealtimeAdmission != "overcommit" {
+		t.Logf("RealtimeAdmission want: %s, got %s", "overcommit", config.RealtimeAdmission)
+		t.Fail()
+	}
+	if config.RealtimeOverbookingRatio != 2 {
+		t.Logf("RealtimeOverbookingRatio want: %v, got %v", 2, config.RealtimeOverbookingRatio)
+		t.Fail()
+	}
+
+	defaults.Setenv("realtime_overbooking_ratio", "0.5")
+	config = readConfig.Read(defaults)
+	if config.RealtimeOverbookingRatio != 1.5 {
+		t.Logf("RealtimeOverbookingRatio want: %v, got %v", 1.5, config.RealtimeOverbookingRatio)
+		t.Fail()
+	}
+}
+
+func TestRead_RealtimeDemand(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := ReadConfig{}
+
+	config := readConfig.Read(defaults)
+	if config.RealtimeDemandPercentile != 0.99 || config.RealtimeDemandSizing || config.RealtimeResizeInterval != time.Minute {
+		t.Logf("RealtimeDemand want: %v %v %s, got %v %v %s", 0.99, false, time.Minute,
+			config.RealtimeDemandPercentile, config.RealtimeDemandSizing, config.RealtimeResizeInterval)
+		t.Fail()
+	}
+
+	defaults.Setenv("realtime_demand_percentile", "0.95")
+	defaults.Setenv("realtime_demand_sizing", "true")
+	defaults.Setenv("realtime_resize_interval", "30s")
+	config = readConfig.Read(defaults)
+	if config.RealtimeDemandPercentile != 0.95 || !config.RealtimeDemandSizing || config.RealtimeResizeInterval != 30*time.Second {
+		t.Logf("RealtimeDemand want: %v %v %s, got %v %v %s", 0.95, true, 30*time.Second,
+			config.RealtimeDemandPercentile, config.RealtimeDemandSizing, config.RealtimeResizeInterval)
+		t.Fail()
+	}
+
+	defaults.Setenv("realtime_demand_percentile", "95")
+	config = readConfig.Read(defaults)
+	if config.RealtimeDemandPercentile != 0.99 {
+		t.Logf("RealtimeDemandPercentile want: %v, got %v", 0.99, config.RealtimeDemandPercentile)
+		t.Fail()
+	}
+}
+
+func TestRead_RealtimeLeaseInterval(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := ReadConfig{}
+
+	config := readConfig.Read(defaults)
+	if config.RealtimeLeaseInterval != 10*time.Second {
+		t.Logf("RealtimeLeaseInterval want: %s, got %s", 10*time.Second, config.RealtimeLeaseInterval)
+		t.Fail()
+	}
+
+	defaults.Setenv("realtime_lease_interval", "1m")
+	config = readConfig.Read(defaults)
+	if config.RealtimeLeaseInterval != time.Minute {
+		t.Logf("RealtimeLeaseInterval want: %s, got %s", time.Minute, config.RealtimeLeaseInterval)
+		t.Fail()
+	}
+}
+
+func TestRead_RealtimeJournalDir(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := ReadConfig{}
+
+	config := readConfig.Read(defaults)
+	if config.RealtimeJournalDir != "" {
+		t.Logf("RealtimeJournalDir want: %q, got %q", "", config.RealtimeJournalDir)
+		t.Fail()
+	}
+
+	defaults.Setenv("realtime_journal_dir", "/var/lib/gateway/journal")
+	config = readConfig.Read(defaults)
+	if config.RealtimeJournalDir != "/var/lib/gateway/journal" {
+		t.Logf("RealtimeJournalDir want: %q, got %q", "/var/lib/gateway/journal", config.RealtimeJournalDir)
+		t.Fail()
+	}
+}
+
+func TestRead_RealtimeContainerConcurrency(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := ReadConfig{}
+
+	config := readConfig.Read(defaults)
+	if config.RealtimeContainerConcurrency != 100 {
+		t.Logf("RealtimeContainerConcurrency want: %d, got %d", 100, config.RealtimeContainerConcurrency)
+		t.Fail()
+	}
+
+	defaults.Setenv("realtime_container_concurrency", "8")
+	config = readConfig.Read(defaults)
+	if config.RealtimeContainerConcurrency != 8 {
+		t.Logf("RealtimeContainerConcurrency want: %d, got %d", 8, config.RealtimeContainerConcurrency)
+		t.Fail()
+	}
+
+	defaults.Setenv("realtime_container_concurrency", "many")
+	config = readConfig.Read(defaults)
+	if config.RealtimeContainerConcurrency != 100 {
+		t.Logf("RealtimeContainerConcurrency want: %d, got %d", 100, config.RealtimeContainerConcurrency)
+		t.Fail()
+	}
+}
+
+func TestRead_RealtimeDegradeOrder(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := ReadConfig{}
+
+	config := readConfig.Read(defaults)
+	if len(config.RealtimeDegradeOrder) != 0 {
+		t.Logf("RealtimeDegradeOrder want: empty, got %v", config.RealtimeDegradeOrder)
+		t.Fail()
+	}
+
+	defaults.Setenv("realtime_degrade_order", "batch, thumbnails,,reports")
+	config = readConfig.Read(defaults)
+	want := []string{"batch", "thumbnails", "reports"}
+	if len(config.RealtimeDegradeOrder) != len(want) {
+		t.Logf("RealtimeDegradeOrder want: %v, got %v", want, config.RealtimeDegradeOrder)
+		t.FailNow()
+	}
+	for i := range want {
+		if config.RealtimeDegradeOrder[i] != want[i] {
+			t.Logf("RealtimeDegradeOrder want: %v, got %v", want, config.RealtimeDegradeOrder)
+			t.Fail()
+		}
+	}
+}
+
+func TestRead_RealtimeWarmPool(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := ReadConfig{}
+
+	config := readConfig.Read(defaults)
+	if config.RealtimeWarmHeadroom != 0 || config.RealtimeWarmPath != "/_/health" {
+		t.Logf("RealtimeWarmHeadroom want: %f %s, got %f %s", 0.0, "/_/health", config.RealtimeWarmHeadroom, config.RealtimeWarmPath)
+		t.Fail()
+	}
+
+	defaults.Setenv("realtime_warm_headroom", "0.25")
+	defaults.Setenv("realtime_warm_path", "/_/ready")
+	config = readConfig.Read(defaults)
+	if config.RealtimeWarmHeadroom != 0.25 || config.RealtimeWarmPath != "/_/ready" {
+		t.Logf("RealtimeWarmHeadroom want: %f %s, got %f %s", 0.25, "/_/ready", config.RealtimeWarmHeadroom, config.RealtimeWarmPath)
+		t.Fail()
+	}
+
+	defaults.Setenv("realtime_warm_headroom", "NaN")
+	config = readConfig.Read(defaults)
+	if config.RealtimeWarmHeadroom != 0 {
+		t.Logf("RealtimeWarmHeadroom want: %f, got %f", 0.0, config.RealtimeWarmHeadroom)
+		t.Fail()
+	}
+}
+
+func TestRead_RealtimeConcurrencyQueue(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := ReadConfig{}
+
+	config := readConfig.Read(defaults)
+	if config.RealtimeConcurrencyQueue != 100 {
+		t.Logf("RealtimeConcurrencyQueue want: %d, got %d", 100, config.RealtimeConcurrencyQueue)
+		t.Fail()
+	}
+
+	defaults.Setenv("realtime_concurrency_queue", "0")
+	config = readConfig.Read(defaults)
+	if config.RealtimeConcurrencyQueue != 0 {
+		t.Logf("RealtimeConcurrencyQueue want: %d, got %d", 0, config.RealtimeConcurrencyQueue)
+		t.Fail()
+	}
+
+	defaults.Setenv("realtime_concurrency_queue", "-5")
+	config = readConfig.Read(defaults)
+	if config.RealtimeConcurrencyQueue != 100 {
+		t.Logf("RealtimeConcurrencyQueue want: %d, got %d", 100, config.RealtimeConcurrencyQueue)
+		t.Fail()
+	}
+}
+
+func TestRead_RealtimeRightSize(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := ReadConfig{}
+
+	config := readConfig.Read(defaults)
+	if config.RealtimeRightSize || config.RealtimeRightSizeFloor != 0.25 {
+		t.Logf("RealtimeRightSize want: %v %v, got %v %v", false, 0.25, config.RealtimeRightSize, config.RealtimeRightSizeFloor)
+		t.Fail()
+	}
+
+	defaults.Setenv("realtime_rightsize", "true")
+	defaults.Setenv("realtime_rightsize_floor", "0.5")
+	config = readConfig.Read(defaults)
+	if !config.RealtimeRightSize || config.RealtimeRightSizeFloor != 0.5 {
+		t.Logf("RealtimeRightSize want: %v %v, got %v %v", true, 0.5, config.RealtimeRightSize, config.RealtimeRightSizeFloor)
+		t.Fail()
+	}
+}
+
+func TestRead_RealtimeBackfill(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := ReadConfig{}
+
+	if config := readConfig.Read(defaults); config.RealtimeBackfill {
+		t.Logf("RealtimeBackfill want: %v, got %v", false, config.RealtimeBackfill)
+		t.Fail()
+	}
+
+	defaults.Setenv("realtime_backfill", "true")
+	if config := readConfig.Read(defaults); !config.RealtimeBackfill {
+		t.Logf("RealtimeBackfill want: %v, got %v", true, config.RealtimeBackfill)
+		t.Fail()
+	}
+}
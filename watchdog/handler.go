@@ -199,6 +199,9 @@ func pipeRequest(config *WatchdogConfig, w http.ResponseWriter, r *http.Request,
 	execDuration := time.Since(startTime).Seconds()
 	if ri.headerWritten == false {
 		w.Header().Set("X-Duration-Seconds", fmt.Sprintf("%f", execDuration))
+		if memory, ok := peakMemory(targetCmd.ProcessState); ok {
+			w.Header().Set("X-Peak-Memory-Bytes", fmt.Sprintf("%d", memory))
+		}
 		ri.headerWritten = true
 		w.WriteHeader(200)
 		w.Write(out)
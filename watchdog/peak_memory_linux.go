@@ -0,0 +1,19 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// peakMemory returns the maximum resident set size of the exited process in bytes
+func peakMemory(state *os.ProcessState) (int64, bool) {
+	if state == nil {
+		return 0, false
+	}
+	usage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0, false
+	}
+	// Maxrss is reported in kilobytes on Linux
+	return usage.Maxrss * 1024, true
+}
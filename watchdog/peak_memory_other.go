@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "os"
+
+// peakMemory is only reported on Linux
+func peakMemory(state *os.ProcessState) (int64, bool) {
+	return 0, false
+}
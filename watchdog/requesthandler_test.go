@@ -12,6 +12,8 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -68,6 +70,29 @@ func TestHandler_HasCustomHeaderInFunction_WithCgi_Mode(t *testing.T) {
 	}
 }
 
+func TestHandler_ReportsPeakMemory(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peak memory is only reported on Linux")
+	}
+	rr := httptest.NewRecorder()
+
+	req, err := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := WatchdogConfig{
+		faasProcess: "cat",
+	}
+	handler := makeRequestHandler(&config)
+	handler(rr, req)
+
+	memory, err := strconv.ParseInt(rr.Header().Get("X-Peak-Memory-Bytes"), 10, 64)
+	if err != nil || memory <= 0 {
+		t.Errorf("cat should have given its peak memory as an X-Peak-Memory-Bytes header, got: %s\n", rr.Header().Get("X-Peak-Memory-Bytes"))
+	}
+}
+
 func TestHandler_HasCustomHeaderInFunction_WithCgiMode_AndBody(t *testing.T) {
 	rr := httptest.NewRecorder()
 